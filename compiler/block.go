@@ -54,6 +54,17 @@ func (b *Block) SearchDeclaration(name string) *Declaration {
 	return nil
 }
 
+// 只在当前块中查找
+func (b *Block) searchLocalDeclaration(name string) *Declaration {
+	for _, decl := range b.declarationList {
+		if decl.Name == name {
+			return decl
+		}
+	}
+
+	return nil
+}
+
 func (b *Block) Fix() {
 	for _, statement := range b.statementList {
 		statement.Fix()
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"embed"
+	"path"
+	"strings"
+)
+
+//
+// 内置包, 源码随编译器一起发布, 其中的原生函数由虚拟机实现
+//
+//go:embed lib
+var builtinLib embed.FS
+
+// 内置包源文件的路径前缀, 报错时用于显示
+const builtinPathPrefix = "<builtin>/"
+
+// 内置包的源文件列表, 不是内置包时返回nil
+func searchBuiltinSourceFileList(importPath string) []string {
+	entryList, err := builtinLib.ReadDir(path.Join("lib", importPath))
+	if err != nil {
+		return nil
+	}
+
+	pathList := []string{}
+
+	for _, entry := range entryList {
+		if entry.IsDir() || path.Ext(entry.Name()) != importSuffix {
+			continue
+		}
+		pathList = append(pathList, builtinPathPrefix+path.Join(importPath, entry.Name()))
+	}
+
+	if len(pathList) == 0 {
+		return nil
+	}
+
+	return pathList
+}
+
+func isBuiltinPath(filePath string) bool {
+	return strings.HasPrefix(filePath, builtinPathPrefix)
+}
+
+// 读取内置包的源文件
+func readBuiltinFile(filePath string) ([]byte, error) {
+	return builtinLib.ReadFile(path.Join("lib", strings.TrimPrefix(filePath, builtinPathPrefix)))
+}
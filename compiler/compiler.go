@@ -1,36 +1,50 @@
 package compiler
 
 import (
-	"github.com/lth-go/gogo/utils"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/lth-go/gogo/vm"
 )
 
 type Compiler struct {
-	doingList []*Package
-	doneList  []*Package
+	doingList      []*Package
+	doneList       []*Package
+	packageNameMap map[string]string // 包目录的绝对路径对应的包标识
 
 	FuncList        []*FunctionDefinition // 函数列表
 	TypeDefList     []*TypeDefDecl        // 类型声明列表
 	DeclarationList []*Declaration        // 声明列表
 	ConstantList    []interface{}         // 常量定义
+	constantIndex   map[interface{}]int   // 常量在常量池中的下标, 用于去重
 
-	CodeList []byte
+	CodeList       []byte
+	LineNumberList []*vm.LineNumber // 顶层代码的行号表
 }
 
 func (cm *Compiler) AddConstant(value interface{}) int {
-	for i, v := range cm.ConstantList {
-		if value == v {
-			return i
-		}
+	if index, ok := cm.constantIndex[value]; ok {
+		return index
+	}
+
+	if cm.constantIndex == nil {
+		cm.constantIndex = map[interface{}]int{}
 	}
 
 	cm.ConstantList = append(cm.ConstantList, value)
+	cm.constantIndex[value] = len(cm.ConstantList) - 1
 
 	return len(cm.ConstantList) - 1
 }
 
 func (cm *Compiler) SearchFunction(packageName string, name string) (*FunctionDefinition, int) {
 	for i, f := range cm.FuncList {
+		// init函数只在包初始化时调用, 不能被引用
+		if f.Name == "init" && f.PackageName != "_sys" {
+			continue
+		}
+
 		if f.PackageName == packageName && f.Name == name {
 			return f, i
 		}
@@ -53,12 +67,30 @@ func (cm *Compiler) SearchDeclaration(packageName string, name string) *Declarat
 	return nil
 }
 
+// 解析阶段即可查找, 因此从各个包中查找
+func (cm *Compiler) SearchTypeDef(packageName string, name string) *TypeDefDecl {
+	for _, pkg := range cm.doneList {
+		if pkg.GetPackageName() != packageName {
+			continue
+		}
+
+		for _, decl := range pkg.typeDefList {
+			if decl.Name == name {
+				return decl
+			}
+		}
+	}
+
+	return nil
+}
+
 var compilerManager *Compiler
 
 func NewCompilerManager() *Compiler {
 	compilerManager = &Compiler{
-		doingList: []*Package{},
-		doneList:  []*Package{},
+		doingList:      []*Package{},
+		doneList:       []*Package{},
+		packageNameMap: map[string]string{},
 	}
 
 	return compilerManager
@@ -96,6 +128,18 @@ func IsCompiling(packageName string) bool {
 	return false
 }
 
+// 记录包目录对应的包标识, 目录已经记录过时返回之前的标识
+// 同一个目录以不同的写法导入时, 只会解析和初始化一次
+func (cm *Compiler) registerPackage(dir string, packageName string) string {
+	if name, ok := cm.packageNameMap[dir]; ok {
+		return name
+	}
+
+	cm.packageNameMap[dir] = packageName
+
+	return packageName
+}
+
 func (cm *Compiler) AddDoneCompilerList(c *Package) {
 	cm.doneList = append(cm.doneList, c)
 }
@@ -109,8 +153,8 @@ func (cm *Compiler) GetDoneCompiler(packageName string) *Package {
 	return nil
 }
 
-func (cm *Compiler) Parse(path string) {
-	c := NewPackage(path)
+func (cm *Compiler) Parse(packageName string, pathList []string) {
+	c := NewPackage(packageName, pathList)
 
 	cm.PushCurrentCompiler(c)
 	cm.AddDoneCompilerList(c)
@@ -119,21 +163,52 @@ func (cm *Compiler) Parse(path string) {
 	c.Parse()
 
 	for _, imp := range c.importList {
-		if IsCompiling(imp.packageName) {
-			panic("TODO")
+		if IsCompiling(imp.GetPackageName()) {
+			cm.importCycleError(imp)
 		}
 
 		// 判断是否已经被解析过
-		if cm.GetDoneCompiler(imp.packageName) != nil {
+		if cm.GetDoneCompiler(imp.GetPackageName()) != nil {
 			continue
 		}
 
-		cm.Parse(imp.GetPath())
+		cm.Parse(imp.GetPackageName(), imp.GetPathList())
 	}
 
 	cm.PopCurrentCompiler()
 }
 
+// 循环导入, 列出导入链以及链上每一处import的位置
+func (cm *Compiler) importCycleError(imp *Import) {
+	start := 0
+	for i, pkg := range cm.doingList {
+		if pkg.GetPackageName() == imp.GetPackageName() {
+			start = i
+			break
+		}
+	}
+	chain := cm.doingList[start:]
+
+	nameList := []string{}
+	importList := []string{}
+
+	for i, pkg := range chain {
+		next := imp
+		if i+1 < len(chain) {
+			next = pkg.searchImport(chain[i+1].GetPackageName())
+		}
+
+		nameList = append(nameList, pkg.GetPackageName())
+		importList = append(importList, fmt.Sprintf(
+			"\t%s:%d:%d: import \"%s\"",
+			next.filename, next.Position().Line, next.Position().Column, next.path,
+		))
+	}
+	nameList = append(nameList, imp.GetPackageName())
+
+	compileError(imp.Position(), IMPORT_CYCLE_ERR, strings.Join(nameList, " -> "), strings.Join(importList, "\n"))
+}
+
 func (c *Compiler) Fix() {
 	// 添加原生函数声明
 	c.AddNativeFunctionList()
@@ -147,91 +222,107 @@ func (c *Compiler) Fix() {
 			decl.Index = index
 		}
 
-		// 添加并修正全局声明
+		// 添加全局声明
 		c.DeclarationList = append(c.DeclarationList, pkg.declarationList...)
 		for index, decl := range c.DeclarationList {
 			decl.Index = index
-			decl.Value = decl.Value.Fix()
-			decl.Value = CreateAssignCast(decl.Value, decl.Type)
 		}
 
 		// 添加函数
 		c.FuncList = append(c.FuncList, pkg.funcList...)
 	}
 
-	for _, f := range c.FuncList {
-		// 将函数所在的compiler压栈
-		for _, pkg := range c.doneList {
-			if pkg.GetPackageName() == f.PackageName {
-				c.PushCurrentCompiler(pkg)
+	// 修正全局声明, 初始值可以引用任意的函数和全局变量
+	for i := len(c.doneList) - 1; i >= 0; i-- {
+		pkg := c.doneList[i]
+
+		c.PushCurrentCompiler(pkg)
+
+		for _, decl := range pkg.declarationList {
+			// 用于报错时显示声明所在的源文件
+			pkg.path = decl.Path
+
+			decl.Type.Fix()
+
+			if decl.Value == nil {
+				decl.Value = GetTypeDefaultValue(decl.Type, decl.Position())
 			}
+			decl.Value = decl.Value.Fix()
+			decl.Value = CreateAssignCast(decl.Value, decl.Type)
 		}
 
+		c.PopCurrentCompiler()
+	}
+
+	for _, f := range c.FuncList {
+		// 将函数所在的包压栈
+		pkg := c.pushFunctionPackage(f)
+
 		f.Fix()
+
+		if pkg != nil {
+			c.PopCurrentCompiler()
+		}
 	}
 }
 
+// 将函数所在的包压栈, 原生函数不属于任何包, 返回nil
+func (c *Compiler) pushFunctionPackage(f *FunctionDefinition) *Package {
+	pkg := c.GetDoneCompiler(f.PackageName)
+	if pkg == nil {
+		return nil
+	}
+
+	c.PushCurrentCompiler(pkg)
+	// 用于报错时显示函数所在的源文件
+	pkg.path = f.Path
+
+	return pkg
+}
+
 func (c *Compiler) Compile() {
 	//
 	// 函数生成字节码,并修正字节码
 	//
 	for _, f := range c.FuncList {
-		if f.Block == nil {
+		if f.Block == nil || f.IsGeneric() {
 			continue
 		}
 
+		pkg := c.pushFunctionPackage(f)
+
 		ob := NewOpCodeBuf()
 		for _, stmt := range f.Block.statementList {
 			stmt.Generate(ob)
 		}
 
+		// 修正本地变量在栈上的位置
+		ob.FixStackIndex(len(f.GetType().funcType.Params))
+
 		//
 		// 修正Label
 		//
 		codeList := ob.FixLabel()
 
-		//
-		// 修正opCode
-		// TODO: 去掉代码
-		//
-		paramCount := len(f.GetType().funcType.Params)
-
-		for i := 0; i < len(codeList); i++ {
-			code := codeList[i]
-			switch code {
-			// 函数内的本地声明
-			case vm.OP_CODE_PUSH_STACK, vm.OP_CODE_POP_STACK:
-				// 形参
-				// 返回值(新增)
-				// 声明
-
-				// 增加返回值的位置
-				idx := utils.Get2ByteInt(codeList[i+1:])
-				if idx >= paramCount {
-					utils.Set2ByteInt(codeList[i+1:], idx+1)
-				} else {
-					utils.Set2ByteInt(codeList[i+1:], idx-paramCount)
-				}
-			}
+		// 操作数栈的最大深度, 调用时据此扩展栈
+		maxDepth, err := vm.MaxStackDepth(codeList)
+		if err != nil {
+			compileError(f.GetType().Position(), STACK_DEPTH_ERR, f.Name, err)
+		}
 
-			for _, p := range []byte(vm.OpcodeInfo[code].Parameter) {
-				switch p {
-				case 'b':
-					i++
-				case 's', 'p':
-					i += 2
-				default:
-					panic("TODO")
-				}
-			}
+		if pkg != nil {
+			c.PopCurrentCompiler()
 		}
 
 		f.CodeList = codeList
+		f.LineNumberList = ob.lineNumberList
+		f.MaxStackDepth = maxDepth
 	}
 
 	c.SetCodeList()
 }
 
+// 顶层代码: 按依赖顺序初始化各个包的全局变量, 调用init函数, 最后调用main函数
 func (c *Compiler) SetCodeList() {
 	mainFunc := -1
 
@@ -245,10 +336,15 @@ func (c *Compiler) SetCodeList() {
 		panic("TODO")
 	}
 
-	b := make([]byte, 2)
-	utils.Set2ByteInt(b, mainFunc)
-	c.CodeList = append(c.CodeList, b...)
-	c.CodeList = append(c.CodeList, vm.OP_CODE_INVOKE)
+	ob := NewOpCodeBuf()
+
+	c.GenerateInitCode(ob)
+
+	ob.GenerateCode(Position{}, vm.OP_CODE_PUSH_FUNCTION, mainFunc)
+	ob.GenerateCode(Position{}, vm.OP_CODE_INVOKE, 0)
+
+	c.CodeList = ob.FixLabel()
+	c.LineNumberList = ob.lineNumberList
 }
 
 //
@@ -260,7 +356,14 @@ func (c *Compiler) CompileFile(path string) {
 		yyErrorVerbose = true
 	}
 
-	c.Parse(path)
+	// 入口可以是源文件, 也可以是包目录
+	pathList := []string{path}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		pathList = GetSourceFileList(path)
+	}
+
+	c.registerPackage(packageDir(path), "main")
+	c.Parse("main", pathList)
 
 	c.Fix()
 
@@ -37,6 +37,15 @@ var operatorCodeMap = map[BinaryOperatorKind]byte{
 	DivOperator: vm.OP_CODE_DIV_INT,
 }
 
+// 无符号整数与有符号整数结果不同的操作
+var unsignedOperatorCodeMap = map[BinaryOperatorKind]byte{
+	GtOperator:  vm.OP_CODE_GT_UINT,
+	GeOperator:  vm.OP_CODE_GE_UINT,
+	LtOperator:  vm.OP_CODE_LT_UINT,
+	LeOperator:  vm.OP_CODE_LE_UINT,
+	DivOperator: vm.OP_CODE_DIV_UINT,
+}
+
 type UnaryOperatorKind int
 
 const (
@@ -54,5 +63,49 @@ const (
 	CastTypeBoolToString
 	CastTypeFloatToString
 	CastTypeIntToFloat
+	CastTypeUintToFloat
 	CastTypeFloatToInt
+	CastTypeFloatToUint
+	CastTypeFloatToFloat32
+	CastTypeIntToInt8
+	CastTypeIntToInt16
+	CastTypeIntToInt32
+	CastTypeIntToUint8
+	CastTypeIntToUint16
+	CastTypeIntToUint32
+	CastTypeUintToInterface
+	CastTypeStringToBytes
+	CastTypeStringToRunes
+	CastTypeBytesToString
+	CastTypeRunesToString
 )
+
+var castCodeMap = map[CastType]byte{
+	CastTypeIntToString:     vm.OP_CODE_CAST_INT_TO_STRING,
+	CastTypeIntToFloat:      vm.OP_CODE_CAST_INT_TO_FLOAT,
+	CastTypeUintToFloat:     vm.OP_CODE_CAST_UINT_TO_FLOAT,
+	CastTypeFloatToInt:      vm.OP_CODE_CAST_FLOAT_TO_INT,
+	CastTypeFloatToUint:     vm.OP_CODE_CAST_FLOAT_TO_UINT,
+	CastTypeFloatToFloat32:  vm.OP_CODE_CAST_FLOAT_TO_FLOAT32,
+	CastTypeIntToInt8:       vm.OP_CODE_CAST_INT_TO_INT8,
+	CastTypeIntToInt16:      vm.OP_CODE_CAST_INT_TO_INT16,
+	CastTypeIntToInt32:      vm.OP_CODE_CAST_INT_TO_INT32,
+	CastTypeIntToUint8:      vm.OP_CODE_CAST_INT_TO_UINT8,
+	CastTypeIntToUint16:     vm.OP_CODE_CAST_INT_TO_UINT16,
+	CastTypeIntToUint32:     vm.OP_CODE_CAST_INT_TO_UINT32,
+	CastTypeUintToInterface: vm.OP_CODE_CAST_UINT_TO_INTERFACE,
+	CastTypeStringToBytes:   vm.OP_CODE_CAST_STRING_TO_BYTES,
+	CastTypeStringToRunes:   vm.OP_CODE_CAST_STRING_TO_RUNES,
+	CastTypeBytesToString:   vm.OP_CODE_CAST_BYTES_TO_STRING,
+	CastTypeRunesToString:   vm.OP_CODE_CAST_RUNES_TO_STRING,
+}
+
+// 64位以下的整数, 运算后需要截断到对应宽度
+var truncateCastTypeMap = map[BasicType]CastType{
+	BasicTypeInt8:   CastTypeIntToInt8,
+	BasicTypeInt16:  CastTypeIntToInt16,
+	BasicTypeInt32:  CastTypeIntToInt32,
+	BasicTypeUint8:  CastTypeIntToUint8,
+	BasicTypeUint16: CastTypeIntToUint16,
+	BasicTypeUint32: CastTypeIntToUint32,
+}
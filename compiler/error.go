@@ -2,7 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"log"
 )
 
 func compileError(pos Position, errorNumber int, a ...interface{}) {
@@ -12,7 +11,6 @@ func compileError(pos Position, errorNumber int, a ...interface{}) {
 	errMsg := fmt.Sprintf(errMessageMap[errorNumber], a...)
 	msg := fmt.Sprintf("%d\n%s", errorNumber, errMsg)
 	panic(msg)
-	log.Fatalf(msg)
 }
 
 const (
@@ -59,6 +57,33 @@ const (
 	BAD_PARAMETER_TYPE_ERR
 	BAD_RETURN_TYPE_ERR
 	TYPE_NAME_NOT_FOUND_ERR
+	ASSIGNMENT_COUNT_MISMATCH_ERR
+	NO_NEW_VARIABLE_ERR
+	USE_OF_UNTYPED_NIL_ERR
+	CONSTANT_OVERFLOW_ERR
+	STRING_INDEX_ASSIGN_ERR
+	RANGE_TYPE_ERR
+	RANGE_VARIABLE_COUNT_ERR
+	NUMBER_LITERAL_OUT_OF_RANGE_ERR
+	INVALID_NUMBER_LITERAL_ERR
+	CONSTANT_TRUNCATED_ERR
+	TYPE_ARGUMENT_COUNT_MISMATCH_ERR
+	TYPE_CONSTRAINT_UNSATISFIED_ERR
+	CANNOT_INFER_TYPE_ARGUMENT_ERR
+	TYPE_ARGUMENT_CONFLICT_ERR
+	GENERIC_NOT_INSTANTIATED_ERR
+	IMPORT_CYCLE_ERR
+	PACKAGE_NAME_MISMATCH_ERR
+	UNEXPORTED_NAME_ERR
+	INITIALIZATION_CYCLE_ERR
+	INIT_FUNCTION_SIGNATURE_ERR
+	TYPE_NOT_COMPARABLE_ERR
+	COMPARE_NIL_ONLY_ERR
+	TYPE_NOT_ORDERED_ERR
+	MAP_KEY_NOT_COMPARABLE_ERR
+	OPERAND_OVERFLOW_ERR
+	STACK_DEPTH_ERR
+	READ_FILE_ERR
 )
 
 var errMessageMap map[int]string = map[int]string{
@@ -86,8 +111,8 @@ var errMessageMap map[int]string = map[int]string{
 	ARRAY_SIZE_NOT_INT_ERR:           "数组的大小不是int。",
 	DIVISION_BY_ZERO_IN_COMPILE_ERR:  "整数值不能被0除。",
 	PACKAGE_NAME_TOO_LONG_ERR:        "package名称过长",
-	REQUIRE_FILE_NOT_FOUND_ERR:       "被import的文件不存在($(file))",
-	REQUIRE_DUPLICATE_ERR:            "源文件中重复import了包($(package_name))。",
+	REQUIRE_FILE_NOT_FOUND_ERR:       "被import的文件不存在(%s)",
+	REQUIRE_DUPLICATE_ERR:            "包名%s重复, 同时指向了\"%s\"和\"%s\"。",
 	MEMBER_EXPRESSION_TYPE_ERR:       "该类型不能使用成员运算符。",
 	RETURN_IN_VOID_FUNCTION_ERR:      "void类型的函数不能有返回值。",
 	CLASS_NOT_FOUND_ERR:              "没有找到类$(name)。",
@@ -104,5 +129,32 @@ var errMessageMap map[int]string = map[int]string{
 	BAD_PARAMETER_COUNT_ERR:          "方法或函数$(name)的参数数量错误。",
 	BAD_PARAMETER_TYPE_ERR:           "方法或函数$(func_name)的第$(index)个参数, $(param_name)的类型错误。",
 	BAD_RETURN_TYPE_ERR:              "方法或函数$(name)的返回值类型错误。",
-	TYPE_NAME_NOT_FOUND_ERR:          "找不到类型名$(name)。",
+	TYPE_NAME_NOT_FOUND_ERR:          "找不到类型名%s。",
+	ASSIGNMENT_COUNT_MISMATCH_ERR:    "赋值语句左右两边的数量不一致, 左边%d个, 右边%d个。",
+	NO_NEW_VARIABLE_ERR:              ":=左边没有新的变量。",
+	USE_OF_UNTYPED_NIL_ERR:           "不能使用无类型的nil声明变量。",
+	CONSTANT_OVERFLOW_ERR:            "常量%v超出了%s类型的范围。",
+	STRING_INDEX_ASSIGN_ERR:          "字符串不可修改, 不能对字符串的下标赋值。",
+	RANGE_TYPE_ERR:                   "不能对%s类型使用range。",
+	RANGE_VARIABLE_COUNT_ERR:         "range最多只能有两个迭代变量。",
+	NUMBER_LITERAL_OUT_OF_RANGE_ERR:  "数字常量%s超出了范围。",
+	INVALID_NUMBER_LITERAL_ERR:       "不正确的数字常量%s。",
+	CONSTANT_TRUNCATED_ERR:           "常量%v转为%s类型时会被截断。",
+	TYPE_ARGUMENT_COUNT_MISMATCH_ERR: "%s需要%d个类型参数, 实际为%d个。",
+	TYPE_CONSTRAINT_UNSATISFIED_ERR:  "类型%s不满足类型参数%s的约束%s。",
+	CANNOT_INFER_TYPE_ARGUMENT_ERR:   "无法推导出函数%s的类型参数%s。",
+	TYPE_ARGUMENT_CONFLICT_ERR:       "类型参数%s推导出了不同的类型%s和%s。",
+	GENERIC_NOT_INSTANTIATED_ERR:     "泛型函数%s未实例化, 不能作为值使用。",
+	IMPORT_CYCLE_ERR:                 "不允许循环导入: %s\n%s",
+	PACKAGE_NAME_MISMATCH_ERR:        "包名%s与同一目录下其它文件的包名%s不一致。",
+	UNEXPORTED_NAME_ERR:              "%s未导出, 不能在包外引用。",
+	INITIALIZATION_CYCLE_ERR:         "全局变量初始化循环: %s",
+	INIT_FUNCTION_SIGNATURE_ERR:      "init函数不能有参数和返回值。",
+	TYPE_NOT_COMPARABLE_ERR:          "%s类型的值不可比较。",
+	COMPARE_NIL_ONLY_ERR:             "%s类型的值只能与nil比较。",
+	TYPE_NOT_ORDERED_ERR:             "%s类型的值不能比较大小。",
+	MAP_KEY_NOT_COMPARABLE_ERR:       "map的键类型%s不可比较。",
+	OPERAND_OVERFLOW_ERR:             "字节码%s的操作数%d超出了范围。",
+	STACK_DEPTH_ERR:                  "函数%s的字节码有误: %v",
+	READ_FILE_ERR:                    "读取文件%s失败: %v",
 }
@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"math"
+	"math/big"
+)
+
 func FixMathBinaryExpression(expr *BinaryExpression) Expression {
 	expr.left = expr.left.Fix()
 	expr.right = expr.right.Fix()
@@ -18,12 +23,10 @@ func FixMathBinaryExpression(expr *BinaryExpression) Expression {
 	newBinaryExprLeftType := newBinaryExpr.left.GetType()
 	newBinaryExprRightType := newBinaryExpr.right.GetType()
 
-	if newBinaryExprLeftType.IsInt() && newBinaryExprRightType.IsInt() {
-		newBinaryExpr.SetType(NewType(BasicTypeInt))
-	} else if newBinaryExprLeftType.IsFloat() && newBinaryExprRightType.IsFloat() {
-		newBinaryExpr.SetType(NewType(BasicTypeFloat))
-	} else if expr.operator == AddOperator && newBinaryExprLeftType.IsString() && newBinaryExprRightType.IsString() {
-		newBinaryExpr.SetType(NewType(BasicTypeString))
+	if newBinaryExprLeftType.IsNumber() && newBinaryExprLeftType.Equal(newBinaryExprRightType) {
+		newBinaryExpr.SetType(newBinaryExprLeftType.Copy())
+	} else if expr.operator == AddOperator && newBinaryExprLeftType.IsString() && newBinaryExprLeftType.Equal(newBinaryExprRightType) {
+		newBinaryExpr.SetType(newBinaryExprLeftType.Copy())
 	} else {
 		compileError(
 			expr.Position(),
@@ -49,6 +52,17 @@ func FixCompareBinaryExpression(expr *BinaryExpression) Expression {
 
 	newBinaryExpr := CastBinaryExpression(expr)
 
+	// interface与其它类型比较时, 先装箱再比较动态类型和值
+	leftType := newBinaryExpr.left.GetType()
+	rightType := newBinaryExpr.right.GetType()
+	if leftType.IsInterface() && !rightType.IsInterface() && !rightType.IsNil() {
+		checkComparable(newBinaryExpr.right)
+		newBinaryExpr.right = CreateInterfaceExpression(newBinaryExpr.right, leftType).Fix()
+	} else if rightType.IsInterface() && !leftType.IsInterface() && !leftType.IsNil() {
+		checkComparable(newBinaryExpr.left)
+		newBinaryExpr.left = CreateInterfaceExpression(newBinaryExpr.left, rightType).Fix()
+	}
+
 	newBinaryExprLeftType := newBinaryExpr.left.GetType()
 	newBinaryExprRightType := newBinaryExpr.right.GetType()
 
@@ -75,11 +89,38 @@ func FixCompareBinaryExpression(expr *BinaryExpression) Expression {
 		)
 	}
 
+	switch expr.operator {
+	case EqOperator, NeOperator:
+		// slice, map, func只能与nil比较
+		if !isNilExpression(newBinaryExpr.left) && !isNilExpression(newBinaryExpr.right) {
+			checkComparable(newBinaryExpr.left)
+		}
+	default:
+		if !newBinaryExprLeftType.IsOrdered() {
+			compileError(expr.Position(), TYPE_NOT_ORDERED_ERR, newBinaryExprLeftType.GetTypeName())
+		}
+	}
+
 	newBinaryExpr.SetType(NewType(BasicTypeBool))
 
 	return newBinaryExpr
 }
 
+// 值能否用==, !=比较
+func checkComparable(expr Expression) {
+	typ := expr.GetType()
+
+	if typ.IsComparable() {
+		return
+	}
+
+	if typ.IsComposite() {
+		compileError(expr.Position(), COMPARE_NIL_ONLY_ERR, typ.GetTypeName())
+	}
+
+	compileError(expr.Position(), TYPE_NOT_COMPARABLE_ERR, typ.GetTypeName())
+}
+
 func FixLogicalBinaryExpression(expr *BinaryExpression) Expression {
 	expr.left = expr.left.Fix()
 	expr.right = expr.right.Fix()
@@ -108,13 +149,14 @@ func evalMathExpression(binaryExpr *BinaryExpression) Expression {
 			newExpr := evalMathExpressionInt(binaryExpr, leftExpr.Value, rightExpr.Value)
 			return newExpr
 		case *FloatExpression:
-			newExpr := evalMathExpressionInt(binaryExpr, leftExpr.Value, int(rightExpr.Value))
+			// 整数与浮点数常量运算, 结果为浮点数, eg: 3 / 2.0
+			newExpr := evalMathExpressionFloat(binaryExpr, bigIntToFloat(leftExpr.Value), rightExpr.Value)
 			return newExpr
 		}
 	case *FloatExpression:
 		switch rightExpr := binaryExpr.right.(type) {
 		case *IntExpression:
-			newExpr := evalMathExpressionFloat(binaryExpr, leftExpr.Value, float64(rightExpr.Value))
+			newExpr := evalMathExpressionFloat(binaryExpr, leftExpr.Value, bigIntToFloat(rightExpr.Value))
 			return newExpr
 		case *FloatExpression:
 			newExpr := evalMathExpressionFloat(binaryExpr, leftExpr.Value, rightExpr.Value)
@@ -124,7 +166,14 @@ func evalMathExpression(binaryExpr *BinaryExpression) Expression {
 		switch rightExpr := binaryExpr.right.(type) {
 		case *StringExpression:
 			if binaryExpr.operator == AddOperator {
+				// 自定义类型的字符串常量, 结果沿用该类型
+				typ := leftExpr.GetType()
+				if !typ.IsNamed() {
+					typ = rightExpr.GetType()
+				}
+
 				newExpr := &StringExpression{Value: leftExpr.Value + rightExpr.Value}
+				newExpr.SetType(typ.Copy())
 				newExpr.Fix()
 				return newExpr
 			}
@@ -134,27 +183,36 @@ func evalMathExpression(binaryExpr *BinaryExpression) Expression {
 	return binaryExpr
 }
 
-func evalMathExpressionInt(binaryExpr *BinaryExpression, left, right int) Expression {
-	var value int
+func evalMathExpressionInt(binaryExpr *BinaryExpression, left, right *big.Int) Expression {
+	value := new(big.Int)
 
 	switch binaryExpr.operator {
 	case AddOperator:
-		value = left + right
+		value.Add(left, right)
 	case SubOperator:
-		value = left - right
+		value.Sub(left, right)
 	case MulOperator:
-		value = left * right
+		value.Mul(left, right)
 	case DivOperator:
-		if right == 0 {
+		if right.Sign() == 0 {
 			compileError(binaryExpr.Position(), DIVISION_BY_ZERO_IN_COMPILE_ERR)
 		}
-		value = left / right
+		value.Quo(left, right)
 	default:
 		compileError(binaryExpr.Position(), MATH_TYPE_MISMATCH_ERR)
 	}
 
+	// 常量带有类型时(如'a' + 1), 结果沿用该类型
+	typ := binaryExpr.left.GetType()
+	if typ.IsInt() || !typ.IsInteger() {
+		typ = binaryExpr.right.GetType()
+	}
+	if !typ.IsInteger() {
+		typ = NewType(BasicTypeInt)
+	}
+
 	newExpr := CreateIntExpression(binaryExpr.Position(), value)
-	newExpr.SetType(NewType(BasicTypeInt))
+	newExpr.SetType(typ.Copy())
 
 	return newExpr
 }
@@ -177,8 +235,17 @@ func evalMathExpressionFloat(binaryExpr *BinaryExpression, left, right float64)
 	default:
 		compileError(binaryExpr.Position(), MATH_TYPE_MISMATCH_ERR)
 	}
+	// float32常量参与运算时, 结果为float32
+	typ := binaryExpr.left.GetType()
+	if !typ.IsFloat32() {
+		typ = binaryExpr.right.GetType()
+	}
+	if !typ.IsFloat32() {
+		typ = NewType(BasicTypeFloat)
+	}
+
 	newExpr := CreateFloatExpression(binaryExpr.Position(), value)
-	newExpr.SetType(NewType(BasicTypeFloat))
+	newExpr.SetType(typ.Copy())
 
 	return newExpr
 }
@@ -198,13 +265,13 @@ func evalCompareExpression(binaryExpr *BinaryExpression) Expression {
 			newExpr := evalCompareExpressionInt(binaryExpr, leftExpr.Value, rightExpr.Value)
 			return newExpr
 		case *FloatExpression:
-			newExpr := evalCompareExpressionDouble(binaryExpr, float64(leftExpr.Value), rightExpr.Value)
+			newExpr := evalCompareExpressionDouble(binaryExpr, bigIntToFloat(leftExpr.Value), rightExpr.Value)
 			return newExpr
 		}
 	case *FloatExpression:
 		switch rightExpr := binaryExpr.right.(type) {
 		case *IntExpression:
-			newExpr := evalCompareExpressionDouble(binaryExpr, leftExpr.Value, float64(rightExpr.Value))
+			newExpr := evalCompareExpressionDouble(binaryExpr, leftExpr.Value, bigIntToFloat(rightExpr.Value))
 			return newExpr
 		case *FloatExpression:
 			newExpr := evalCompareExpressionDouble(binaryExpr, leftExpr.Value, rightExpr.Value)
@@ -246,22 +313,24 @@ func evalCompareExpressionBoolean(binaryExpr *BinaryExpression, left, right bool
 	return newExpr
 }
 
-func evalCompareExpressionInt(binaryExpr *BinaryExpression, left, right int) Expression {
+func evalCompareExpressionInt(binaryExpr *BinaryExpression, left, right *big.Int) Expression {
 	var value bool
 
+	cmp := left.Cmp(right)
+
 	switch binaryExpr.operator {
 	case EqOperator:
-		value = left == right
+		value = cmp == 0
 	case NeOperator:
-		value = left != right
+		value = cmp != 0
 	case GtOperator:
-		value = left > right
+		value = cmp > 0
 	case GeOperator:
-		value = left >= right
+		value = cmp >= 0
 	case LtOperator:
-		value = left < right
+		value = cmp < 0
 	case LeOperator:
-		value = left <= right
+		value = cmp <= 0
 	default:
 		compileError(binaryExpr.Position(), COMPARE_TYPE_MISMATCH_ERR)
 	}
@@ -324,8 +393,18 @@ func evalCompareExpressionString(binaryExpr *BinaryExpression, left, right strin
 
 // 声明类型转换
 func CreateAssignCast(src Expression, destType *Type) Expression {
+	// 泛型函数只能调用, 不能赋值或者作为参数传递
+	if fd := getGenericFunction(src); fd != nil {
+		compileError(src.Position(), GENERIC_NOT_INSTANTIATED_ERR, fd.Name)
+	}
+
 	srcTye := src.GetType()
 
+	// 赋值给interface时装箱
+	if destType.IsInterface() && !srcTye.IsInterface() && !srcTye.IsNil() {
+		return CreateInterfaceExpression(src, destType).Fix()
+	}
+
 	if srcTye.Equal(destType) {
 		return src
 	}
@@ -334,22 +413,8 @@ func CreateAssignCast(src Expression, destType *Type) Expression {
 		return src
 	}
 
-	if destType.IsFloat() {
-		expr, ok := src.(*IntExpression)
-		if ok {
-			newExpr := CreateFloatExpression(expr.Position(), float64(expr.Value))
-			newExpr.Fix()
-			return newExpr
-		}
-	}
-
-	if destType.IsInt() {
-		expr, ok := src.(*FloatExpression)
-		if ok {
-			newExpr := CreateIntExpression(expr.Position(), int(expr.Value))
-			newExpr.Fix()
-			return newExpr
-		}
+	if canCastConstant(src, destType) {
+		return castConstant(src, destType)
 	}
 
 	castMismatchError(src.Position(), srcTye, destType)
@@ -361,25 +426,120 @@ func CastBinaryExpression(binaryExpr *BinaryExpression) *BinaryExpression {
 	leftType := binaryExpr.left.GetType()
 	rightType := binaryExpr.right.GetType()
 
-	if leftType.IsInt() && rightType.IsFloat() {
-		right, ok := binaryExpr.right.(*FloatExpression)
-		if !ok {
-			compileError(binaryExpr.Position(), CAST_MISMATCH_ERR, leftType.GetBasicType(), rightType.GetBasicType())
-		}
+	if leftType.Equal(rightType) {
+		return binaryExpr
+	}
+
+	// 常量转为另一边的类型, eg: b - '0', f * 2, name + "go"
+	if canCastConstant(binaryExpr.right, leftType) {
+		binaryExpr.right = castConstant(binaryExpr.right, leftType)
+	} else if canCastConstant(binaryExpr.left, rightType) {
+		binaryExpr.left = castConstant(binaryExpr.left, rightType)
+	}
+
+	return binaryExpr
+}
+
+// 常量可以隐式转为同一类别的类型, eg: 数字常量转为MyInt
+func canCastConstant(expr Expression, destType *Type) bool {
+	switch expr.(type) {
+	case *IntExpression, *FloatExpression:
+		return destType.IsNumber()
+	case *StringExpression:
+		return destType.IsString()
+	case *BoolExpression:
+		return destType.IsBool()
+	}
+
+	return false
+}
+
+func castConstant(expr Expression, destType *Type) Expression {
+	switch value := expr.(type) {
+	case *StringExpression:
+		newExpr := CreateStringExpression(value.Position(), value.Value)
+		newExpr.SetType(destType.Copy())
+		return newExpr.Fix()
+	case *BoolExpression:
+		newExpr := CreateBooleanExpression(value.Position(), value.Value)
+		newExpr.SetType(destType.Copy())
+		return newExpr.Fix()
+	}
+
+	return castNumberConstant(expr, destType)
+}
+
+func isNumberConstant(expr Expression) bool {
+	switch expr.(type) {
+	case *IntExpression, *FloatExpression:
+		return true
+	}
+
+	return false
+}
 
-		binaryExpr.right = CreateIntExpression(right.Position(), int(right.Value))
-		binaryExpr.right.Fix()
-	} else if leftType.IsFloat() && rightType.IsInt() {
-		right, ok := binaryExpr.right.(*IntExpression)
-		if !ok {
-			compileError(binaryExpr.Position(), CAST_MISMATCH_ERR, leftType.GetBasicType(), rightType.GetBasicType())
+// 数字常量转型, 整数超出范围时报错
+func castNumberConstant(expr Expression, destType *Type) Expression {
+	var newExpr Expression
+
+	switch value := expr.(type) {
+	case *IntExpression:
+		if destType.IsFloat() {
+			newExpr = CreateFloatExpression(value.Position(), bigIntToFloat(value.Value))
+		} else {
+			newExpr = CreateIntExpression(value.Position(), value.Value)
+		}
+	case *FloatExpression:
+		if destType.IsFloat() {
+			newExpr = CreateFloatExpression(value.Position(), value.Value)
+		} else {
+			// 不允许隐式丢失精度, eg: var i int = 3.5
+			if value.Value != math.Trunc(value.Value) {
+				compileError(value.Position(), CONSTANT_TRUNCATED_ERR, value.Value, destType.GetTypeName())
+			}
+			newExpr = CreateIntExpression(value.Position(), floatToBigInt(value.Value))
 		}
+	default:
+		castMismatchError(expr.Position(), expr.GetType(), destType)
+		return nil
+	}
+
+	newExpr.SetType(destType.Copy())
+	newExpr = newExpr.Fix()
 
-		binaryExpr.right = CreateFloatExpression(right.Position(), float64(right.Value))
-		binaryExpr.right.Fix()
+	if intExpr, ok := newExpr.(*IntExpression); ok {
+		intExpr.CheckRange()
 	}
 
-	return binaryExpr
+	return newExpr
+}
+
+// 整数类型的取值范围
+func integerRange(typ *Type) (*big.Int, *big.Int) {
+	bits := uint(typ.GetIntegerBits())
+
+	min := new(big.Int)
+	max := new(big.Int)
+
+	if typ.IsUnsigned() {
+		max.Lsh(big.NewInt(1), bits).Sub(max, big.NewInt(1))
+	} else {
+		min.Lsh(big.NewInt(1), bits-1).Neg(min)
+		max.Lsh(big.NewInt(1), bits-1).Sub(max, big.NewInt(1))
+	}
+
+	return min, max
+}
+
+func bigIntToFloat(value *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(value).Float64()
+	return f
+}
+
+// 向零取整
+func floatToBigInt(value float64) *big.Int {
+	i, _ := big.NewFloat(value).Int(nil)
+	return i
 }
 
 func castMismatchError(pos Position, src, dest *Type) {
@@ -2,6 +2,9 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"unicode/utf8"
 
 	"github.com/lth-go/gogo/vm"
 )
@@ -39,7 +42,9 @@ type BoolExpression struct {
 }
 
 func (expr *BoolExpression) Fix() Expression {
-	expr.SetType(NewType(BasicTypeBool))
+	if expr.GetType() == nil {
+		expr.SetType(NewType(BasicTypeBool))
+	}
 	expr.GetType().Fix()
 	return expr
 }
@@ -67,32 +72,57 @@ func CreateBooleanExpression(pos Position, value bool) *BoolExpression {
 //
 type IntExpression struct {
 	ExpressionBase
-	Value int
+	Value *big.Int // 常量按任意精度保存, 生成字节码时再检查范围
 	Index int
 }
 
 func (expr *IntExpression) Fix() Expression {
-	expr.SetType(NewType(BasicTypeInt))
+	// 字符常量等已带有类型
+	if expr.GetType() == nil {
+		expr.SetType(NewType(BasicTypeInt))
+	}
 	expr.GetType().Fix()
 
-	if expr.Value > 65535 || expr.Value < 0 {
-		expr.Index = GetCurrentCompiler().AddConstant(expr.Value)
+	value := expr.Int64()
+	if value > vm.MaxShortOperand || value < 0 {
+		expr.Index = GetCurrentCompiler().AddConstant(value)
 	}
 
 	return expr
 }
 
 func (expr *IntExpression) Generate(ob *OpCodeBuf) {
-	if expr.Value >= 0 && expr.Value < 256 {
-		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_INT_1BYTE, expr.Value)
-	} else if expr.Value >= 0 && expr.Value < 65536 {
-		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_INT_2BYTE, expr.Value)
+	expr.CheckRange()
+
+	value := expr.Int64()
+
+	if value >= 0 && value < 256 {
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_INT_1BYTE, int(value))
+	} else if value >= 0 && value <= vm.MaxShortOperand {
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_INT_2BYTE, int(value))
 	} else {
 		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_INT, expr.Index)
 	}
 }
 
-func CreateIntExpression(pos Position, value int) *IntExpression {
+// 常量在确定最终类型后检查范围, eg: var h uint64 = 14695981039346656037
+func (expr *IntExpression) CheckRange() {
+	min, max := integerRange(expr.GetType())
+	if expr.Value.Cmp(min) < 0 || expr.Value.Cmp(max) > 0 {
+		compileError(expr.Position(), CONSTANT_OVERFLOW_ERR, expr.Value, expr.GetType().GetTypeName())
+	}
+}
+
+// 虚拟机中的值, 无符号整数按位保存为int64
+func (expr *IntExpression) Int64() int64 {
+	if expr.Value.IsInt64() {
+		return expr.Value.Int64()
+	}
+
+	return int64(expr.Value.Uint64())
+}
+
+func CreateIntExpression(pos Position, value *big.Int) *IntExpression {
 	expr := &IntExpression{
 		Value: value,
 	}
@@ -101,6 +131,14 @@ func CreateIntExpression(pos Position, value int) *IntExpression {
 	return expr
 }
 
+// 字符常量, eg: 'a'
+func CreateRuneExpression(pos Position, value rune) *IntExpression {
+	expr := CreateIntExpression(pos, big.NewInt(int64(value)))
+	expr.SetType(CreateType(BasicTypeInt32, pos))
+
+	return expr
+}
+
 //
 // FloatExpression
 //
@@ -111,9 +149,18 @@ type FloatExpression struct {
 }
 
 func (expr *FloatExpression) Fix() Expression {
-	expr.SetType(NewType(BasicTypeFloat))
+	if expr.GetType() == nil {
+		expr.SetType(NewType(BasicTypeFloat))
+	}
 	expr.GetType().Fix()
 
+	if expr.GetType().IsFloat32() {
+		if math.Abs(expr.Value) > math.MaxFloat32 {
+			compileError(expr.Position(), CONSTANT_OVERFLOW_ERR, expr.Value, expr.GetType().GetTypeName())
+		}
+		expr.Value = float64(float32(expr.Value))
+	}
+
 	if expr.Value != 0.0 && expr.Value != 1.0 {
 		expr.Index = GetCurrentCompiler().AddConstant(expr.Value)
 	}
@@ -149,7 +196,9 @@ type StringExpression struct {
 }
 
 func (expr *StringExpression) Fix() Expression {
-	expr.SetType(NewType(BasicTypeString))
+	if expr.GetType() == nil {
+		expr.SetType(NewType(BasicTypeString))
+	}
 	expr.GetType().Fix()
 
 	expr.Index = GetCurrentCompiler().AddConstant(expr.Value)
@@ -170,31 +219,49 @@ func CreateStringExpression(pos Position, value string) *StringExpression {
 }
 
 //
-// InterfaceExpression
+// InterfaceExpression 非interface的值赋给interface时装箱, 记录动态类型
 //
 type InterfaceExpression struct {
 	ExpressionBase
 	Data Expression
 }
 
+// Data已修正
 func (expr *InterfaceExpression) Fix() Expression {
-	expr.SetType(NewType(BasicTypeInterface))
-	expr.Data.Fix()
 	expr.GetType().Fix()
 
 	return expr
 }
 
 func (expr *InterfaceExpression) Generate(ob *OpCodeBuf) {
+	dataType := expr.Data.GetType()
+
 	expr.Data.Generate(ob)
-	ob.GenerateCode(expr.Position(), vm.OP_CODE_NEW_INTERFACE)
+
+	// uint, uint64按位保存, 装箱时需要区分
+	if dataType.IsUnsigned() && dataType.GetIntegerBits() == 64 {
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_CAST_UINT_TO_INTERFACE)
+	}
+
+	ob.GenerateCode(expr.Position(), vm.OP_CODE_NEW_INTERFACE, GetCurrentCompiler().AddConstant(expr.GetRuntimeType()))
 }
 
-func CreateInterfaceExpression(pos Position) *InterfaceExpression {
+// 动态类型
+func (expr *InterfaceExpression) GetRuntimeType() vm.RuntimeType {
+	dataType := expr.Data.GetType()
+
+	return vm.RuntimeType{
+		Name:       dataType.GetRuntimeTypeName(),
+		Comparable: dataType.IsComparable(),
+	}
+}
+
+func CreateInterfaceExpression(data Expression, typ *Type) *InterfaceExpression {
 	expr := &InterfaceExpression{
-		Data: CreateNilExpression(pos),
+		Data: data,
 	}
-	expr.SetPosition(pos)
+	expr.SetType(typ.Copy())
+	expr.SetPosition(data.Position())
 
 	return expr
 }
@@ -229,6 +296,12 @@ func isNilExpression(expr Expression) bool {
 	return ok
 }
 
+// 是否为空白标识符 `_`
+func isBlankIdentifier(expr Expression) bool {
+	identifierExpr, ok := expr.(*IdentifierExpression)
+	return ok && identifierExpr.Name == "_"
+}
+
 //
 // ArrayExpression 创建列表时的值, eg:{1,2,3,4}
 //
@@ -350,10 +423,9 @@ func (expr *StructExpression) Fix() Expression {
 	for i, field := range expr.FieldList {
 		fieldType := expr.Type.structType.Fields[i].Type
 
+		// 未赋值的字段使用类型默认值
 		if field == nil {
-			// TODO: default value
-			// expr.FieldList[i] = field
-			panic("TODO")
+			expr.FieldList[i] = GetTypeDefaultValue(fieldType, expr.Position())
 		}
 		expr.FieldList[i] = expr.FieldList[i].Fix()
 		expr.FieldList[i] = CreateAssignCast(expr.FieldList[i], fieldType)
@@ -412,7 +484,7 @@ type IdentifierExpression struct {
 	ExpressionBase
 	PackageName string
 	Name        string
-	Obj         interface{} // 变量,函数,包,自定义类型(FunctionIdentifier Declaration Package)
+	Obj         interface{} // 变量,函数,包,自定义类型(FunctionIdentifier Declaration Package Type)
 	Block       *Block
 }
 
@@ -459,13 +531,11 @@ func (expr *IdentifierExpression) Fix() Expression {
 	//
 	// 判断是否是包引用
 	//
-	for _, imp := range pkg.importList {
-		if imp.packageName == expr.Name {
-			expr.SetType(NewType(BasicTypePackage))
-			expr.Obj = imp.packageName
-			expr.GetType().Fix()
-			return expr
-		}
+	if imp := pkg.searchImportByName(expr.Name); imp != nil {
+		expr.SetType(NewType(BasicTypePackage))
+		expr.Obj = imp.GetPackageName()
+		expr.GetType().Fix()
+		return expr
 	}
 
 	compileError(expr.Position(), IDENTIFIER_NOT_FOUND_ERR, expr.Name)
@@ -476,6 +546,9 @@ func (expr *IdentifierExpression) Fix() Expression {
 func (expr *IdentifierExpression) Generate(ob *OpCodeBuf) {
 	switch inner := expr.Obj.(type) {
 	case *FunctionIdentifier:
+		if inner.Func.IsGeneric() {
+			compileError(expr.Position(), GENERIC_NOT_INSTANTIATED_ERR, inner.Func.Name)
+		}
 		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_FUNCTION, inner.Index)
 
 	case *Declaration:
@@ -498,6 +571,11 @@ func CreateIdentifierExpression(pos Position, name string) *IdentifierExpression
 	if c != nil {
 		expr.Block = c.currentBlock
 		expr.PackageName = c.GetPackageName()
+
+		// 泛型实例化时, 类型参数替换为实际类型, eg: T(0)
+		if typeArg, ok := c.typeArgs[name]; ok {
+			expr.Obj = typeArg.Copy()
+		}
 	}
 
 	return expr
@@ -508,36 +586,72 @@ func CreateIdentifierExpression(pos Position, name string) *IdentifierExpression
 //
 type CallExpression struct {
 	ExpressionBase
-	Func Expression   // 函数名
-	Args []Expression // 实参列表
+	Func    Expression   // 函数名
+	Args    []Expression // 实参列表
+	Results []Expression // 返回值占位
 }
 
 func (expr *CallExpression) Fix() Expression {
+	// 类型转换, eg: string(r)
+	convertExpr := expr.toConvertExpression()
+	if convertExpr != nil {
+		return convertExpr.Fix()
+	}
+
 	expr.Func = expr.Func.Fix()
 
-	funcExpr, ok := expr.Func.(*IdentifierExpression)
-	if !ok {
-		panic("TODO")
-		// compileError(expr.Position(), FUNCTION_NOT_FOUND_ERR, "")
+	for i := range expr.Args {
+		expr.Args[i] = expr.Args[i].Fix()
 	}
 
-	fd := funcExpr.Obj.(*FunctionIdentifier).Func
+	// 泛型函数根据实参推导类型参数, 并实例化
+	fd := getGenericFunction(expr.Func)
+	if fd != nil {
+		typeArgs := fd.InferTypeArgs(expr.Args, expr.Position())
+		expr.Func = createInstanceExpression(expr.Func.Position(), fd, typeArgs)
+	}
+
+	// 函数名或函数类型的变量
+	funcType := expr.Func.GetType()
+	if !funcType.IsFunc() {
+		compileError(expr.Position(), FUNCTION_NOT_IDENTIFIER_ERR)
+	}
 
-	expr.Args = FixArgList(fd, expr.Args)
+	expr.Args = FixArgList(funcType.funcType, expr.Args, expr.Position(), !isBuiltinCall(expr.Func))
 
-	FixReturn(fd, expr.Type)
+	FixReturn(funcType.funcType, expr.Type)
+
+	for _, p := range funcType.funcType.Results {
+		expr.Results = append(expr.Results, GetTypeDefaultValue(p.Type, expr.Position()).Fix())
+	}
 
 	expr.GetType().Fix()
 
 	return expr
 }
 
-func FixArgList(fd *FunctionDefinition, argumentList []Expression) []Expression {
-	parameterList := fd.Type.funcType.Params
+// 内置函数len, append, delete的interface形参表示任意类型, 实参不装箱
+func isBuiltinCall(funcExpr Expression) bool {
+	identifierExpr, ok := funcExpr.(*IdentifierExpression)
+	if !ok {
+		return false
+	}
+
+	f, ok := identifierExpr.Obj.(*FunctionIdentifier)
+	if !ok {
+		return false
+	}
+
+	return f.Func.PackageName == "_sys" && f.Func.Name != "printf"
+}
+
+// 实参已修正, box为false时interface形参的实参不装箱
+func FixArgList(funcType *FuncType, argumentList []Expression, pos Position, box bool) []Expression {
+	parameterList := funcType.Params
 
 	paramLen := len(parameterList)
 
-	if paramLen > 0 {
+	if paramLen > 0 && len(argumentList) >= paramLen-1 {
 		lastP := parameterList[paramLen-1]
 		if lastP.Ellipsis {
 			newArgList := make([]Expression, 0)
@@ -545,6 +659,11 @@ func FixArgList(fd *FunctionDefinition, argumentList []Expression) []Expression
 				newArgList = append(newArgList, expr)
 			}
 			lastArg := CreateArrayExpression(lastP.Type, argumentList[paramLen-1:])
+			if box {
+				for i, elem := range lastArg.List {
+					lastArg.List[i] = CreateAssignCast(elem, lastP.Type.arrayType.ElementType)
+				}
+			}
 			newArgList = append(newArgList, lastArg)
 
 			argumentList = newArgList
@@ -554,11 +673,17 @@ func FixArgList(fd *FunctionDefinition, argumentList []Expression) []Expression
 	argLen := len(argumentList)
 
 	if argLen != paramLen {
-		compileError(fd.GetType().Position(), ARGUMENT_COUNT_MISMATCH_ERR, paramLen, argLen)
+		compileError(pos, ARGUMENT_COUNT_MISMATCH_ERR, "", paramLen, argLen)
 	}
 
 	for i := 0; i < paramLen; i++ {
-		argumentList[i] = argumentList[i].Fix()
+		// 常量实参转为形参类型, eg: Max(f, 1)
+		if !argumentList[i].GetType().Equal(parameterList[i].Type) && canCastConstant(argumentList[i], parameterList[i].Type) {
+			argumentList[i] = castConstant(argumentList[i], parameterList[i].Type)
+		}
+		if box {
+			argumentList[i] = boxInterfaceArg(argumentList[i], parameterList[i].Type)
+		}
 		if !argumentList[i].GetType().Equal(parameterList[i].Type) {
 			compileError(
 				argumentList[i].Position(),
@@ -573,17 +698,30 @@ func FixArgList(fd *FunctionDefinition, argumentList []Expression) []Expression
 	return argumentList
 }
 
+// interface形参的实参装箱
+func boxInterfaceArg(arg Expression, paramType *Type) Expression {
+	argType := arg.GetType()
+
+	if paramType.IsInterface() && !argType.IsInterface() && !argType.IsNil() {
+		return CreateInterfaceExpression(arg, paramType).Fix()
+	}
+
+	return arg
+}
+
 // 设置返回值类型
-func FixReturn(fd *FunctionDefinition, typ *Type) {
-	resultCount := len(fd.Type.funcType.Results)
+func FixReturn(funcType *FuncType, typ *Type) {
+	resultCount := len(funcType.Results)
 
 	if resultCount == 0 {
 		typ.SetBasicType(BasicTypeVoid)
 	} else if resultCount == 1 {
-		typ.SetBasicType(fd.Type.funcType.Results[0].Type.GetBasicType())
+		resultType := funcType.Results[0].Type.Copy()
+		resultType.SetPosition(typ.Position())
+		*typ = *resultType
 	} else {
 		typeList := make([]*Type, resultCount)
-		for i, resultType := range fd.Type.funcType.Results {
+		for i, resultType := range funcType.Results {
 			typeList[i] = resultType.Type.Copy()
 		}
 		typ.SetBasicType(BasicTypeMultipleValues)
@@ -597,10 +735,8 @@ func FixReturn(fd *FunctionDefinition, typ *Type) {
 // return address
 // locals -- base
 func (expr *CallExpression) Generate(ob *OpCodeBuf) {
-	fd := expr.Func.(*IdentifierExpression).Obj.(*FunctionIdentifier).Func
-
-	for _, p := range fd.Type.funcType.Results {
-		GetTypeDefaultValue(p.Type, expr.Position()).Generate(ob)
+	for _, result := range expr.Results {
+		result.Generate(ob)
 	}
 
 	for _, param := range expr.Args {
@@ -608,7 +744,104 @@ func (expr *CallExpression) Generate(ob *OpCodeBuf) {
 	}
 
 	expr.Func.Generate(ob)
-	ob.GenerateCode(expr.Position(), vm.OP_CODE_INVOKE)
+	ob.GenerateCode(expr.Position(), vm.OP_CODE_INVOKE, len(expr.Args))
+}
+
+// 以类型名调用时, 视为类型转换, eg: int(f), MyInt(i), utils.MyInt(i)
+func (expr *CallExpression) toConvertExpression() *ConvertExpression {
+	if len(expr.Args) != 1 {
+		return nil
+	}
+
+	typ := expressionToType(expr.Func)
+	if typ == nil {
+		return nil
+	}
+
+	return CreateConvertExpression(typ, expr.Args[0])
+}
+
+// 表达式为类型名时返回对应的类型, eg: int, MyInt, utils.MyInt, 实例化时的T
+func expressionToType(expr Expression) *Type {
+	var typ *Type
+
+	switch typeExpr := expr.(type) {
+	case *IdentifierExpression:
+		if typeArg, ok := typeExpr.Obj.(*Type); ok {
+			typ = typeArg.Copy()
+			typ.SetPosition(typeExpr.Position())
+			return typ
+		}
+
+		switch typeExpr.Name {
+		case "bool", "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64",
+			"float", "float32", "float64",
+			"byte", "rune", "string", "any":
+			typ = CreateTypeByName(typeExpr.Name, typeExpr.Position())
+		default:
+			if GetCurrentCompiler().SearchTypeDef(typeExpr.PackageName, typeExpr.Name) == nil {
+				return nil
+			}
+			typ = CreateType(BasicTypeNoType, typeExpr.Position())
+			typ.packageName = typeExpr.PackageName
+			typ.name = typeExpr.Name
+		}
+	case *SelectorExpression:
+		packageExpr, ok := typeExpr.X.(*IdentifierExpression)
+		if !ok {
+			return nil
+		}
+		imp := GetCurrentPackage().searchImportByName(packageExpr.Name)
+		if imp == nil || GetCurrentCompiler().SearchTypeDef(imp.GetPackageName(), typeExpr.Sel) == nil {
+			return nil
+		}
+		if !IsExported(typeExpr.Sel) {
+			compileError(typeExpr.Position(), UNEXPORTED_NAME_ERR, packageExpr.Name+"."+typeExpr.Sel)
+		}
+		typ = CreateType(BasicTypeNoType, typeExpr.Position())
+		typ.packageName = imp.GetPackageName()
+		typ.name = typeExpr.Sel
+	case *IndexExpression:
+		return expressionToGenericType(typeExpr.X, []Expression{typeExpr.Index})
+	case *InstantiateExpression:
+		return expressionToGenericType(typeExpr.X, typeExpr.TypeArgs)
+	default:
+		return nil
+	}
+
+	return typ
+}
+
+// 泛型类型实例, eg: List[int], utils.Pair[int, string]
+func expressionToGenericType(expr Expression, typeArgExprList []Expression) *Type {
+	typ := expressionToType(expr)
+	if typ == nil || !typ.IsNamed() {
+		return nil
+	}
+
+	typ.typeArgs = expressionListToTypeList(typeArgExprList)
+
+	return typ
+}
+
+// 类型实参列表, 不是类型名时报错
+func expressionListToTypeList(typeArgExprList []Expression) []*Type {
+	typeArgs := make([]*Type, 0)
+
+	for _, typeArgExpr := range typeArgExprList {
+		typ := expressionToType(typeArgExpr)
+		if typ == nil {
+			name := ""
+			if identifierExpr, ok := typeArgExpr.(*IdentifierExpression); ok {
+				name = identifierExpr.Name
+			}
+			compileError(typeArgExpr.Position(), TYPE_NAME_NOT_FOUND_ERR, name)
+		}
+		typeArgs = append(typeArgs, typ)
+	}
+
+	return typeArgs
 }
 
 func NewFunctionCallExpression(pos Position, function Expression, argumentList []Expression) *CallExpression {
@@ -622,6 +855,146 @@ func NewFunctionCallExpression(pos Position, function Expression, argumentList [
 	return expr
 }
 
+//
+// ConvertExpression 类型转换表达式, eg: []byte(s), string(r)
+//
+type ConvertExpression struct {
+	ExpressionBase
+	Value    Expression
+	CastType CastType
+}
+
+func (expr *ConvertExpression) Fix() Expression {
+	expr.Value = expr.Value.Fix()
+
+	srcType := expr.Value.GetType()
+	destType := expr.GetType()
+
+	switch {
+	case destType.IsInterface() && (srcType.IsInterface() || srcType.IsNil()):
+		expr.Value.SetType(destType.Copy())
+		return expr.Value
+	case destType.IsInterface():
+		return CreateInterfaceExpression(expr.Value, destType).Fix()
+	case srcType.IsNumber() && destType.IsNumber():
+		return expr.fixNumber()
+	case srcType.IsInteger() && destType.IsString():
+		constExpr, ok := expr.Value.(*IntExpression)
+		if ok {
+			r := utf8.RuneError
+			if constExpr.Value.IsInt64() && constExpr.Value.Int64() >= 0 && constExpr.Value.Int64() <= utf8.MaxRune {
+				r = rune(constExpr.Value.Int64())
+			}
+			return CreateStringExpression(expr.Position(), string(r)).Fix()
+		}
+
+		expr.CastType = CastTypeIntToString
+	case srcType.IsString() && destType.IsByteArray():
+		expr.CastType = CastTypeStringToBytes
+	case srcType.IsString() && destType.IsRuneArray():
+		expr.CastType = CastTypeStringToRunes
+	case srcType.IsByteArray() && destType.IsString():
+		expr.CastType = CastTypeBytesToString
+	case srcType.IsRuneArray() && destType.IsString():
+		expr.CastType = CastTypeRunesToString
+	case !srcType.IsInterface() && srcType.UnderlyingEqual(destType):
+		expr.Value.SetType(destType.Copy())
+		return expr.Value
+	default:
+		castMismatchError(expr.Position(), srcType, destType)
+	}
+
+	expr.GetType().Fix()
+
+	return expr
+}
+
+// 数字之间的转换, 需要时拆成多步, eg: int8(f) 为 float -> int -> int8
+func (expr *ConvertExpression) fixNumber() Expression {
+	value := expr.Value
+	srcType := value.GetType()
+	destType := expr.GetType()
+
+	if isNumberConstant(value) {
+		return castNumberConstant(value, destType)
+	}
+
+	switch {
+	case srcType.IsInteger() && destType.IsFloat():
+		castType := CastTypeIntToFloat
+		if srcType.IsUnsigned() && srcType.GetIntegerBits() == 64 {
+			castType = CastTypeUintToFloat
+		}
+		value = newConvertExpression(value, NewType(BasicTypeFloat), castType)
+	case srcType.IsFloat() && destType.IsInteger():
+		castType := CastTypeFloatToInt
+		if destType.IsUnsigned() {
+			castType = CastTypeFloatToUint
+		}
+		value = newConvertExpression(value, NewType(BasicTypeInt), castType)
+	case srcType.IsInteger() && destType.IsInteger():
+		if !integerNeedTruncate(srcType, destType) {
+			value.SetType(destType.Copy())
+			return value
+		}
+	}
+
+	switch {
+	case destType.IsFloat32() && !value.GetType().IsFloat32():
+		return newConvertExpression(value, destType, CastTypeFloatToFloat32)
+	case destType.IsInteger() && destType.GetIntegerBits() < 64 && value.GetType().IsInteger():
+		return newConvertExpression(value, destType, truncateCastTypeMap[destType.GetBasicType()])
+	}
+
+	value.SetType(destType.Copy())
+	return value
+}
+
+// 源类型的取值范围不包含在目标类型中时需要截断
+func integerNeedTruncate(srcType, destType *Type) bool {
+	srcBits := srcType.GetIntegerBits()
+	destBits := destType.GetIntegerBits()
+
+	// 64位整数按位保存, 不需要处理
+	if destBits == 64 {
+		return false
+	}
+
+	if srcType.IsUnsigned() == destType.IsUnsigned() {
+		return srcBits > destBits
+	}
+
+	return !(srcType.IsUnsigned() && srcBits < destBits)
+}
+
+func (expr *ConvertExpression) Generate(ob *OpCodeBuf) {
+	expr.Value.Generate(ob)
+	ob.GenerateCode(expr.Position(), castCodeMap[expr.CastType])
+}
+
+// 创建已确定类型的转换表达式
+func newConvertExpression(value Expression, typ *Type, castType CastType) *ConvertExpression {
+	expr := &ConvertExpression{
+		Value:    value,
+		CastType: castType,
+	}
+	expr.SetType(typ.Copy())
+	expr.SetPosition(value.Position())
+	expr.GetType().Fix()
+
+	return expr
+}
+
+func CreateConvertExpression(typ *Type, value Expression) *ConvertExpression {
+	expr := &ConvertExpression{
+		Value: value,
+	}
+	expr.SetType(typ)
+	expr.SetPosition(typ.Position())
+
+	return expr
+}
+
 //
 // SelectorExpression
 //
@@ -661,7 +1034,12 @@ func (expr *SelectorExpression) Generate(ob *OpCodeBuf) {
 }
 
 func FixPackageSelectorExpression(expr *SelectorExpression) Expression {
-	packageName := expr.X.(*IdentifierExpression).Obj.(string)
+	packageExpr := expr.X.(*IdentifierExpression)
+	packageName := packageExpr.Obj.(string)
+
+	if !IsExported(expr.Sel) {
+		compileError(expr.Position(), UNEXPORTED_NAME_ERR, packageExpr.Name+"."+expr.Sel)
+	}
 
 	fd, index := GetCurrentCompiler().SearchFunction(packageName, expr.Sel)
 	if fd != nil {
@@ -695,8 +1073,14 @@ func FixStructSelectorExpression(expr *SelectorExpression) Expression {
 	// innerExpr.GetType().Fix()
 	expr.X = expr.X.Fix()
 
-	for i, field := range expr.X.GetType().structType.Fields {
+	typ := expr.X.GetType()
+
+	for i, field := range typ.structType.Fields {
 		if field.Name == expr.Sel {
+			// 其它包中自定义类型的字段
+			if typ.IsNamed() && typ.packageName != GetCurrentPackage().GetPackageName() && !IsExported(field.Name) {
+				compileError(expr.Position(), UNEXPORTED_NAME_ERR, typ.GetTypeName()+"."+field.Name)
+			}
 			expr.Index = i
 			expr.SetType(field.Type.Copy())
 
@@ -722,27 +1106,57 @@ func CreateSelectorExpression(expression Expression, memberName string) *Selecto
 //
 type IndexExpression struct {
 	ExpressionBase
-	X     Expression
-	Index Expression
+	X         Expression
+	Index     Expression
+	CommaOk   bool       // v, ok = m[k]
+	ZeroValue Expression // map中不存在key时返回的默认值
 }
 
 func (expr *IndexExpression) Fix() Expression {
 
 	expr.X = expr.X.Fix()
+
+	// 显式实例化泛型函数, eg: Max[float]
+	if fd := getGenericFunction(expr.X); fd != nil {
+		return fixInstantiateExpression(expr.Position(), fd, []Expression{expr.Index})
+	}
+
 	expr.Index = expr.Index.Fix()
 
-	if !expr.X.GetType().IsArray() && !expr.X.GetType().IsMap() {
+	if !expr.X.GetType().IsArray() && !expr.X.GetType().IsMap() && !expr.X.GetType().IsString() {
 		compileError(expr.Position(), INDEX_LEFT_OPERAND_NOT_ARRAY_ERR)
 	}
 
+	if expr.CommaOk && !expr.X.GetType().IsMap() {
+		compileError(expr.Position(), ASSIGNMENT_COUNT_MISMATCH_ERR, 2, 1)
+	}
+
 	if expr.X.GetType().IsArray() {
 		expr.SetType(expr.X.GetType().arrayType.ElementType.Copy())
 
-		if !expr.Index.GetType().IsInt() {
+		if !expr.Index.GetType().IsInteger() {
+			compileError(expr.Position(), INDEX_NOT_INT_ERR)
+		}
+	} else if expr.X.GetType().IsString() {
+		// 字符串下标取到的是字节
+		expr.SetType(NewType(BasicTypeUint8))
+
+		if !expr.Index.GetType().IsInteger() {
 			compileError(expr.Position(), INDEX_NOT_INT_ERR)
 		}
 	} else if expr.X.GetType().IsMap() {
-		expr.SetType(expr.X.GetType().mapType.Value.Copy())
+		mapType := expr.X.GetType().mapType
+
+		expr.Index = CreateAssignCast(expr.Index, mapType.Key)
+		expr.ZeroValue = GetTypeDefaultValue(mapType.Value, expr.Position()).Fix()
+
+		if expr.CommaOk {
+			typeList := []*Type{mapType.Value.Copy(), NewType(BasicTypeBool)}
+			expr.SetType(NewType(BasicTypeMultipleValues))
+			expr.GetType().multipleValueType = NewMultipleValueType(typeList)
+		} else {
+			expr.SetType(mapType.Value.Copy())
+		}
 	}
 
 	expr.GetType().Fix()
@@ -751,15 +1165,25 @@ func (expr *IndexExpression) Fix() Expression {
 }
 
 func (expr *IndexExpression) Generate(ob *OpCodeBuf) {
-	expr.X.Generate(ob)
-	expr.Index.Generate(ob)
-
 	switch {
 	case expr.X.GetType().IsArray():
-		code := vm.OP_CODE_PUSH_ARRAY
-		ob.GenerateCode(expr.Position(), code)
+		expr.X.Generate(ob)
+		expr.Index.Generate(ob)
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_ARRAY)
+	case expr.X.GetType().IsString():
+		expr.X.Generate(ob)
+		expr.Index.Generate(ob)
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_PUSH_STRING_BYTE)
 	case expr.X.GetType().IsMap():
+		// 默认值先入栈, key存在时被覆盖
+		expr.ZeroValue.Generate(ob)
+		expr.X.Generate(ob)
+		expr.Index.Generate(ob)
+
 		code := vm.OP_CODE_PUSH_MAP
+		if expr.CommaOk {
+			code = vm.OP_CODE_PUSH_MAP_OK
+		}
 		ob.GenerateCode(expr.Position(), code)
 	default:
 		panic("TODO")
@@ -776,6 +1200,40 @@ func CreateIndexExpression(pos Position, array, index Expression) *IndexExpressi
 	return expr
 }
 
+//
+// InstantiateExpression 显式实例化泛型函数, eg: Map[int, string]
+//
+type InstantiateExpression struct {
+	ExpressionBase
+	X        Expression
+	TypeArgs []Expression
+}
+
+func (expr *InstantiateExpression) Fix() Expression {
+	expr.X = expr.X.Fix()
+
+	fd := getGenericFunction(expr.X)
+	if fd == nil {
+		compileError(expr.Position(), INDEX_LEFT_OPERAND_NOT_ARRAY_ERR)
+	}
+
+	return fixInstantiateExpression(expr.Position(), fd, expr.TypeArgs)
+}
+
+func fixInstantiateExpression(pos Position, fd *FunctionDefinition, typeArgExprList []Expression) Expression {
+	return createInstanceExpression(pos, fd, expressionListToTypeList(typeArgExprList))
+}
+
+func CreateInstantiateExpression(pos Position, x Expression, typeArgs []Expression) *InstantiateExpression {
+	expr := &InstantiateExpression{
+		X:        x,
+		TypeArgs: typeArgs,
+	}
+	expr.SetPosition(pos)
+
+	return expr
+}
+
 type KeyValueExpression struct {
 	ExpressionBase
 	Key   Expression
@@ -816,19 +1274,23 @@ func CreateKeyValueExpression(pos Position, key, value Expression) *KeyValueExpr
 }
 
 func CreateCompositeLit(typ *Type, valueList []Expression) Expression {
-	if typ.IsArray() {
-		return CreateArrayExpression(typ, valueList)
-	}
+	var expr Expression
 
-	if typ.IsMap() {
-		return CreateMapExpression(typ, valueList)
+	switch {
+	case typ.IsArray():
+		expr = CreateArrayExpression(typ, valueList)
+	case typ.IsMap():
+		expr = CreateMapExpression(typ, valueList)
+	case typ.IsStruct():
+		expr = CreateStructExpression(typ, valueList)
+	default:
+		return nil
 	}
 
-	if typ.IsStruct() {
-		return CreateStructExpression(typ, valueList)
-	}
+	// 报错时指向字面量的类型
+	expr.SetPosition(typ.Position())
 
-	return nil
+	return expr
 }
 
 //
@@ -884,7 +1346,7 @@ func (expr *BinaryExpression) Generate(ob *OpCodeBuf) {
 			panic("TODO")
 		}
 
-		if leftExpr.GetType().IsInt() || leftExpr.GetType().IsBool() {
+		if leftExpr.GetType().IsInteger() || leftExpr.GetType().IsBool() {
 			offset = byte(0)
 		} else if leftExpr.GetType().IsFloat() {
 			offset = byte(1)
@@ -892,39 +1354,79 @@ func (expr *BinaryExpression) Generate(ob *OpCodeBuf) {
 			offset = byte(2)
 		} else if leftExpr.GetType().IsNil() || rightExpr.GetType().IsNil() {
 			offset = byte(3)
-		} else if leftExpr.GetType().IsComposite() {
+		} else if leftExpr.GetType().IsComposite() || leftExpr.GetType().IsStruct() || leftExpr.GetType().IsInterface() {
 			offset = byte(3)
 		} else {
 			panic("TODO")
 		}
 
-		ob.GenerateCode(expr.Position(), code+offset)
+		if uintCode, ok := unsignedOperatorCodeMap[operator]; ok && leftExpr.GetType().IsUnsigned() {
+			ob.GenerateCode(expr.Position(), uintCode)
+		} else {
+			ob.GenerateCode(expr.Position(), code+offset)
+		}
 
-	case LogicalAndOperator, LogicalOrOperator:
-		var jumpCode, logicalCode byte
+		generateTruncate(ob, expr.GetType(), expr.Position())
 
-		if operator == LogicalAndOperator {
-			jumpCode = vm.OP_CODE_JUMP_IF_FALSE
-			logicalCode = vm.OP_CODE_LOGICAL_AND
-		} else {
+	case LogicalAndOperator, LogicalOrOperator:
+		jumpCode := vm.OP_CODE_JUMP_IF_FALSE
+		if operator == LogicalOrOperator {
 			jumpCode = vm.OP_CODE_JUMP_IF_TRUE
-			logicalCode = vm.OP_CODE_LOGICAL_OR
 		}
 
 		label := ob.GetLabel()
 
+		// 短路求值, 左值已能确定结果时, 左值即为结果, 不再计算右值
 		expr.left.Generate(ob)
 		ob.GenerateCode(expr.Position(), vm.OP_CODE_DUPLICATE)
 		ob.GenerateCode(expr.Position(), jumpCode, label)
 
+		ob.GenerateCode(expr.Position(), vm.OP_CODE_POP)
 		expr.right.Generate(ob)
 
-		ob.GenerateCode(expr.Position(), logicalCode)
-
 		ob.SetLabel(label)
 	}
 }
 
+//
+// 条件跳转, 条件的值为jumpIf时跳转到label
+// && || ! 直接生成跳转, 不必先计算出布尔值
+//
+func generateConditionJump(cond Expression, jumpIf bool, label int, ob *OpCodeBuf) {
+	switch expr := cond.(type) {
+	case *BinaryExpression:
+		switch expr.operator {
+		case LogicalAndOperator, LogicalOrOperator:
+			// a && b 为false, 或者a || b 为true时, 只需要计算左值即可跳转
+			shortCircuit := expr.operator == LogicalOrOperator
+
+			if jumpIf == shortCircuit {
+				generateConditionJump(expr.left, jumpIf, label, ob)
+				generateConditionJump(expr.right, jumpIf, label, ob)
+			} else {
+				skipLabel := ob.GetLabel()
+				generateConditionJump(expr.left, shortCircuit, skipLabel, ob)
+				generateConditionJump(expr.right, jumpIf, label, ob)
+				ob.SetLabel(skipLabel)
+			}
+			return
+		}
+	case *UnaryExpression:
+		if expr.Operator == UnaryOperatorKindNot {
+			generateConditionJump(expr.Value, !jumpIf, label, ob)
+			return
+		}
+	}
+
+	cond.Generate(ob)
+
+	if jumpIf {
+		ob.GenerateCode(cond.Position(), vm.OP_CODE_JUMP_IF_TRUE, label)
+	} else {
+		ob.GenerateCode(cond.Position(), vm.OP_CODE_JUMP_IF_FALSE, label)
+	}
+}
+
 type UnaryExpression struct {
 	ExpressionBase
 	Operator UnaryOperatorKind
@@ -958,7 +1460,7 @@ func (expr *UnaryExpression) FixMinus() Expression {
 
 	expr.Value = expr.Value.Fix()
 
-	if !expr.Value.GetType().IsInt() && !expr.Value.GetType().IsFloat() {
+	if !expr.Value.GetType().IsInteger() && !expr.Value.GetType().IsFloat() {
 		compileError(expr.Position(), MINUS_TYPE_MISMATCH_ERR, "")
 	}
 
@@ -967,7 +1469,7 @@ func (expr *UnaryExpression) FixMinus() Expression {
 	// 如果值是常量,则直接转换
 	switch operand := expr.Value.(type) {
 	case *IntExpression:
-		operand.Value = -operand.Value
+		operand.Value = new(big.Int).Neg(operand.Value)
 		newExpr = operand
 		newExpr = newExpr.Fix()
 	case *FloatExpression:
@@ -1014,6 +1516,21 @@ func (expr *UnaryExpression) GenerateMinus(ob *OpCodeBuf) {
 		code = vm.OP_CODE_MINUS_FLOAT
 	}
 	ob.GenerateCode(expr.Position(), code)
+
+	generateTruncate(ob, expr.GetType(), expr.Position())
+}
+
+// 64位以下的整数以及float32, 运算后截断到对应的宽度和精度
+func generateTruncate(ob *OpCodeBuf, typ *Type, pos Position) {
+	if typ.IsFloat32() {
+		ob.GenerateCode(pos, castCodeMap[CastTypeFloatToFloat32])
+		return
+	}
+
+	castType, ok := truncateCastTypeMap[typ.GetBasicType()]
+	if ok {
+		ob.GenerateCode(pos, castCodeMap[castType])
+	}
 }
 
 func (expr *UnaryExpression) GenerateNot(ob *OpCodeBuf) {
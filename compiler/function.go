@@ -1,5 +1,9 @@
 package compiler
 
+import (
+	"github.com/lth-go/gogo/vm"
+)
+
 //
 // Parameter 形参
 //
@@ -26,16 +30,32 @@ func NewParameter(typ *Type, name string, ellipsis bool) *Parameter {
 //
 type FunctionDefinition struct {
 	Type            *Type
+	Path            string // 所在的源文件
 	PackageName     string
 	Name            string
 	Block           *Block
 	DeclarationList []*Declaration
 	CodeList        []byte
+	LineNumberList  []*vm.LineNumber
+	MaxStackDepth   int          // 操作数栈的最大深度
+	TypeParams      []*TypeParam // 泛型函数的类型参数
+	Source          string       // 泛型函数的源码, 实例化时重新解析
+	SourcePos       Position
+}
+
+// 原生函数没有函数体, 也不是泛型函数
+func (fd *FunctionDefinition) IsNative() bool {
+	return fd.Block == nil && !fd.IsGeneric()
+}
+
+// 泛型函数只在实例化后修正和生成字节码
+func (fd *FunctionDefinition) IsGeneric() bool {
+	return len(fd.TypeParams) > 0
 }
 
 // Fix
 func (fd *FunctionDefinition) Fix() {
-	if fd.Block == nil {
+	if fd.Block == nil || fd.IsGeneric() {
 		return
 	}
 
@@ -111,6 +131,11 @@ func (fd *FunctionDefinition) GetName() string {
 }
 
 // 拷贝函数定义的参数类型
+// 泛型函数的参数类型中含有类型参数, 实例化前没有确定的类型
 func (fd *FunctionDefinition) CopyType() *Type {
+	if fd.IsGeneric() {
+		return CreateType(BasicTypeFunc, fd.SourcePos)
+	}
+
 	return fd.Type.Copy()
 }
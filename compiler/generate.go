@@ -1,27 +1,38 @@
 package compiler
 
 import (
-	"encoding/binary"
-
-	"github.com/lth-go/gogo/utils"
 	"github.com/lth-go/gogo/vm"
 )
 
+//
+// OpCodeBuf 字节码缓冲
+//
+// 先保存指令, 跳转地址确定后再编码为字节码,
+// 操作数超出两个字节的范围时自动加上wide前缀
+//
 type OpCodeBuf struct {
-	codeList       []byte
-	labelTableList []*LabelTable
-	lineNumberList []*vm.LineNumber
+	instructionList []*instruction
+	labelTableList  []*LabelTable
+	lineNumberList  []*vm.LineNumber
+}
+
+type instruction struct {
+	pos      Position
+	code     byte
+	operands []int // 跳转指令的操作数为label
+	wide     bool
+	address  int
 }
 
 type LabelTable struct {
-	labelAddress int
+	labelAddress int // 指令下标, 编码时转为字节码地址
 }
 
 func NewOpCodeBuf() *OpCodeBuf {
 	ob := &OpCodeBuf{
-		codeList:       []byte{},
-		labelTableList: []*LabelTable{},
-		lineNumberList: []*vm.LineNumber{},
+		instructionList: []*instruction{},
+		labelTableList:  []*LabelTable{},
+		lineNumberList:  []*vm.LineNumber{},
 	}
 
 	return ob
@@ -35,7 +46,7 @@ func (ob *OpCodeBuf) GetLabel() int {
 
 func (ob *OpCodeBuf) SetLabel(label int) {
 	// 设置跳转
-	ob.labelTableList[label].labelAddress = len(ob.codeList)
+	ob.labelTableList[label].labelAddress = len(ob.instructionList)
 }
 
 //
@@ -45,73 +56,161 @@ func (ob *OpCodeBuf) GenerateCode(pos Position, code byte, rest ...int) {
 	// 获取参数类型
 	paramList := []byte(vm.OpcodeInfo[code].Parameter)
 
-	startPc := len(ob.codeList)
-	ob.codeList = append(ob.codeList, code)
+	inst := &instruction{
+		pos:      pos,
+		code:     code,
+		operands: rest[:len(paramList)],
+	}
 
 	for i, param := range paramList {
-		value := rest[i]
 		switch param {
-		// byte
 		case 'b':
-			ob.codeList = append(ob.codeList, byte(value))
-			// short(2byte int)
-		case 's':
-			b := make([]byte, 2)
-			binary.BigEndian.PutUint16(b, uint16(value))
-			ob.codeList = append(ob.codeList, b...)
-			// constant pool index
-		case 'p':
-			b := make([]byte, 2)
-			binary.BigEndian.PutUint16(b, uint16(value))
-			ob.codeList = append(ob.codeList, b...)
+			if rest[i] < 0 || rest[i] > 255 {
+				compileError(pos, OPERAND_OVERFLOW_ERR, vm.OpcodeInfo[code].Mnemonic, rest[i])
+			}
+		case 's', 'p':
+			inst.fixWide(rest[i])
+		case 'l':
+			// 跳转地址在编码时确定
 		default:
-			panic("TODO")
+			// 参数类型来自vm.OpcodeInfo, 不会出现其它值
+			panic("invalid operand type " + string(param))
 		}
 	}
-	ob.AddLineNumber(pos.Line, startPc)
+
+	ob.instructionList = append(ob.instructionList, inst)
 }
 
-func (ob *OpCodeBuf) AddLineNumber(lineNumber int, startPc int) {
+// 操作数超出两个字节时使用wide前缀, 超出四个字节时报错
+func (inst *instruction) fixWide(value int) {
+	if value >= vm.MinShortOperand && value <= vm.MaxShortOperand {
+		return
+	}
+
+	if value < vm.MinWideOperand || value > vm.MaxWideOperand {
+		compileError(inst.pos, OPERAND_OVERFLOW_ERR, vm.OpcodeInfo[inst.code].Mnemonic, value)
+	}
+
+	inst.wide = true
+}
+
+func (inst *instruction) size() int {
+	size := 1
+	if inst.wide {
+		size++
+	}
+
+	for _, param := range []byte(vm.OpcodeInfo[inst.code].Parameter) {
+		size += vm.OperandSize(param, inst.wide)
+	}
+
+	return size
+}
+
+func (ob *OpCodeBuf) AddLineNumber(lineNumber int, startPc int, endPc int) {
 
 	if len(ob.lineNumberList) == 0 || ob.lineNumberList[len(ob.lineNumberList)-1].LineNumber != lineNumber {
 		newLineNumber := &vm.LineNumber{
 			LineNumber: lineNumber,
 			StartPc:    startPc,
-			PcCount:    len(ob.codeList) - startPc,
+			PcCount:    endPc - startPc,
 		}
 		ob.lineNumberList = append(ob.lineNumberList, newLineNumber)
 	} else {
 		// 源代码中相同的一行
 		topLineNumber := ob.lineNumberList[len(ob.lineNumberList)-1]
-		topLineNumber.PcCount += len(ob.codeList) - startPc
+		topLineNumber.PcCount += endPc - startPc
+	}
+}
+
+// 修正本地变量在栈上的位置
+// 栈上依次为: 形参, 返回信息, 本地声明
+func (ob *OpCodeBuf) FixStackIndex(paramCount int) {
+	for _, inst := range ob.instructionList {
+		switch inst.code {
+		case vm.OP_CODE_PUSH_STACK, vm.OP_CODE_POP_STACK:
+			idx := inst.operands[0]
+			if idx >= paramCount {
+				inst.operands[0] = idx - paramCount + 1
+			} else {
+				inst.operands[0] = idx - paramCount
+			}
+			inst.fixWide(inst.operands[0])
+		}
 	}
 }
 
-// 修正label, 将正确的跳转地址填入
+// 修正label, 将正确的跳转地址填入, 返回字节码
 func (ob *OpCodeBuf) FixLabel() []byte {
-	for i := 0; i < len(ob.codeList); i++ {
-		if ob.codeList[i] == vm.OP_CODE_JUMP ||
-			ob.codeList[i] == vm.OP_CODE_JUMP_IF_TRUE ||
-			ob.codeList[i] == vm.OP_CODE_JUMP_IF_FALSE {
-
-			label := utils.Get2ByteInt(ob.codeList[i+1:])
-			address := ob.labelTableList[label].labelAddress
-			utils.Set2ByteInt(ob.codeList[i+1:], address)
+	// 跳转地址超出两个字节时加上wide前缀, 指令变长后重新计算地址
+	for {
+		ob.fixAddress()
+
+		changed := false
+		for _, inst := range ob.instructionList {
+			if !inst.wide && inst.isJump() && ob.getJumpAddress(inst) > vm.MaxShortOperand {
+				inst.wide = true
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
 		}
-		info := vm.OpcodeInfo[ob.codeList[i]]
-		for _, p := range []byte(info.Parameter) {
-			switch p {
-			case 'b':
-				i++
-			case 's', 'p':
-				i += 2
-			default:
-				panic("param error")
+	}
+
+	codeList := []byte{}
+
+	for _, inst := range ob.instructionList {
+		operands := inst.operands
+		if inst.isJump() {
+			address := ob.getJumpAddress(inst)
+			if address > vm.MaxWideOperand {
+				compileError(inst.pos, OPERAND_OVERFLOW_ERR, vm.OpcodeInfo[inst.code].Mnemonic, address)
 			}
+			operands = []int{address}
 		}
+
+		if inst.wide {
+			codeList = append(codeList, vm.OP_CODE_WIDE)
+		}
+		codeList = append(codeList, inst.code)
+
+		for i, param := range []byte(vm.OpcodeInfo[inst.code].Parameter) {
+			size := vm.OperandSize(param, inst.wide)
+			b := make([]byte, size)
+			vm.SetOperand(b, size, operands[i])
+			codeList = append(codeList, b...)
+		}
+
+		ob.AddLineNumber(inst.pos.Line, inst.address, len(codeList))
 	}
 
 	ob.labelTableList = nil
 
-	return ob.codeList
+	return codeList
+}
+
+// 计算每条指令的地址
+func (ob *OpCodeBuf) fixAddress() {
+	address := 0
+	for _, inst := range ob.instructionList {
+		inst.address = address
+		address += inst.size()
+	}
+}
+
+// label指向的指令地址, label可能指向最后一条指令之后
+func (ob *OpCodeBuf) getJumpAddress(inst *instruction) int {
+	index := ob.labelTableList[inst.operands[0]].labelAddress
+	if index == len(ob.instructionList) {
+		last := ob.instructionList[index-1]
+		return last.address + last.size()
+	}
+
+	return ob.instructionList[index].address
+}
+
+func (inst *instruction) isJump() bool {
+	return vm.OpcodeInfo[inst.code].Parameter == "l"
 }
@@ -0,0 +1,438 @@
+package compiler
+
+import (
+	"strings"
+)
+
+//
+// TypeParam 类型参数, eg: [T any], [K comparable, V Number]
+//
+type TypeParam struct {
+	Name       string
+	Constraint *Type
+}
+
+func CreateTypeParamList(nameList []string, constraint *Type) []*TypeParam {
+	typeParamList := make([]*TypeParam, 0)
+
+	for _, name := range nameList {
+		typeParamList = append(typeParamList, &TypeParam{
+			Name:       name,
+			Constraint: constraint,
+		})
+	}
+
+	return typeParamList
+}
+
+// 类型参数与类型实参一一对应, 并检查约束
+func CreateTypeArgMap(typeParams []*TypeParam, typeArgs []*Type, packageName string, pos Position) map[string]*Type {
+	typeArgMap := make(map[string]*Type)
+
+	for i, typeParam := range typeParams {
+		typeArgMap[typeParam.Name] = typeArgs[i]
+	}
+
+	for i, typeParam := range typeParams {
+		// 约束中可以引用其它类型参数, eg: [S ~[]E, E any]
+		constraint := typeParam.Constraint.substitute(packageName, typeArgMap)
+		if !typeArgs[i].Satisfies(constraint) {
+			compileError(pos, TYPE_CONSTRAINT_UNSATISFIED_ERR, typeArgs[i].GetTypeName(), typeParam.Name, constraint.GetTypeName())
+		}
+	}
+
+	return typeArgMap
+}
+
+// 实例名, eg: [int,string]
+func GetTypeArgListName(typeArgs []*Type) string {
+	nameList := make([]string, 0)
+
+	for _, typeArg := range typeArgs {
+		nameList = append(nameList, typeArg.GetTypeName())
+	}
+
+	return "[" + strings.Join(nameList, ",") + "]"
+}
+
+func copyTypeList(typeList []*Type) []*Type {
+	if typeList == nil {
+		return nil
+	}
+
+	newTypeList := make([]*Type, len(typeList))
+
+	for i, typ := range typeList {
+		newTypeList[i] = typ.Copy()
+	}
+
+	return newTypeList
+}
+
+//
+// 将类型参数替换为类型实参
+// 类型参数所在的泛型还未实例化, 不能解析自定义类型
+//
+func (t *Type) substitute(packageName string, typeArgMap map[string]*Type) *Type {
+	if t == nil {
+		return nil
+	}
+
+	if t.IsNamed() && len(t.typeArgs) == 0 && t.packageName == packageName {
+		if typeArg, ok := typeArgMap[t.name]; ok {
+			newType := typeArg.Copy()
+			newType.SetPosition(t.Position())
+			return newType
+		}
+	}
+
+	newType := *t
+
+	newType.typeArgs = nil
+	for _, typeArg := range t.typeArgs {
+		newType.typeArgs = append(newType.typeArgs, typeArg.substitute(packageName, typeArgMap))
+	}
+
+	substituteParams := func(params []*Parameter) []*Parameter {
+		newParams := []*Parameter{}
+
+		for _, p := range params {
+			newParams = append(newParams, &Parameter{
+				Type:     p.Type.substitute(packageName, typeArgMap),
+				Name:     p.Name,
+				Ellipsis: p.Ellipsis,
+			})
+		}
+		return newParams
+	}
+
+	if t.arrayType != nil {
+		newType.arrayType = NewArrayType(t.arrayType.ElementType.substitute(packageName, typeArgMap))
+	}
+
+	if t.mapType != nil {
+		newType.mapType = NewMapType(
+			t.mapType.Key.substitute(packageName, typeArgMap),
+			t.mapType.Value.substitute(packageName, typeArgMap),
+		)
+	}
+
+	if t.funcType != nil {
+		newType.funcType = NewFuncType(substituteParams(t.funcType.Params), substituteParams(t.funcType.Results))
+	}
+
+	if t.structType != nil {
+		fieldList := make([]*StructField, 0)
+		for _, field := range t.structType.Fields {
+			fieldList = append(fieldList, CreateFieldDecl(field.Name, field.Type.substitute(packageName, typeArgMap)))
+		}
+		newType.structType = NewStructType(fieldList)
+	}
+
+	if t.interfaceType != nil {
+		newType.interfaceType = &InterfaceType{Comparable: t.interfaceType.Comparable}
+		for _, term := range t.interfaceType.Terms {
+			newType.interfaceType.Terms = append(
+				newType.interfaceType.Terms,
+				CreateTypeTerm(term.Type.substitute(packageName, typeArgMap), term.Tilde),
+			)
+		}
+	}
+
+	return &newType
+}
+
+// 类型实参是否满足约束
+func (t *Type) Satisfies(constraint *Type) bool {
+	// 非接口约束, eg: [T int]
+	if !constraint.IsInterface() {
+		return !t.IsInterface() && t.Equal(constraint)
+	}
+
+	it := constraint.interfaceType
+	if it == nil {
+		return true
+	}
+
+	if it.Comparable && !t.IsComparable() {
+		return false
+	}
+
+	if len(it.Terms) == 0 {
+		return true
+	}
+
+	if t.IsInterface() {
+		return false
+	}
+
+	for _, term := range it.Terms {
+		switch {
+		case term.Type.IsInterface():
+			// 嵌入其它约束, eg: interface { Integer | Float }
+			if t.Satisfies(term.Type) {
+				return true
+			}
+		case term.Tilde:
+			if t.UnderlyingEqual(term.Type) {
+				return true
+			}
+		default:
+			if t.Equal(term.Type) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//
+// 类型推导
+//
+
+// 类型推导的中间结果
+type typeInference struct {
+	fd         *FunctionDefinition
+	typeArgMap map[string]*Type
+	typedSet   map[string]bool // 由有类型的实参推导出的类型参数
+	untyped    bool            // 是否正在使用无类型常量推导
+}
+
+// 根据实参类型推导泛型函数的类型实参
+// 先使用有类型的实参推导, 无类型常量最后使用默认类型, eg: Max(f, 1)
+func (fd *FunctionDefinition) InferTypeArgs(argumentList []Expression, pos Position) []*Type {
+	inference := &typeInference{
+		fd:         fd,
+		typeArgMap: make(map[string]*Type),
+		typedSet:   make(map[string]bool),
+	}
+
+	for _, untyped := range []bool{false, true} {
+		inference.untyped = untyped
+
+		for i, arg := range argumentList {
+			if isUntypedConstant(arg) != untyped || isNilExpression(arg) {
+				continue
+			}
+
+			paramType := fd.getParamType(i)
+			if paramType == nil {
+				continue
+			}
+
+			inference.unify(paramType, arg.GetType(), arg.Position())
+		}
+
+		if !untyped {
+			for name := range inference.typeArgMap {
+				inference.typedSet[name] = true
+			}
+		}
+	}
+
+	typeArgs := make([]*Type, 0)
+
+	for _, typeParam := range fd.TypeParams {
+		typeArg, ok := inference.typeArgMap[typeParam.Name]
+		if !ok {
+			compileError(pos, CANNOT_INFER_TYPE_ARGUMENT_ERR, fd.Name, typeParam.Name)
+		}
+		typeArgs = append(typeArgs, typeArg)
+	}
+
+	return typeArgs
+}
+
+// 第i个实参对应的形参类型, 可变参数对应数组的元素类型
+func (fd *FunctionDefinition) getParamType(i int) *Type {
+	params := fd.Type.funcType.Params
+
+	if len(params) > 0 && params[len(params)-1].Ellipsis && i >= len(params)-1 {
+		return params[len(params)-1].Type.arrayType.ElementType
+	}
+
+	if i >= len(params) {
+		return nil
+	}
+
+	return params[i].Type
+}
+
+func (fd *FunctionDefinition) getTypeParam(typ *Type) *TypeParam {
+	if !typ.IsNamed() || len(typ.typeArgs) > 0 || typ.packageName != fd.PackageName {
+		return nil
+	}
+
+	for _, typeParam := range fd.TypeParams {
+		if typeParam.Name == typ.name {
+			return typeParam
+		}
+	}
+
+	return nil
+}
+
+// 比较形参类型与实参类型的结构, 确定类型参数
+// 结构不一致时不报错, 实例化后检查实参类型
+func (inference *typeInference) unify(paramType *Type, argType *Type, pos Position) {
+	if typeParam := inference.fd.getTypeParam(paramType); typeParam != nil {
+		typeArg, ok := inference.typeArgMap[typeParam.Name]
+		if !ok {
+			inference.typeArgMap[typeParam.Name] = argType.Copy()
+			return
+		}
+
+		if inference.untyped {
+			// 只由整数常量推导出时, 浮点数常量改为推导出float, eg: Max(1, 2.5)
+			if !inference.typedSet[typeParam.Name] && typeArg.IsInt() && argType.IsFloat() {
+				inference.typeArgMap[typeParam.Name] = argType.Copy()
+			}
+			// 其余无类型常量转为已推导出的类型
+			return
+		}
+
+		if !typeArg.Equal(argType) {
+			compileError(pos, TYPE_ARGUMENT_CONFLICT_ERR, typeParam.Name, typeArg.GetTypeName(), argType.GetTypeName())
+		}
+		return
+	}
+
+	// 泛型类型, eg: Stack[T]
+	if paramType.IsNamed() {
+		if paramType.name != argType.name || paramType.packageName != argType.packageName {
+			return
+		}
+		if len(paramType.typeArgs) != len(argType.typeArgs) {
+			return
+		}
+		for i := range paramType.typeArgs {
+			inference.unify(paramType.typeArgs[i], argType.typeArgs[i], pos)
+		}
+		return
+	}
+
+	switch paramType.basicType {
+	case BasicTypeArray:
+		if argType.IsArray() {
+			inference.unify(paramType.arrayType.ElementType, argType.arrayType.ElementType, pos)
+		}
+	case BasicTypeMap:
+		if argType.IsMap() {
+			inference.unify(paramType.mapType.Key, argType.mapType.Key, pos)
+			inference.unify(paramType.mapType.Value, argType.mapType.Value, pos)
+		}
+	case BasicTypeFunc:
+		if !argType.IsFunc() || argType.funcType == nil {
+			return
+		}
+		if len(paramType.funcType.Params) != len(argType.funcType.Params) {
+			return
+		}
+		if len(paramType.funcType.Results) != len(argType.funcType.Results) {
+			return
+		}
+		for i, p := range paramType.funcType.Params {
+			inference.unify(p.Type, argType.funcType.Params[i].Type, pos)
+		}
+		for i, p := range paramType.funcType.Results {
+			inference.unify(p.Type, argType.funcType.Results[i].Type, pos)
+		}
+	}
+}
+
+// 字面量常量, 类型为默认类型
+func isUntypedConstant(expr Expression) bool {
+	typ := expr.GetType()
+	if typ.IsNamed() {
+		return false
+	}
+
+	switch expr.(type) {
+	case *IntExpression:
+		return typ.IsInt()
+	case *FloatExpression:
+		return typ.GetBasicType() == BasicTypeFloat
+	case *StringExpression, *BoolExpression, *NilExpression:
+		return true
+	}
+
+	return false
+}
+
+//
+// 实例化
+//
+
+// 以类型实参实例化泛型函数, 相同的类型实参只实例化一次
+// 实例化时以实际类型重新解析函数源码, 并添加到函数列表
+func (c *Compiler) InstantiateFunction(fd *FunctionDefinition, typeArgs []*Type, pos Position) (*FunctionDefinition, int) {
+	if len(fd.TypeParams) != len(typeArgs) {
+		compileError(pos, TYPE_ARGUMENT_COUNT_MISMATCH_ERR, fd.Name, len(fd.TypeParams), len(typeArgs))
+	}
+
+	CreateTypeArgMap(fd.TypeParams, typeArgs, fd.PackageName, pos)
+
+	name := fd.Name + GetTypeArgListName(typeArgs)
+
+	instance, index := c.SearchFunction(fd.PackageName, name)
+	if instance != nil {
+		return instance, index
+	}
+
+	pkg := c.GetDoneCompiler(fd.PackageName)
+	genericPkg := NewGenericPackage(pkg, fd, typeArgs)
+
+	c.PushCurrentCompiler(genericPkg)
+	genericPkg.parse()
+	c.PopCurrentCompiler()
+
+	instance = genericPkg.funcList[0]
+	instance.Name = name
+
+	// 先加入函数列表, 函数体中可以递归调用自身
+	c.FuncList = append(c.FuncList, instance)
+	index = len(c.FuncList) - 1
+
+	path := pkg.path
+	pkg.path = instance.Path
+
+	c.PushCurrentCompiler(pkg)
+	instance.Fix()
+	c.PopCurrentCompiler()
+
+	pkg.path = path
+
+	return instance, index
+}
+
+// 泛型函数标识符, 未实例化时返回函数定义
+func getGenericFunction(expr Expression) *FunctionDefinition {
+	identifierExpr, ok := expr.(*IdentifierExpression)
+	if !ok {
+		return nil
+	}
+
+	funcIdentifier, ok := identifierExpr.Obj.(*FunctionIdentifier)
+	if !ok || !funcIdentifier.Func.IsGeneric() {
+		return nil
+	}
+
+	return funcIdentifier.Func
+}
+
+// 创建指向泛型函数实例的标识符
+func createInstanceExpression(pos Position, fd *FunctionDefinition, typeArgs []*Type) Expression {
+	instance, index := GetCurrentCompiler().InstantiateFunction(fd, typeArgs, pos)
+
+	expr := CreateIdentifierExpression(pos, instance.Name)
+	expr.PackageName = instance.PackageName
+	expr.Obj = &FunctionIdentifier{
+		Func:  instance,
+		Index: index,
+	}
+	expr.SetType(instance.CopyType())
+	expr.GetType().Fix()
+
+	return expr
+}
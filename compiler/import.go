@@ -3,6 +3,7 @@ package compiler
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -11,37 +12,154 @@ const (
 
 type Import struct {
 	PosBase
-	packageName string
+	filename    string   // import语句所在的源文件
+	path        string   // 导入路径, eg: company/net/proto, ./proto
+	name        string   // 源码中引用包时使用的名字, 默认为导入路径的最后一个元素
+	packageName string   // 包的唯一标识, 同一个目录以不同写法导入时相同
+	pathList    []string // 包的源文件列表
 }
 
-// 获取导入文件的相对路径
-func (i *Import) getRelativePath() string {
-	return i.packageName + importSuffix
+// 相对路径从import语句所在的源文件开始查找
+func (i *Import) IsRelative() bool {
+	return strings.HasPrefix(i.path, "./") || strings.HasPrefix(i.path, "../")
 }
 
-func (i *Import) GetPath() string {
-	searchBasePath := os.Getenv("IMPORT_SEARCH_PATH")
-	if searchBasePath == "" {
-		searchBasePath = "."
+// 包的唯一标识, 不同路径下的同名包互不冲突
+// 查找源文件之后, 同一个目录的包使用第一次导入时的标识
+func (i *Import) GetPackageName() string {
+	if i.packageName != "" {
+		return i.packageName
 	}
 
-	relativePath := i.getRelativePath()
+	if i.IsRelative() {
+		return filepath.Join(filepath.Dir(i.filename), filepath.FromSlash(i.path))
+	}
+
+	return i.path
+}
 
-	fullPath := filepath.Join(searchBasePath, relativePath)
-	_, err := os.Stat(fullPath)
+// 获取导入包的源文件列表
+func (i *Import) GetPathList() []string {
+	if i.pathList == nil {
+		i.resolve()
+	}
+
+	return i.pathList
+}
+
+// 查找包的源文件, 并按包所在目录的绝对路径确定包的唯一标识
+// 内置包优先, 然后依次在IMPORT_SEARCH_PATH(冒号分隔)的各个目录下查找
+// 包为目录时使用目录下所有的源文件, 否则使用同名的源文件
+func (i *Import) resolve() {
+	fullPath := ""
+
+	if i.IsRelative() {
+		fullPath = i.GetPackageName()
+		i.pathList = searchSourceFileList(fullPath)
+	} else if pathList := searchBuiltinSourceFileList(i.path); pathList != nil {
+		fullPath = builtinPathPrefix + i.path
+		i.pathList = pathList
+	} else {
+		for _, searchBasePath := range GetImportSearchPathList() {
+			fullPath = filepath.Join(searchBasePath, filepath.FromSlash(i.path))
+			if i.pathList = searchSourceFileList(fullPath); i.pathList != nil {
+				break
+			}
+		}
+	}
+
+	if i.pathList == nil {
+		compileError(i.Position(), REQUIRE_FILE_NOT_FOUND_ERR, i.path)
+	}
+
+	i.packageName = GetCurrentCompiler().registerPackage(packageDir(fullPath), i.GetPackageName())
+}
+
+// 包所在目录的绝对路径, 单个源文件的包为去掉后缀的文件路径
+func packageDir(fullPath string) string {
+	if strings.HasPrefix(fullPath, builtinPathPrefix) {
+		return fullPath
+	}
+
+	dir, err := filepath.Abs(strings.TrimSuffix(fullPath, importSuffix))
 	if err != nil {
-		compileError(i.Position(), REQUIRE_FILE_NOT_FOUND_ERR, fullPath)
+		return filepath.Clean(fullPath)
 	}
 
-	return fullPath
+	return dir
+}
+
+func GetImportSearchPathList() []string {
+	searchPathList := []string{}
+
+	for _, searchBasePath := range filepath.SplitList(os.Getenv("IMPORT_SEARCH_PATH")) {
+		if searchBasePath != "" {
+			searchPathList = append(searchPathList, searchBasePath)
+		}
+	}
+
+	if len(searchPathList) == 0 {
+		searchPathList = append(searchPathList, ".")
+	}
+
+	return searchPathList
+}
+
+// 包目录下的所有源文件, 或者同名的单个源文件, 都不存在时返回nil
+func searchSourceFileList(fullPath string) []string {
+	info, err := os.Stat(fullPath)
+	if err == nil && info.IsDir() {
+		pathList := GetSourceFileList(fullPath)
+		if len(pathList) == 0 {
+			return nil
+		}
+		return pathList
+	}
+
+	fullPath += importSuffix
+
+	info, err = os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	return []string{fullPath}
+}
+
+// 目录下的源文件, 按文件名排序
+func GetSourceFileList(dir string) []string {
+	entryList, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	pathList := []string{}
+
+	for _, entry := range entryList {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != importSuffix {
+			continue
+		}
+		pathList = append(pathList, filepath.Join(dir, entry.Name()))
+	}
+
+	return pathList
 }
 
 func CreateImportList(importSpec *Import) []*Import {
 	return []*Import{importSpec}
 }
 
-func CreateImport(packageName string) *Import {
-	return &Import{
-		packageName: packageName,
+func CreateImport(pos Position, name string, path string) *Import {
+	if name == "" {
+		name = path[strings.LastIndex(path, "/")+1:]
 	}
+
+	imp := &Import{
+		filename: GetCurrentPackage().path,
+		path:     path,
+		name:     name,
+	}
+	imp.SetPosition(pos)
+
+	return imp
 }
@@ -0,0 +1,268 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/lth-go/gogo/vm"
+)
+
+//
+// 全局变量初始化
+//
+// 被导入的包先于导入它的包初始化, 同一个包内按声明顺序,
+// 依次选出第一个依赖都已初始化的全局变量, 全局变量初始化之后再调用init函数
+//
+type initializer struct {
+	compiler    *Compiler
+	declRefs    map[*Declaration][]int // 全局变量初始值直接引用的全局变量和函数
+	funcRefs    map[int][]int          // 函数直接引用的全局变量和函数
+	initialized map[*Declaration]bool
+}
+
+// 引用的函数和全局变量统一编号, 全局变量为下标, 函数为负数
+// 函数下标与编号的转换互为逆运算
+func funcRef(index int) int {
+	return -index - 1
+}
+
+func (c *Compiler) GenerateInitCode(ob *OpCodeBuf) {
+	in := &initializer{
+		compiler:    c,
+		declRefs:    map[*Declaration][]int{},
+		funcRefs:    map[int][]int{},
+		initialized: map[*Declaration]bool{},
+	}
+
+	for _, pkg := range c.getInitPackageList() {
+		c.PushCurrentCompiler(pkg)
+
+		for _, decl := range in.sortDeclarationList(pkg) {
+			decl.Value.Generate(ob)
+			generatePopToIdentifier(decl, decl.Position(), ob)
+		}
+
+		// 调用init函数
+		for index, f := range c.FuncList {
+			if f.PackageName == pkg.GetPackageName() && f.Name == "init" {
+				ob.GenerateCode(Position{}, vm.OP_CODE_PUSH_FUNCTION, index)
+				ob.GenerateCode(Position{}, vm.OP_CODE_INVOKE, 0)
+			}
+		}
+
+		c.PopCurrentCompiler()
+	}
+}
+
+// 包的初始化顺序, 按import的顺序深度优先, 被导入的包排在前面
+func (c *Compiler) getInitPackageList() []*Package {
+	pkgList := []*Package{}
+	visited := map[*Package]bool{}
+
+	var visit func(pkg *Package)
+	visit = func(pkg *Package) {
+		visited[pkg] = true
+
+		for _, imp := range pkg.importList {
+			dep := c.GetDoneCompiler(imp.GetPackageName())
+			if !visited[dep] {
+				visit(dep)
+			}
+		}
+
+		pkgList = append(pkgList, pkg)
+	}
+
+	// doneList的第一个为入口包
+	visit(c.doneList[0])
+
+	return pkgList
+}
+
+// 包内全局变量的初始化顺序
+func (in *initializer) sortDeclarationList(pkg *Package) []*Declaration {
+	declList := []*Declaration{}
+
+	pending := make([]*Declaration, len(pkg.declarationList))
+	copy(pending, pkg.declarationList)
+
+	for len(pending) > 0 {
+		ready := -1
+
+		for i, decl := range pending {
+			if in.isReady(decl) {
+				ready = i
+				break
+			}
+		}
+
+		if ready == -1 {
+			in.cycleError(pkg)
+		}
+
+		decl := pending[ready]
+		in.initialized[decl] = true
+		declList = append(declList, decl)
+		pending = append(pending[:ready], pending[ready+1:]...)
+	}
+
+	return declList
+}
+
+// 依赖的全局变量都已初始化, 其它包的全局变量已经先初始化
+func (in *initializer) isReady(decl *Declaration) bool {
+	for _, dep := range in.getDeclDependencyList(decl) {
+		if dep.PackageName == decl.PackageName && !in.initialized[dep] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 初始值直接或者通过函数间接引用的全局变量
+func (in *initializer) getDeclDependencyList(decl *Declaration) []*Declaration {
+	depList := []*Declaration{}
+	visited := map[int]bool{}
+
+	var visit func(refList []int)
+	visit = func(refList []int) {
+		for _, ref := range refList {
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+
+			if ref >= 0 {
+				depList = append(depList, in.compiler.DeclarationList[ref])
+			} else {
+				visit(in.getFuncRefList(funcRef(ref)))
+			}
+		}
+	}
+
+	visit(in.getDeclRefList(decl))
+
+	return depList
+}
+
+func (in *initializer) getDeclRefList(decl *Declaration) []int {
+	refList, ok := in.declRefs[decl]
+	if !ok {
+		ob := NewOpCodeBuf()
+		decl.Value.Generate(ob)
+		refList = getCodeRefList(ob.FixLabel())
+		in.declRefs[decl] = refList
+	}
+
+	return refList
+}
+
+func (in *initializer) getFuncRefList(index int) []int {
+	refList, ok := in.funcRefs[index]
+	if !ok {
+		refList = getCodeRefList(in.compiler.FuncList[index].CodeList)
+		in.funcRefs[index] = refList
+	}
+
+	return refList
+}
+
+// 字节码中引用的全局变量和函数
+func getCodeRefList(codeList []byte) []int {
+	refList := []int{}
+
+	for pc := 0; pc < len(codeList); {
+		inst := vm.DecodeInstruction(codeList, pc)
+
+		switch inst.Code {
+		case vm.OP_CODE_PUSH_STATIC, vm.OP_CODE_POP_STATIC:
+			refList = append(refList, inst.Operands[0])
+		case vm.OP_CODE_PUSH_FUNCTION:
+			refList = append(refList, funcRef(inst.Operands[0]))
+		}
+
+		pc += inst.Size
+	}
+
+	return refList
+}
+
+// 初始化循环, 找出一条包含全局变量的引用链
+func (in *initializer) cycleError(pkg *Package) {
+	c := in.compiler
+
+	refPath := []int{}
+	onPath := map[int]int{}
+	visited := map[int]bool{}
+
+	var find func(ref int) bool
+	find = func(ref int) bool {
+		if start, ok := onPath[ref]; ok {
+			// 只经过函数的循环是递归调用, 不是初始化循环
+			for _, r := range refPath[start:] {
+				if r >= 0 {
+					refPath = append(refPath[start:], ref)
+					return true
+				}
+			}
+			return false
+		}
+		if visited[ref] {
+			return false
+		}
+		visited[ref] = true
+
+		var refList []int
+		if ref >= 0 {
+			decl := c.DeclarationList[ref]
+			if decl.PackageName != pkg.GetPackageName() || in.initialized[decl] {
+				return false
+			}
+			refList = in.getDeclRefList(decl)
+		} else {
+			refList = in.getFuncRefList(funcRef(ref))
+		}
+
+		onPath[ref] = len(refPath)
+		refPath = append(refPath, ref)
+
+		for _, next := range refList {
+			if find(next) {
+				return true
+			}
+		}
+
+		delete(onPath, ref)
+		refPath = refPath[:len(refPath)-1]
+
+		return false
+	}
+
+	for _, decl := range pkg.declarationList {
+		if !in.initialized[decl] && find(decl.Index) {
+			break
+		}
+	}
+
+	// 引用链从循环中的第一个全局变量开始, eg: a -> f -> a
+	start := 0
+	for refPath[start] < 0 {
+		start++
+	}
+	refPath = append(refPath[start:len(refPath)-1], refPath[:start+1]...)
+
+	nameList := []string{}
+	for _, ref := range refPath {
+		if ref >= 0 {
+			nameList = append(nameList, c.DeclarationList[ref].Name)
+		} else {
+			nameList = append(nameList, c.FuncList[funcRef(ref)].Name)
+		}
+	}
+
+	decl := c.DeclarationList[refPath[0]]
+
+	// 用于报错时显示声明所在的源文件
+	pkg.path = decl.Path
+	compileError(decl.Position(), INITIALIZATION_CYCLE_ERR, strings.Join(nameList, " -> "))
+}
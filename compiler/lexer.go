@@ -39,6 +39,12 @@ func NewLexer(path string) *Lexer {
 	}
 }
 
+func newLexerBySource(prefix string, src string, pos Position) *Lexer {
+	return &Lexer{
+		s: newScannerBySource(prefix, src, pos),
+	}
+}
+
 // Lex scans the token and literals.
 func (l *Lexer) Lex(lval *yySymType) int {
 	tok, lit, pos, err := l.s.Scan()
@@ -68,5 +74,9 @@ func (l *Lexer) show() {
 
 // Error sets parse error.
 func (l *Lexer) Error(msg string) {
+	// 优先报告词法错误
+	if l.e != nil {
+		return
+	}
 	l.e = &Error{Message: msg, Pos: l.pos, Fatal: false}
 }
@@ -15,20 +15,31 @@ func createNativeFuncParamTypeList(typeList []BasicType) []*Parameter {
 			p.Type.arrayType = NewArrayType(NewType(BasicTypeInterface))
 		}
 
+		if p.Type.IsMap() {
+			p.Type.mapType = NewMapType(NewType(BasicTypeInterface), NewType(BasicTypeInterface))
+		}
+
 		list = append(list, p)
 	}
 
 	return list
 }
 
+// 顺序与虚拟机添加原生函数的顺序一致
 func (c *Compiler) AddNativeFunctionList() {
 	c.AddNativeFunctionPrintf()
 	c.AddNativeFunctionLen()
 	c.AddNativeFunctionAppend()
 	c.AddNativeFunctionDelete()
+	c.AddNativeFunctionRuntime()
 }
 
 func (c *Compiler) AddNativeFunc(name string, pType, rType []BasicType, ellipsis bool) {
+	c.AddPackageNativeFunc("_sys", name, pType, rType, ellipsis)
+}
+
+// 内置包中的原生函数, 只能通过包名引用
+func (c *Compiler) AddPackageNativeFunc(packageName string, name string, pType, rType []BasicType, ellipsis bool) {
 	paramsType := createNativeFuncParamTypeList(pType)
 	resultsType := createNativeFuncParamTypeList(rType)
 
@@ -39,7 +50,7 @@ func (c *Compiler) AddNativeFunc(name string, pType, rType []BasicType, ellipsis
 	fd := &FunctionDefinition{
 		Type:            CreateFuncType(paramsType, resultsType),
 		Name:            name,
-		PackageName:     "_sys",
+		PackageName:     packageName,
 		Block:           nil,
 		DeclarationList: nil,
 	}
@@ -91,3 +102,12 @@ func (c *Compiler) AddNativeFunctionDelete() {
 		false,
 	)
 }
+
+// runtime包的原生函数, ReadMemStats等由runtime包的源码实现
+func (c *Compiler) AddNativeFunctionRuntime() {
+	c.AddPackageNativeFunc("runtime", "GC", nil, nil, false)
+	c.AddPackageNativeFunc("runtime", "NumGoroutine", nil, []BasicType{BasicTypeInt}, false)
+	c.AddPackageNativeFunc("runtime", "readMemStats", nil, nil, false)
+	c.AddPackageNativeFunc("runtime", "memStat", []BasicType{BasicTypeString}, []BasicType{BasicTypeInt}, false)
+	c.AddPackageNativeFunc("runtime", "objectCount", []BasicType{BasicTypeString}, []BasicType{BasicTypeInt}, false)
+}
@@ -2,38 +2,93 @@ package compiler
 
 import (
 	"log"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Package struct {
 	lexer           *Lexer                // 词法解析器
-	path            string                // 源文件路径
-	packageName     string                // 包名
-	importList      []*Import             // 依赖的包
+	path            string                // 当前的源文件路径
+	pathList        []string              // 包内所有的源文件
+	packageName     string                // 包的唯一标识, 入口包为main, 其它包为导入路径
+	name            string                // package语句声明的包名
+	importList      []*Import             // 依赖的包, 各文件导入的包合并后去重
+	fileImportMap   map[string][]*Import  // 各源文件的import, 包名只在所在的文件中有效
 	funcList        []*FunctionDefinition // 函数列表
 	declarationList []*Declaration        // 声明列表
 	typeDefList     []*TypeDefDecl        // 类型声明列表
 	currentBlock    *Block                // 当前块
+	typeArgs        map[string]*Type      // 泛型实例化时, 类型参数对应的实际类型
 }
 
 func (c *Package) GetPackageName() string {
 	return c.packageName
 }
 
-func (c *Package) SetPackageName(packageName string) {
-	c.packageName = packageName
+func (c *Package) GetName() string {
+	return c.name
 }
 
+func (c *Package) SetName(name string) {
+	c.name = name
+}
+
+// 依次解析包内的源文件, 声明合并到同一个包中
 func (c *Package) Parse() {
-	if yyParse(c.lexer) != 0 {
+	for _, path := range c.pathList {
+		c.path = path
+		c.lexer = NewLexer(path)
+		c.currentBlock = nil
+
+		c.parse()
+	}
+}
+
+func (c *Package) parse() {
+	// 词法错误出现在文件末尾时, 语法分析仍可能成功
+	if yyParse(c.lexer) != 0 || c.lexer.e != nil {
 		log.Fatalf("\nFileName: %s%s", c.path, c.lexer.e)
 	}
 }
 
-func NewPackage(path string) *Package {
+// 查找导入了packageName的import语句
+func (c *Package) searchImport(packageName string) *Import {
+	for _, imp := range c.importList {
+		if imp.GetPackageName() == packageName {
+			return imp
+		}
+	}
+
+	return nil
+}
+
+// 根据源码中引用包时使用的名字查找当前源文件的import语句, 匿名导入的包不能被引用
+func (c *Package) searchImportByName(name string) *Import {
+	if name == "_" {
+		return nil
+	}
+
+	for _, imp := range c.fileImportMap[c.path] {
+		if imp.name == name {
+			return imp
+		}
+	}
+
+	return nil
+}
+
+// 首字母大写的标识符才能在包外引用
+func IsExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+func NewPackage(packageName string, pathList []string) *Package {
 	c := &Package{
-		lexer:           NewLexer(path),
-		path:            path,
+		packageName:     packageName,
+		pathList:        pathList,
 		importList:      []*Import{},
+		fileImportMap:   map[string][]*Import{},
 		funcList:        []*FunctionDefinition{},
 		declarationList: []*Declaration{},
 		typeDefList:     []*TypeDefDecl{},
@@ -41,3 +96,26 @@ func NewPackage(path string) *Package {
 
 	return c
 }
+
+// 用于实例化泛型函数, 以实际类型重新解析函数源码
+func NewGenericPackage(pkg *Package, fd *FunctionDefinition, typeArgs []*Type) *Package {
+	prefix := "package " + pkg.GetName() + ";"
+
+	c := &Package{
+		packageName:     pkg.GetPackageName(),
+		lexer:           newLexerBySource(prefix, fd.Source+";", fd.SourcePos),
+		path:            fd.Path,
+		importList:      pkg.importList,
+		fileImportMap:   pkg.fileImportMap,
+		funcList:        []*FunctionDefinition{},
+		declarationList: []*Declaration{},
+		typeDefList:     []*TypeDefDecl{},
+		typeArgs:        map[string]*Type{},
+	}
+
+	for i, typeParam := range fd.TypeParams {
+		c.typeArgs[typeParam.Name] = typeArgs[i]
+	}
+
+	return c
+}
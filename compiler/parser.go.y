@@ -2,7 +2,7 @@
 package compiler
 
 import (
-    "strconv"
+    "unicode/utf8"
 )
 %}
 
@@ -31,17 +31,23 @@ import (
 
     type_def             *TypeDefDecl
 
+    identifier_list      []string
+    type_param_list      []*TypeParam
+    type_specifier_list  []*Type
+    type_term            *TypeTerm
+    type_term_list       []*TypeTerm
+
     tok                  Token
 }
 
 %token<tok> IF ELSE FOR RETURN BREAK CONTINUE
     LP RP LC RC LB RB
     SEMICOLON COMMA COLON
-    ASSIGN
+    ASSIGN DEFINE
     LOGICAL_AND LOGICAL_OR
     EQ NE GT GE LT LE
     ADD SUB MUL DIV
-    INT FLOAT STRING
+    INT FLOAT STRING CHAR
     TRUE FALSE NIL
     IDENTIFIER
     EXCLAMATION DOT
@@ -49,6 +55,12 @@ import (
     TYPE STRUCT MAP
     INTERFACE
     ELLIPSIS
+    RANGE
+    OR TILDE
+
+// 函数类型后的`(`优先视为返回值, eg: []func() (int)
+%nonassoc NO_RESULT
+%nonassoc LP
 
 %type <import_spec> import_decl
 %type <import_spec_list> import_decl_list
@@ -69,10 +81,17 @@ import (
     if_statement for_statement
     return_statement break_statement continue_statement
     declaration_statement assign_statement
+    short_var_decl
     var_decl
 %type <type_def> type_decl
+%type <function_decl> function_decl
+%type <identifier_list> identifier_list
+%type <type_param_list> type_parameters type_param_list type_param_decl
+%type <type_specifier_list> type_arguments type_specifier_list
+%type <type_term> type_term
+%type <type_term_list> type_union type_elem_list
 %type <statement_list> statement_list
-%type <parameter> receiver_or_nil parameter_decl
+%type <parameter> receiver parameter_decl
 %type <parameter_list> parameter_list parameters
     result_or_nil result
     type_list_or_nil type_list
@@ -90,7 +109,7 @@ source_file
 package_clause
         : PACKAGE IDENTIFIER
         {
-            SetPackageName($2.Lit)
+            SetPackageName($2.Position(), $2.Lit)
         }
         ;
 import_decl_list_or_nil
@@ -113,16 +132,20 @@ import_decl_list
 import_decl
         : IMPORT STRING SEMICOLON
         {
-            $$ = CreateImport($2.Lit)
+            $$ = CreateImport($1.Position(), "", $2.Lit)
+        }
+        | IMPORT IDENTIFIER STRING SEMICOLON
+        {
+            $$ = CreateImport($1.Position(), $2.Lit, $3.Lit)
         }
         ;
 top_level_decl_list
         :
-        | top_level_decl_list top_level_decl SEMICOLON
-        ;
-top_level_decl
-        : declaration
-        | function_decl
+        | top_level_decl_list declaration SEMICOLON
+        | top_level_decl_list function_decl SEMICOLON
+        {
+            SetGenericSource($2, $3.Position())
+        }
         ;
 declaration
         : type_decl
@@ -139,6 +162,55 @@ type_decl
         {
             $$ = CreateTypeDef($1.Position(), $3, $2.Lit)
         }
+        | TYPE IDENTIFIER type_parameters type_specifier
+        {
+            $$ = CreateGenericTypeDef($1.Position(), $4, $2.Lit, $3)
+        }
+        ;
+type_parameters
+        : LB type_param_list RB
+        {
+            $$ = $2
+        }
+        ;
+type_param_list
+        : type_param_decl
+        | type_param_list COMMA type_param_decl
+        {
+            $$ = append($1, $3...)
+        }
+        ;
+type_param_decl
+        : identifier_list type_specifier
+        {
+            $$ = CreateTypeParamList($1, $2)
+        }
+        ;
+identifier_list
+        : IDENTIFIER
+        {
+            $$ = []string{$1.Lit}
+        }
+        | identifier_list COMMA IDENTIFIER
+        {
+            $$ = append($1, $3.Lit)
+        }
+        ;
+type_arguments
+        : LB type_specifier_list RB
+        {
+            $$ = $2
+        }
+        ;
+type_specifier_list
+        : type_specifier
+        {
+            $$ = []*Type{$1}
+        }
+        | type_specifier_list COMMA type_specifier
+        {
+            $$ = append($1, $3)
+        }
         ;
 var_decl
         : VAR IDENTIFIER type_specifier
@@ -168,6 +240,37 @@ interface_type
         {
             $$ = CreateInterfaceType($1.Position())
         }
+        | INTERFACE LC type_elem_list RC
+        {
+            $$ = CreateConstraintType($1.Position(), $3)
+        }
+        ;
+type_elem_list
+        : type_union SEMICOLON
+        | type_elem_list type_union SEMICOLON
+        {
+            $$ = append($1, $2...)
+        }
+        ;
+type_union
+        : type_term
+        {
+            $$ = []*TypeTerm{$1}
+        }
+        | type_union OR type_term
+        {
+            $$ = append($1, $3)
+        }
+        ;
+type_term
+        : type_specifier
+        {
+            $$ = CreateTypeTerm($1, false)
+        }
+        | TILDE type_specifier
+        {
+            $$ = CreateTypeTerm($2, true)
+        }
         ;
 func_type
         : FUNC signature
@@ -214,21 +317,33 @@ type_specifier
         {
             $$ = CreateTypeByName($1.Lit + "." + $3.Lit, $1.Position())
         }
+        | IDENTIFIER type_arguments
+        {
+            $$ = CreateGenericType($1.Lit, $2, $1.Position())
+        }
+        | IDENTIFIER DOT IDENTIFIER type_arguments
+        {
+            $$ = CreateGenericType($1.Lit + "." + $3.Lit, $4, $1.Position())
+        }
         | literal_type
         | func_type
         ;
 function_decl
-        : FUNC receiver_or_nil IDENTIFIER signature block_or_nil
+        : FUNC IDENTIFIER signature block_or_nil
         {
-            CreateFunctionDefine($1.Position(), $2, $3.Lit, $4, $5)
+            $$ = CreateFunctionDefine($1.Position(), nil, $2.Lit, $3, $4)
         }
-        ;
-receiver_or_nil
-        :
+        | FUNC receiver IDENTIFIER signature block_or_nil
         {
-            $$ = nil
+            $$ = CreateFunctionDefine($1.Position(), $2, $3.Lit, $4, $5)
         }
-        | LP IDENTIFIER type_specifier RP
+        | FUNC IDENTIFIER type_parameters signature block
+        {
+            $$ = CreateGenericFunctionDefine($1.Position(), $2.Lit, $3, $4, $5)
+        }
+        ;
+receiver
+        : LP IDENTIFIER type_specifier RP
         {
             $$ = NewParameter($3, $2.Lit, false)
         }
@@ -252,6 +367,14 @@ parameter_decl
         {
             $$ = NewParameter($3, $1.Lit, true)
         }
+        | type_specifier
+        {
+            $$ = NewParameter($1, "", false)
+        }
+        | ELLIPSIS type_specifier
+        {
+            $$ = NewParameter($2, "", true)
+        }
         ;
 parameters
         : LP RP
@@ -280,7 +403,7 @@ signature
         }
         ;
 result_or_nil
-        :
+        : %prec NO_RESULT
         {
             $$ = nil
         }
@@ -418,18 +541,21 @@ unary_expression
 primary_expression
         : INT
         {
-            value, _ := strconv.Atoi($1.Lit)
-            $$ = CreateIntExpression($1.Position(), value)
+            $$ = CreateIntExpression($1.Position(), ParseIntLiteral($1.Position(), $1.Lit))
         }
         | FLOAT
         {
-            value, _ := strconv.ParseFloat($1.Lit, 64)
-            $$ = CreateFloatExpression($1.Position(), value)
+            $$ = CreateFloatExpression($1.Position(), ParseFloatLiteral($1.Position(), $1.Lit))
         }
         | STRING
         {
             $$ = CreateStringExpression($1.Position(), $1.Lit)
         }
+        | CHAR
+        {
+            value, _ := utf8.DecodeRuneInString($1.Lit)
+            $$ = CreateRuneExpression($1.Position(), value)
+        }
         | TRUE
         {
             $$ = CreateBooleanExpression($1.Position(), true)
@@ -455,6 +581,10 @@ primary_expression
         {
             $$ = CreateIndexExpression($1.Position(), $1, $3)
         }
+        | primary_expression LB expression COMMA argument_list RB
+        {
+            $$ = CreateInstantiateExpression($1.Position(), $1, append([]Expression{$3}, $5...))
+        }
         | primary_expression LP argument_list RP
         {
             $$ = NewFunctionCallExpression($1.Position(), $1, $3)
@@ -463,6 +593,10 @@ primary_expression
         {
             $$ = NewFunctionCallExpression($1.Position(), $1, make([]Expression, 0))
         }
+        | literal_type LP expression RP
+        {
+            $$ = CreateConvertExpression($1, $3)
+        }
         | LP expression RP
         {
             $$ = $2
@@ -507,6 +641,7 @@ simple_statement
             $$ = NewExpressionStatement($1.Position(), $1)
         }
         | assign_statement
+        | short_var_decl
         ;
 if_statement
         : IF expression block
@@ -547,6 +682,21 @@ for_statement
             $$ = NewForStatement($1.Position(), nil, $2, nil, $3)
             $3.parent = NewStatementBlockInfo($$)
         }
+        | FOR expression_list DEFINE RANGE expression block
+        {
+            $$ = NewRangeStatement($1.Position(), $2, true, $5, $6)
+            $6.parent = NewStatementBlockInfo($$)
+        }
+        | FOR expression_list ASSIGN RANGE expression block
+        {
+            $$ = NewRangeStatement($1.Position(), $2, false, $5, $6)
+            $6.parent = NewStatementBlockInfo($$)
+        }
+        | FOR RANGE expression block
+        {
+            $$ = NewRangeStatement($1.Position(), nil, false, $3, $4)
+            $4.parent = NewStatementBlockInfo($$)
+        }
         ;
 expression_or_nil
         :
@@ -582,6 +732,12 @@ assign_statement
             $$ = NewAssignStatement($2.Position(), $1, $3)
         }
         ;
+short_var_decl
+        : expression_list DEFINE expression_list
+        {
+            $$ = NewShortVarDecl($2.Position(), $1, $3)
+        }
+        ;
 block
         : LC
         {
@@ -1,13 +1,27 @@
 package compiler
 
+import (
+	"errors"
+	"math/big"
+	"strconv"
+)
+
 //
 // 函数定义
 //
-func CreateFunctionDefine(pos Position, receiver *Parameter, identifier string, typ *Type, block *Block) {
+func CreateFunctionDefine(pos Position, receiver *Parameter, identifier string, typ *Type, block *Block) *FunctionDefinition {
 	c := GetCurrentPackage()
 
+	// 包初始化时自动调用
+	if receiver == nil && identifier == "init" {
+		if len(typ.funcType.Params) > 0 || len(typ.funcType.Results) > 0 {
+			compileError(pos, INIT_FUNCTION_SIGNATURE_ERR)
+		}
+	}
+
 	fd := &FunctionDefinition{
 		Type:            typ,
+		Path:            c.path,
 		Name:            identifier,
 		PackageName:     c.GetPackageName(),
 		Block:           block,
@@ -19,6 +33,32 @@ func CreateFunctionDefine(pos Position, receiver *Parameter, identifier string,
 	}
 
 	c.funcList = append(c.funcList, fd)
+
+	return fd
+}
+
+//
+// 泛型函数定义, eg: func Map[T, U any](xs []T, f func(T) U) []U
+//
+func CreateGenericFunctionDefine(pos Position, identifier string, typeParams []*TypeParam, typ *Type, block *Block) *FunctionDefinition {
+	fd := CreateFunctionDefine(pos, nil, identifier, typ, block)
+
+	// 实例化时重新解析的函数, 类型参数已替换为实际类型
+	if GetCurrentPackage().typeArgs == nil {
+		fd.TypeParams = typeParams
+		fd.SourcePos = pos
+	}
+
+	return fd
+}
+
+// 记录泛型函数的源码, 实例化时重新解析
+func SetGenericSource(fd *FunctionDefinition, end Position) {
+	if !fd.IsGeneric() {
+		return
+	}
+
+	fd.Source = GetCurrentPackage().lexer.s.source(fd.SourcePos, end)
 }
 
 func CreateTypeDef(pos Position, typ *Type, name string) *TypeDefDecl {
@@ -27,6 +67,13 @@ func CreateTypeDef(pos Position, typ *Type, name string) *TypeDefDecl {
 	return decl
 }
 
+func CreateGenericTypeDef(pos Position, typ *Type, name string, typeParams []*TypeParam) *TypeDefDecl {
+	decl := NewTypeDefDecl(pos, typ, name)
+	decl.TypeParams = typeParams
+
+	return decl
+}
+
 func CreateDeclaration(pos Position, typ *Type, name string, value Expression) *Declaration {
 	decl := NewDeclaration(pos, typ, name, value)
 
@@ -51,18 +98,44 @@ func AddDeclList(stmt Statement) {
 
 	c := GetCurrentPackage()
 	decl.PackageName = c.GetPackageName()
+	decl.Path = c.path
 
 	c.declarationList = append(c.declarationList, decl)
 }
 
-func SetPackageName(packageName string) {
+func SetPackageName(pos Position, name string) {
 	c := GetCurrentPackage()
-	c.SetPackageName(packageName)
+
+	// 同一目录下的文件属于同一个包
+	if c.GetName() != "" && c.GetName() != name {
+		compileError(pos, PACKAGE_NAME_MISMATCH_ERR, name, c.GetName())
+	}
+
+	c.SetName(name)
 }
 
+// 记录当前源文件的import, 包内各文件导入的包合并, 重复导入的包只保留一个
 func SetImportList(importList []*Import) {
 	c := GetCurrentPackage()
-	c.importList = importList
+
+	for _, imp := range importList {
+		// 先确定包的唯一标识, 同一个目录的不同写法指向同一个包
+		imp.resolve()
+
+		if c.searchImport(imp.GetPackageName()) == nil {
+			c.importList = append(c.importList, imp)
+		}
+
+		if imp.name != "_" {
+			// 同一个文件中, 同一个名字不能指向不同的包
+			other := c.searchImportByName(imp.name)
+			if other != nil && other.GetPackageName() != imp.GetPackageName() {
+				compileError(imp.Position(), REQUIRE_DUPLICATE_ERR, imp.name, other.path, imp.path)
+			}
+		}
+
+		c.fileImportMap[c.path] = append(c.fileImportMap[c.path], imp)
+	}
 }
 
 func PushCurrentBlock() *Block {
@@ -79,3 +152,33 @@ func PopCurrentBlock() *Block {
 
 	return b
 }
+
+//
+// 数字字面量
+//
+func ParseIntLiteral(pos Position, lit string) *big.Int {
+	// 范围在确定类型后检查
+	value, ok := new(big.Int).SetString(lit, 0)
+	if !ok {
+		compileError(pos, INVALID_NUMBER_LITERAL_ERR, lit)
+	}
+
+	return value
+}
+
+func ParseFloatLiteral(pos Position, lit string) float64 {
+	value, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		numberLiteralError(pos, lit, err)
+	}
+
+	return value
+}
+
+func numberLiteralError(pos Position, lit string, err error) {
+	if errors.Is(err, strconv.ErrRange) {
+		compileError(pos, NUMBER_LITERAL_OUT_OF_RANGE_ERR, lit)
+	}
+
+	compileError(pos, INVALID_NUMBER_LITERAL_ERR, lit)
+}
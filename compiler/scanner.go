@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 	"unicode"
 )
 
@@ -35,6 +34,7 @@ var opName = map[string]int{
 	"nil":       NIL,
 	"map":       MAP,
 	"interface": INTERFACE,
+	"range":     RANGE,
 	"(":         LP,
 	")":         RP,
 	"[":         LB,
@@ -50,6 +50,7 @@ var opName = map[string]int{
 	"/":         DIV,
 	"!":         EXCLAMATION,
 	".":         DOT,
+	"~":         TILDE,
 }
 
 // Scanner stores informations for lexer.
@@ -61,20 +62,40 @@ type Scanner struct {
 }
 
 func newScannerByFilePath(path string) *Scanner {
+	if isBuiltinPath(path) {
+		buf, err := readBuiltinFile(path)
+		if err != nil {
+			compileError(Position{}, READ_FILE_ERR, path, err)
+		}
+		return &Scanner{src: []rune(string(buf))}
+	}
+
 	_, err := os.Stat(path)
 	if err != nil {
-		panic("文件不存在")
+		compileError(Position{}, REQUIRE_FILE_NOT_FOUND_ERR, path)
 	}
 
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		compileError(Position{}, READ_FILE_ERR, path, err)
 	}
 	scanner := &Scanner{src: []rune(string(buf))}
 
 	return scanner
 }
 
+// 从源码创建扫描器, 源码从pos位置开始, 报错时仍能指向原文件的行列
+// prefix会放在pos所在的行前
+func newScannerBySource(prefix string, src string, pos Position) *Scanner {
+	scanner := &Scanner{
+		src:      []rune(prefix + src),
+		line:     pos.Line - 1,
+		lineHead: len([]rune(prefix)) - pos.Column + 1,
+	}
+
+	return scanner
+}
+
 // Scan analyses token, and decide identify or literals.
 func (s *Scanner) Scan() (tok int, lit string, pos Position, err error) {
 retry:
@@ -94,16 +115,10 @@ retry:
 		}
 	// 数字
 	case isDigit(ch):
-		lit, err = s.scanNumber()
+		tok, lit, err = s.scanNumber()
 		if err != nil {
 			return
 		}
-		// 判断lit中有无 `.`
-		if strings.Contains(lit, ".") {
-			tok = FLOAT
-		} else {
-			tok = INT
-		}
 	// 字符串
 	case ch == '"':
 		tok = STRING
@@ -111,9 +126,17 @@ retry:
 		if err != nil {
 			return
 		}
-	case ch == '\'':
+	// 原始字符串
+	case ch == '`':
 		tok = STRING
-		lit, err = s.scanString('\'')
+		lit, err = s.scanRawString()
+		if err != nil {
+			return
+		}
+	// 字符
+	case ch == '\'':
+		tok = CHAR
+		lit, err = s.scanRune()
 		if err != nil {
 			return
 		}
@@ -133,6 +156,13 @@ retry:
 					s.next()
 				}
 				goto retry
+			// 块注释
+			case '*':
+				err = s.skipBlockComment()
+				if err != nil {
+					return
+				}
+				goto retry
 			default:
 				s.back()
 				tok = opName[string(ch)]
@@ -189,10 +219,10 @@ retry:
 				tok = LOGICAL_OR
 				lit = "||"
 			default:
+				// 约束中的类型并集, eg: int | float
 				s.back()
-				err = fmt.Errorf(`syntax Error "%s"`, string(ch))
-				tok = int(ch)
-				lit = string(ch)
+				tok = OR
+				lit = "|"
 			}
 		case '&':
 			s.next()
@@ -208,6 +238,12 @@ retry:
 			}
 		case '.':
 			s.next()
+			// 省略整数部分的小数, eg: .5
+			if isDigit(s.peek()) {
+				s.back()
+				tok, lit, err = s.scanNumber()
+				return
+			}
 			if s.peek() == '.' {
 				s.next()
 				if s.peek() == '.' {
@@ -221,7 +257,18 @@ retry:
 				tok = opName[string(ch)]
 				lit = string(ch)
 			}
-		case '(', ')', '[', ']', '{', '}', ':', ';', ',', '+', '-', '*':
+		case ':':
+			s.next()
+			switch s.peek() {
+			case '=':
+				tok = DEFINE
+				lit = ":="
+			default:
+				s.back()
+				tok = COLON
+				lit = ":"
+			}
+		case '(', ')', '[', ']', '{', '}', ';', ',', '+', '-', '*', '~':
 			tok = opName[string(ch)]
 			lit = string(ch)
 		default:
@@ -245,6 +292,24 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// isHexDigit returns true if the rune is a hexadecimal number.
+func isHexDigit(ch rune) bool {
+	return digitValue(ch) < 16
+}
+
+// digitValue returns the value of hexadecimal digit, or 16 if not a digit.
+func digitValue(ch rune) rune {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return ch - '0'
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10
+	case 'A' <= ch && ch <= 'F':
+		return ch - 'A' + 10
+	}
+	return 16
+}
+
 // isEOL returns true if the rune is at end-of-line or end-of-file.
 func isEOL(ch rune) bool {
 	return ch == '\n' || ch == -1
@@ -279,6 +344,15 @@ func (s *Scanner) next() {
 // back moves back offset once to top.
 func (s *Scanner) back() {
 	s.offset--
+
+	// 回退到上一行时, 重新计算行首
+	if s.src[s.offset] == '\n' {
+		s.line--
+		s.lineHead = s.offset
+		for s.lineHead > 0 && s.src[s.lineHead-1] != '\n' {
+			s.lineHead--
+		}
+	}
 }
 
 // skipBlank moves position into non-black character.
@@ -298,6 +372,60 @@ func (s *Scanner) pos() Position {
 	return Position{Line: s.line + 1, Column: s.offset - s.lineHead + 1}
 }
 
+// source returns the code between start and end.
+func (s *Scanner) source(start, end Position) string {
+	begin, finish := 0, 0
+	line, lineHead := 1, 0
+
+	for offset := 0; offset < len(s.src); offset++ {
+		pos := Position{Line: line, Column: offset - lineHead + 1}
+		if pos == start {
+			begin = offset
+		}
+		if pos == end {
+			finish = offset
+			break
+		}
+
+		if s.src[offset] == '\n' {
+			line++
+			lineHead = offset + 1
+		}
+	}
+
+	return string(s.src[begin:finish])
+}
+
+// skipBlockComment skips block comment starting at `*` after `/`.
+// Nested block comments are allowed.
+func (s *Scanner) skipBlockComment() error {
+	depth := 1
+
+	s.next()
+	for depth > 0 {
+		switch s.peek() {
+		case EOF:
+			return errors.New("comment not terminated")
+		case '/':
+			s.next()
+			if s.peek() == '*' {
+				depth++
+				s.next()
+			}
+		case '*':
+			s.next()
+			if s.peek() == '/' {
+				depth--
+				s.next()
+			}
+		default:
+			s.next()
+		}
+	}
+
+	return nil
+}
+
 // scanIdentifier returns identifier begining at current position.
 func (s *Scanner) scanIdentifier() (string, error) {
 	var ret []rune
@@ -312,27 +440,72 @@ func (s *Scanner) scanIdentifier() (string, error) {
 }
 
 // scanNumber returns number begining at current position.
-func (s *Scanner) scanNumber() (string, error) {
+// This handles 0x/0o/0b prefixes, `_` separators and exponents,
+// the value is checked by strconv in parser.
+func (s *Scanner) scanNumber() (int, string, error) {
 	var ret []rune
-	ch := s.peek()
-	ret = append(ret, ch)
-	s.next()
+	tok := INT
+	prefixed := false
 
-	for isDigit(s.peek()) || s.peek() == '.' {
+	accept := func(valid func(rune) bool) {
+		for valid(s.peek()) || s.peek() == '_' {
+			ret = append(ret, s.peek())
+			s.next()
+		}
+	}
+
+	if s.peek() == '0' {
 		ret = append(ret, s.peek())
 		s.next()
+
+		switch unicode.ToLower(s.peek()) {
+		case 'x':
+			prefixed = true
+			ret = append(ret, s.peek())
+			s.next()
+			accept(isHexDigit)
+		case 'o', 'b':
+			prefixed = true
+			ret = append(ret, s.peek())
+			s.next()
+			accept(isDigit)
+		}
 	}
 
-	if isLetter(s.peek()) {
-		return "", errors.New("identifier starts immediately after numeric literal")
+	if !prefixed {
+		accept(isDigit)
+
+		// 小数部分
+		if s.peek() == '.' {
+			tok = FLOAT
+			ret = append(ret, s.peek())
+			s.next()
+			accept(isDigit)
+		}
+
+		// 指数部分
+		if s.peek() == 'e' || s.peek() == 'E' {
+			tok = FLOAT
+			ret = append(ret, s.peek())
+			s.next()
+			if s.peek() == '+' || s.peek() == '-' {
+				ret = append(ret, s.peek())
+				s.next()
+			}
+			accept(isDigit)
+		}
 	}
-	return string(ret), nil
+
+	if isLetter(s.peek()) || isDigit(s.peek()) {
+		return tok, "", errors.New("identifier starts immediately after numeric literal")
+	}
+	return tok, string(ret), nil
 }
 
 // scanString returns string starting at current position.
 // This handles backslash escaping.
 func (s *Scanner) scanString(l rune) (string, error) {
-	var ret []rune
+	var ret []byte
 eos:
 	for {
 		s.next()
@@ -345,29 +518,130 @@ eos:
 			s.next()
 			break eos
 		case '\\':
-			s.next()
-			switch s.peek() {
-			case 'b':
-				ret = append(ret, '\b')
-				continue
-			case 'f':
-				ret = append(ret, '\f')
-				continue
-			case 'r':
-				ret = append(ret, '\r')
-				continue
-			case 'n':
-				ret = append(ret, '\n')
-				continue
-			case 't':
-				ret = append(ret, '\t')
-				continue
+			ch, isByte, err := s.scanEscape(l)
+			if err != nil {
+				return "", err
+			}
+			// \x, 八进制转义表示单个字节
+			if isByte {
+				ret = append(ret, byte(ch))
+			} else {
+				ret = append(ret, string(ch)...)
 			}
-			ret = append(ret, s.peek())
-			continue
 		default:
-			ret = append(ret, s.peek())
+			ret = append(ret, string(s.peek())...)
 		}
 	}
 	return string(ret), nil
 }
+
+// scanRawString returns raw string starting at current position.
+// Carriage returns are discarded.
+func (s *Scanner) scanRawString() (string, error) {
+	var ret []rune
+
+	for {
+		s.next()
+		switch ch := s.peek(); ch {
+		case EOF:
+			return "", errors.New("raw string literal not terminated")
+		case '`':
+			s.next()
+			return string(ret), nil
+		case '\r':
+		default:
+			ret = append(ret, ch)
+		}
+	}
+}
+
+// scanRune returns rune literal starting at current position.
+func (s *Scanner) scanRune() (string, error) {
+	var ch rune
+
+	s.next()
+	switch s.peek() {
+	case EOL, EOF:
+		return "", errors.New("rune literal not terminated")
+	case '\'':
+		return "", errors.New("empty rune literal or unescaped ' in rune literal")
+	case '\\':
+		var err error
+		ch, _, err = s.scanEscape('\'')
+		if err != nil {
+			return "", err
+		}
+	default:
+		ch = s.peek()
+	}
+
+	s.next()
+	if s.peek() != '\'' {
+		return "", errors.New("more than one character in rune literal")
+	}
+	s.next()
+
+	return string(ch), nil
+}
+
+// scanEscape returns the character of escape sequence at current position.
+// quote is the delimiter which can be escaped.
+// isByte is true if the escape is \x or octal, which represents a single byte.
+func (s *Scanner) scanEscape(quote rune) (value rune, isByte bool, err error) {
+	s.next()
+	switch ch := s.peek(); ch {
+	case 'a':
+		return '\a', false, nil
+	case 'b':
+		return '\b', false, nil
+	case 'f':
+		return '\f', false, nil
+	case 'n':
+		return '\n', false, nil
+	case 'r':
+		return '\r', false, nil
+	case 't':
+		return '\t', false, nil
+	case 'v':
+		return '\v', false, nil
+	case '\\', quote:
+		return ch, false, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		value, err = s.scanDigits(ch-'0', 2, 8)
+		if err == nil && value > 255 {
+			err = errors.New("octal escape value > 255")
+		}
+		return value, true, err
+	case 'x':
+		value, err = s.scanDigits(0, 2, 16)
+		return value, true, err
+	case 'u', 'U':
+		n := 4
+		if ch == 'U' {
+			n = 8
+		}
+
+		value, err = s.scanDigits(0, n, 16)
+		if err == nil && (value > unicode.MaxRune || (0xD800 <= value && value < 0xE000)) {
+			err = errors.New("escape sequence is invalid Unicode code point")
+		}
+		return value, false, err
+	}
+
+	return 0, false, errors.New("unknown escape sequence")
+}
+
+// scanDigits reads n digits in base after current position.
+func (s *Scanner) scanDigits(value rune, n int, base rune) (rune, error) {
+	for i := 0; i < n; i++ {
+		s.next()
+
+		d := digitValue(s.peek())
+		if d >= base {
+			return 0, errors.New("invalid character in escape sequence")
+		}
+		value = value*base + d
+	}
+
+	return value, nil
+}
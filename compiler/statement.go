@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"math/big"
+
 	"github.com/lth-go/gogo/vm"
 )
 
@@ -81,11 +83,9 @@ func (stmt *IfStatement) Fix() {
 }
 
 func (stmt *IfStatement) Generate(ob *OpCodeBuf) {
-	stmt.Condition.Generate(ob)
-
 	// 获取false跳转地址
 	ifFalseLabel := ob.GetLabel()
-	ob.GenerateCode(stmt.Position(), vm.OP_CODE_JUMP_IF_FALSE, ifFalseLabel)
+	generateConditionJump(stmt.Condition, false, ifFalseLabel, ob)
 
 	if stmt.ThenBlock != nil {
 		generateStatementList(stmt.ThenBlock.statementList, ob)
@@ -101,11 +101,9 @@ func (stmt *IfStatement) Generate(ob *OpCodeBuf) {
 	ob.SetLabel(ifFalseLabel)
 
 	for _, elif := range stmt.ElseIfList {
-		elif.Condition.Generate(ob)
-
 		// 获取false跳转地址
 		ifFalseLabel = ob.GetLabel()
-		ob.GenerateCode(stmt.Position(), vm.OP_CODE_JUMP_IF_FALSE, ifFalseLabel)
+		generateConditionJump(elif.Condition, false, ifFalseLabel, ob)
 
 		generateStatementList(elif.Block.statementList, ob)
 
@@ -199,28 +197,25 @@ func (stmt *ForStatement) Generate(ob *OpCodeBuf) {
 	// 设置循环地址
 	ob.SetLabel(loopLabel)
 
-	if stmt.Condition != nil {
-		stmt.Condition.Generate(ob)
-	}
-
 	label := ob.GetLabel()
+	continueLabel := ob.GetLabel()
 
 	if stmt.Condition != nil {
 		// 如果条件为否,跳转到break, label = parent.breakLabel
-		ob.GenerateCode(stmt.Position(), vm.OP_CODE_JUMP_IF_FALSE, label)
+		generateConditionJump(stmt.Condition, false, label, ob)
 	}
 
 	if stmt.Block != nil {
 		parent := stmt.Block.parent.(*StatementBlockInfo)
 		// 获取break,continue地址
 		parent.BreakLabel = label
-		parent.ContinueLabel = label
+		parent.ContinueLabel = continueLabel
 
 		generateStatementList(stmt.Block.statementList, ob)
 	}
 
 	// 如果有continue,直接跳过block,从这里执行, label = parent.continueLabel
-	ob.SetLabel(label)
+	ob.SetLabel(continueLabel)
 
 	if stmt.Post != nil {
 		stmt.Post.Generate(ob)
@@ -246,6 +241,135 @@ func NewForStatement(pos Position, init Statement, condition Expression, post St
 	return stmt
 }
 
+//
+// RangeStatement for range 语句
+//
+type RangeStatement struct {
+	StatementBase
+	Left     []Expression // key, value
+	Define   bool         // 是否使用 := 声明key, value
+	X        Expression   // 迭代的对象
+	Block    *Block
+	Iterator *Declaration // 保存迭代器的匿名局部变量
+}
+
+func (stmt *RangeStatement) Fix() {
+	stmt.X = stmt.X.Fix()
+
+	var keyType, valueType *Type
+
+	xType := stmt.X.GetType()
+	switch {
+	case xType.IsString():
+		// 按rune迭代, key为字节下标
+		keyType = NewType(BasicTypeInt)
+		valueType = NewType(BasicTypeInt32)
+	case xType.IsArray():
+		keyType = NewType(BasicTypeInt)
+		valueType = xType.arrayType.ElementType
+	case xType.IsMap():
+		keyType = xType.mapType.Key
+		valueType = xType.mapType.Value
+	default:
+		compileError(stmt.X.Position(), RANGE_TYPE_ERR, xType.GetTypeName())
+	}
+
+	if len(stmt.Left) > 2 {
+		compileError(stmt.Position(), RANGE_VARIABLE_COUNT_ERR)
+	}
+
+	stmt.Iterator = NewDeclaration(stmt.Position(), NewType(BasicTypeInterface), "", nil)
+	stmt.Iterator.Block = stmt.Block
+	stmt.Iterator.IsLocal = true
+	stmt.Iterator.Fix()
+
+	typeList := []*Type{keyType, valueType}
+
+	for i, expr := range stmt.Left {
+		if isBlankIdentifier(expr) {
+			continue
+		}
+
+		switch e := expr.(type) {
+		case *IdentifierExpression:
+			if stmt.Define {
+				decl := NewDeclaration(e.Position(), typeList[i].Copy(), e.Name, nil)
+				decl.PackageName = e.PackageName
+				decl.Block = stmt.Block
+				decl.IsLocal = true
+				decl.Fix()
+
+				// 声明在循环体内, 从循环体开始查找
+				e.Block = stmt.Block
+			}
+		case *IndexExpression, *SelectorExpression:
+			if stmt.Define {
+				compileError(expr.Position(), NOT_LVALUE_ERR, "")
+			}
+		default:
+			compileError(expr.Position(), NOT_LVALUE_ERR, "")
+		}
+
+		stmt.Left[i] = expr.Fix()
+
+		if indexExpr, ok := stmt.Left[i].(*IndexExpression); ok && indexExpr.X.GetType().IsString() {
+			compileError(expr.Position(), STRING_INDEX_ASSIGN_ERR)
+		}
+
+		if !typeList[i].Equal(stmt.Left[i].GetType()) {
+			castMismatchError(expr.Position(), typeList[i], stmt.Left[i].GetType())
+		}
+	}
+
+	stmt.Block.Fix()
+}
+
+func (stmt *RangeStatement) Generate(ob *OpCodeBuf) {
+	stmt.X.Generate(ob)
+	ob.GenerateCode(stmt.Position(), vm.OP_CODE_NEW_ITERATOR)
+	generatePopToIdentifier(stmt.Iterator, stmt.Position(), ob)
+
+	loopLabel := ob.GetLabel()
+	label := ob.GetLabel()
+
+	ob.SetLabel(loopLabel)
+
+	// 迭代结束时跳转到结尾, 否则key, value依次入栈
+	ob.GenerateCode(stmt.Position(), vm.OP_CODE_PUSH_STACK, stmt.Iterator.Index)
+	ob.GenerateCode(stmt.Position(), vm.OP_CODE_ITERATE, label)
+
+	for i := 1; i >= 0; i-- {
+		if i < len(stmt.Left) {
+			generatePopToLvalue(stmt.Left[i], ob)
+		} else {
+			ob.GenerateCode(stmt.Position(), vm.OP_CODE_POP)
+		}
+	}
+
+	parent := stmt.Block.parent.(*StatementBlockInfo)
+	parent.BreakLabel = label
+	parent.ContinueLabel = loopLabel
+
+	generateStatementList(stmt.Block.statementList, ob)
+
+	ob.GenerateCode(stmt.Position(), vm.OP_CODE_JUMP, loopLabel)
+
+	ob.SetLabel(label)
+}
+
+func NewRangeStatement(pos Position, left []Expression, define bool, x Expression, block *Block) *RangeStatement {
+	stmt := &RangeStatement{
+		Left:   left,
+		Define: define,
+		X:      x,
+		Block:  block,
+	}
+
+	stmt.SetPosition(pos)
+
+	return stmt
+}
+
 //
 // ReturnStatement
 //
@@ -269,13 +393,33 @@ func (stmt *ReturnStatement) Fix() {
 	} else if resultCount != 0 && valueCount == 0 {
 		// 函数定义了返回值,却没返回
 		compileError(stmt.Position(), BAD_RETURN_TYPE_ERR)
-	} else {
-		// 只定义了单个返回值
-		stmt.ValueList = []Expression{stmt.ValueList[0].Fix()}
+	}
+
+	for i := 0; i < valueCount; i++ {
+		stmt.ValueList[i] = stmt.ValueList[i].Fix()
+	}
 
-		if !fd.GetType().funcType.Results[0].Type.Equal(stmt.ValueList[0].GetType()) {
+	// return f(), 直接返回多值函数的返回值
+	if valueCount == 1 && stmt.ValueList[0].GetType().IsMultipleValues() {
+		typeList := stmt.ValueList[0].GetType().multipleValueType.List
+		if len(typeList) != resultCount {
 			compileError(stmt.Position(), BAD_RETURN_TYPE_ERR)
 		}
+
+		for i, result := range fd.GetType().funcType.Results {
+			if !result.Type.Equal(typeList[i]) {
+				compileError(stmt.Position(), BAD_RETURN_TYPE_ERR)
+			}
+		}
+		return
+	}
+
+	if valueCount != resultCount {
+		compileError(stmt.Position(), BAD_RETURN_TYPE_ERR)
+	}
+
+	for i, result := range fd.GetType().funcType.Results {
+		stmt.ValueList[i] = CreateAssignCast(stmt.ValueList[i], result.Type)
 	}
 }
 
@@ -374,6 +518,7 @@ type TypeDefDecl struct {
 	Name        string
 	Value       *Type
 	Index       int
+	TypeParams  []*TypeParam // 泛型类型的类型参数
 }
 
 func NewTypeDefDecl(pos Position, typ *Type, name string) *TypeDefDecl {
@@ -399,6 +544,7 @@ type Declaration struct {
 	Index       int    // 下标
 	IsLocal     bool   // 是否本地声明
 	Block       *Block // 所属块
+	Path        string // 全局声明所在的源文件
 }
 
 func (stmt *Declaration) Fix() {
@@ -408,9 +554,9 @@ func (stmt *Declaration) Fix() {
 	block := stmt.Block
 	block.declarationList = append(block.declarationList, stmt)
 
-	// 向父函数添加
+	// 向父函数添加, 下标排在形参之后
 	fd := block.GetCurrentFunction()
-	stmt.Index = len(fd.DeclarationList)
+	stmt.Index = len(fd.GetType().funcType.Params) + len(fd.DeclarationList)
 	fd.DeclarationList = append(fd.DeclarationList, stmt)
 
 	stmt.Type.Fix()
@@ -445,25 +591,30 @@ func NewDeclaration(pos Position, typ *Type, name string, value Expression) *Dec
 }
 
 func GetTypeDefaultValue(typ *Type, pos Position) Expression {
-	if typ.IsArray() || typ.IsMap() || typ.IsInterface() {
+	if typ.IsArray() || typ.IsMap() || typ.IsInterface() || typ.IsFunc() {
 		return CreateNilExpression(pos)
 	}
 
+	// 整数, 浮点数零值带上各自的类型
+	if typ.IsInteger() {
+		expr := CreateIntExpression(pos, big.NewInt(0))
+		expr.SetType(typ.Copy())
+		return expr
+	}
+
+	if typ.IsFloat() {
+		expr := CreateFloatExpression(pos, 0.0)
+		expr.SetType(typ.Copy())
+		return expr
+	}
+
 	switch typ.GetBasicType() {
 	case BasicTypeBool:
 		return CreateBooleanExpression(pos, false)
-	case BasicTypeInt:
-		return CreateIntExpression(pos, 0)
-	case BasicTypeFloat:
-		return CreateFloatExpression(pos, 0.0)
 	case BasicTypeString:
 		return CreateStringExpression(pos, "")
 	case BasicTypeStruct:
-		// TODO: 结构体默认值
-		// value := CreateStructExpression(typ, nil)
-
-		// return value
-		fallthrough
+		return CreateStructExpression(typ, nil)
 	default:
 		panic("TODO")
 	}
@@ -490,76 +641,81 @@ func (stmt *AssignStatement) Fix() {
 		}
 	}
 
-	// 校验右边是否有函数调用,如果有取函数返回值为长度
-	if stmt.isFuncCall() {
-		leftLen := len(stmt.Left)
-		rightLen := len(stmt.Right)
+	typeList := stmt.FixRight()
+	stmt.FixLeft(typeList)
+}
 
-		if rightLen != 1 {
-			panic("TODO")
+// FixRight 修正右值, 返回右值的类型列表
+func (stmt *AssignStatement) FixRight() []*Type {
+	// v, ok = m[k]
+	if len(stmt.Left) == 2 && len(stmt.Right) == 1 {
+		indexExpr, ok := stmt.Right[0].(*IndexExpression)
+		if ok {
+			indexExpr.CommaOk = true
 		}
+	}
 
-		stmt.Right[0] = stmt.Right[0].Fix()
-		rightLen = stmt.Right[0].GetType().GetResultCount()
+	for i := 0; i < len(stmt.Right); i++ {
+		stmt.Right[i] = stmt.Right[i].Fix()
+	}
 
-		if leftLen != rightLen {
-			panic("TODO")
-		}
+	if stmt.isMultipleValues() {
+		return stmt.Right[0].GetType().multipleValueType.List
+	}
 
-		for i := 0; i < len(stmt.Left); i++ {
-			stmt.Left[i] = stmt.Left[i].Fix()
-		}
-	} else {
-		leftLen := len(stmt.Left)
-		rightLen := len(stmt.Right)
+	typeList := make([]*Type, len(stmt.Right))
+	for i, expr := range stmt.Right {
+		typeList[i] = expr.GetType()
+	}
 
-		if leftLen != rightLen {
-			panic("TODO")
-		}
+	return typeList
+}
 
-		for i := 0; i < len(stmt.Left); i++ {
-			stmt.Left[i] = stmt.Left[i].Fix()
-			stmt.Right[i] = stmt.Right[i].Fix()
-			stmt.Right[i] = CreateAssignCast(stmt.Right[i], stmt.Left[i].GetType())
-		}
+// FixLeft 修正左值, 并校验左右两边的类型
+func (stmt *AssignStatement) FixLeft(typeList []*Type) {
+	if len(stmt.Left) != len(typeList) {
+		compileError(stmt.Position(), ASSIGNMENT_COUNT_MISMATCH_ERR, len(stmt.Left), len(typeList))
 	}
-}
 
-func (stmt *AssignStatement) Generate(ob *OpCodeBuf) {
-	isCall := stmt.isFuncCall()
+	isMultipleValues := stmt.isMultipleValues()
 
-	if isCall {
-		for _, expr := range stmt.Right {
-			expr.Generate(ob)
+	for i := 0; i < len(stmt.Left); i++ {
+		if isBlankIdentifier(stmt.Left[i]) {
+			continue
 		}
 
-		count := len(stmt.Left)
-		for i := 0; i < count; i++ {
-			leftExpr := stmt.Left[count-i-1]
-			ob.GenerateCode(stmt.Position(), vm.OP_CODE_DUPLICATE)
-			generatePopToLvalue(leftExpr, ob)
+		stmt.Left[i] = stmt.Left[i].Fix()
+		leftType := stmt.Left[i].GetType()
+
+		if indexExpr, ok := stmt.Left[i].(*IndexExpression); ok && indexExpr.X.GetType().IsString() {
+			compileError(stmt.Left[i].Position(), STRING_INDEX_ASSIGN_ERR)
 		}
-	} else {
-		count := len(stmt.Left)
-		for i := 0; i < count; i++ {
-			leftExpr := stmt.Left[i]
-			rightExpr := stmt.Right[i]
-
-			rightExpr.Generate(ob)
-			ob.GenerateCode(stmt.Position(), vm.OP_CODE_DUPLICATE)
-			generatePopToLvalue(leftExpr, ob)
+
+		if isMultipleValues {
+			if !typeList[i].Equal(leftType) {
+				castMismatchError(stmt.Left[i].Position(), typeList[i], leftType)
+			}
+			continue
 		}
+
+		stmt.Right[i] = CreateAssignCast(stmt.Right[i], leftType)
 	}
 }
 
-func (stmt *AssignStatement) isFuncCall() bool {
+// 先计算右边所有的值, 再依次赋值给左边
+func (stmt *AssignStatement) Generate(ob *OpCodeBuf) {
 	for _, expr := range stmt.Right {
-		_, ok := expr.(*CallExpression)
-		if ok {
-			return true
-		}
+		expr.Generate(ob)
+	}
+
+	for i := len(stmt.Left) - 1; i >= 0; i-- {
+		generatePopToLvalue(stmt.Left[i], ob)
 	}
-	return false
+}
+
+// 右边是否为单个多值表达式, 如函数调用, v, ok = m[k]
+func (stmt *AssignStatement) isMultipleValues() bool {
+	return len(stmt.Right) == 1 && stmt.Right[0].GetType().IsMultipleValues()
 }
 
 func NewAssignStatement(pos Position, left []Expression, right []Expression) *AssignStatement {
@@ -573,6 +729,73 @@ func NewAssignStatement(pos Position, left []Expression, right []Expression) *As
 	return stmt
 }
 
+//
+// ShortVarDecl 短变量声明, eg: a, b := 1, 2
+//
+type ShortVarDecl struct {
+	StatementBase
+	Assign *AssignStatement
+	Block  *Block
+}
+
+func (stmt *ShortVarDecl) Fix() {
+	for _, expr := range stmt.Assign.Left {
+		_, ok := expr.(*IdentifierExpression)
+		if !ok {
+			compileError(expr.Position(), NOT_LVALUE_ERR, "")
+		}
+	}
+
+	typeList := stmt.Assign.FixRight()
+
+	if len(stmt.Assign.Left) != len(typeList) {
+		compileError(stmt.Position(), ASSIGNMENT_COUNT_MISMATCH_ERR, len(stmt.Assign.Left), len(typeList))
+	}
+
+	// 同一个块中已声明的变量直接赋值, 其余的新建声明
+	hasNewVariable := false
+
+	for i, expr := range stmt.Assign.Left {
+		identifierExpr := expr.(*IdentifierExpression)
+
+		if isBlankIdentifier(identifierExpr) || stmt.Block.searchLocalDeclaration(identifierExpr.Name) != nil {
+			continue
+		}
+
+		if typeList[i].IsNil() {
+			compileError(expr.Position(), USE_OF_UNTYPED_NIL_ERR)
+		}
+
+		decl := NewDeclaration(expr.Position(), typeList[i].Copy(), identifierExpr.Name, nil)
+		decl.PackageName = identifierExpr.PackageName
+		decl.Block = stmt.Block
+		decl.IsLocal = true
+		decl.Fix()
+
+		hasNewVariable = true
+	}
+
+	if !hasNewVariable {
+		compileError(stmt.Position(), NO_NEW_VARIABLE_ERR)
+	}
+
+	stmt.Assign.FixLeft(typeList)
+}
+
+func (stmt *ShortVarDecl) Generate(ob *OpCodeBuf) {
+	stmt.Assign.Generate(ob)
+}
+
+func NewShortVarDecl(pos Position, left []Expression, right []Expression) *ShortVarDecl {
+	stmt := &ShortVarDecl{
+		Assign: NewAssignStatement(pos, left, right),
+		Block:  GetCurrentPackage().currentBlock,
+	}
+	stmt.SetPosition(pos)
+
+	return stmt
+}
+
 func generateStatementList(statementList []Statement, ob *OpCodeBuf) {
 	for _, stmt := range statementList {
 		stmt.Generate(ob)
@@ -582,6 +805,10 @@ func generateStatementList(statementList []Statement, ob *OpCodeBuf) {
 func generatePopToLvalue(expr Expression, ob *OpCodeBuf) {
 	switch e := expr.(type) {
 	case *IdentifierExpression:
+		if isBlankIdentifier(e) {
+			ob.GenerateCode(expr.Position(), vm.OP_CODE_POP)
+			return
+		}
 		generatePopToIdentifier(e.Obj.(*Declaration), expr.Position(), ob)
 	case *IndexExpression:
 		if e.X.GetType().IsArray() {
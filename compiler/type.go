@@ -11,7 +11,17 @@ const (
 	BasicTypeNoType BasicType = iota - 1
 	BasicTypeBool
 	BasicTypeInt
+	BasicTypeInt8
+	BasicTypeInt16
+	BasicTypeInt32
+	BasicTypeInt64
+	BasicTypeUint
+	BasicTypeUint8
+	BasicTypeUint16
+	BasicTypeUint32
+	BasicTypeUint64
 	BasicTypeFloat
+	BasicTypeFloat32
 	BasicTypeString
 	BasicTypeNil
 	BasicTypeVoid
@@ -36,6 +46,10 @@ type Type struct {
 	mapType           *MapType
 	multipleValueType *MultipleValueType // 用于处理函数多返回值
 	structType        *StructType
+	interfaceType     *InterfaceType // 约束接口的类型集
+	name              string         // 自定义类型名, eg: type MyInt int
+	packageName       string         // 自定义类型所在的包
+	typeArgs          []*Type        // 泛型类型的类型实参, eg: Stack[int]
 }
 
 func (t *Type) Fix() {
@@ -45,12 +59,51 @@ func (t *Type) Fix() {
 	//         param.Type.Fix()
 	//     }
 	// }
+
+	// map的键必须可以比较
+	if t.IsMap() && !t.mapType.Key.IsComparable() {
+		compileError(t.Position(), MAP_KEY_NOT_COMPARABLE_ERR, t.mapType.Key.GetTypeName())
+	}
 }
 
 func (t *Type) GetBasicType() BasicType {
+	if t.basicType == BasicTypeNoType && t.name != "" {
+		t.resolve()
+	}
 	return t.basicType
 }
 
+// 自定义类型, 使用时才查找底层类型, 类型声明可以写在使用之后
+func (t *Type) resolve() {
+	decl := GetCurrentCompiler().SearchTypeDef(t.packageName, t.name)
+	if decl == nil {
+		compileError(t.Position(), TYPE_NAME_NOT_FOUND_ERR, t.GetTypeName())
+	}
+
+	if len(decl.TypeParams) != len(t.typeArgs) {
+		compileError(t.Position(), TYPE_ARGUMENT_COUNT_MISMATCH_ERR, decl.Name, len(decl.TypeParams), len(t.typeArgs))
+	}
+
+	underlying := decl.Value
+
+	// 泛型类型, 将类型参数替换为类型实参
+	if len(decl.TypeParams) > 0 {
+		typeArgMap := CreateTypeArgMap(decl.TypeParams, t.typeArgs, decl.PackageName, t.Position())
+		underlying = underlying.substitute(decl.PackageName, typeArgMap)
+	}
+
+	t.basicType = underlying.GetBasicType()
+	t.arrayType = underlying.arrayType.Copy()
+	t.funcType = underlying.funcType.Copy()
+	t.mapType = underlying.mapType.Copy()
+	t.structType = underlying.structType.Copy()
+	t.interfaceType = underlying.interfaceType.Copy()
+}
+
+func (t *Type) IsNamed() bool {
+	return t.name != ""
+}
+
 func (t *Type) SetBasicType(basicType BasicType) {
 	t.basicType = basicType
 }
@@ -60,6 +113,35 @@ func (t *Type) Equal(t2 *Type) bool {
 		return true
 	}
 
+	// 不同的自定义类型不相等, 自定义类型与未命名的复合类型比较底层类型
+	if t.name != t2.name || t.packageName != t2.packageName {
+		if (t.IsNamed() && t2.IsNamed()) || !t.IsComposite() || !t2.IsComposite() {
+			return false
+		}
+	}
+
+	// 同一泛型类型的不同实例不相等, eg: Stack[int], Stack[string]
+	// 泛型类型实例与未命名的复合类型之间只比较底层类型
+	if t.IsNamed() && t2.IsNamed() {
+		if len(t.typeArgs) != len(t2.typeArgs) {
+			return false
+		}
+		for i := range t.typeArgs {
+			if t.typeArgs[i].GetTypeName() != t2.typeArgs[i].GetTypeName() {
+				return false
+			}
+		}
+	}
+
+	return t.UnderlyingEqual(t2)
+}
+
+// 底层类型是否相同, 用于类型转换
+func (t *Type) UnderlyingEqual(t2 *Type) bool {
+	if t.IsInterface() && t2.IsInterface() {
+		return true
+	}
+
 	if t.GetBasicType() != t2.GetBasicType() {
 		return false
 	}
@@ -72,6 +154,14 @@ func (t *Type) Equal(t2 *Type) bool {
 		return false
 	}
 
+	if !t.mapType.Equal(t2.mapType) {
+		return false
+	}
+
+	if !t.structType.Equal(t2.structType) {
+		return false
+	}
+
 	if !t.multipleValueType.Equal(t2.multipleValueType) {
 		return false
 	}
@@ -159,8 +249,9 @@ func (t *FuncType) Copy() *FuncType {
 
 		for _, p := range params {
 			newParams = append(newParams, &Parameter{
-				Type: p.Type.Copy(),
-				Name: p.Name,
+				Type:     p.Type.Copy(),
+				Name:     p.Name,
+				Ellipsis: p.Ellipsis,
 			})
 		}
 		return newParams
@@ -357,7 +448,7 @@ func (t *StructType) Equal(t2 *StructType) bool {
 
 	for i := 0; i < len(t.Fields); i++ {
 		f1 := t.Fields[i]
-		f2 := t.Fields[i]
+		f2 := t2.Fields[i]
 
 		if f1.Name != f2.Name {
 			return false
@@ -371,6 +462,55 @@ func (t *StructType) Equal(t2 *StructType) bool {
 	return true
 }
 
+//
+// InterfaceType 约束接口, eg: interface { ~int | float }
+//
+type InterfaceType struct {
+	Terms      []*TypeTerm
+	Comparable bool // comparable
+}
+
+// 类型集中的一项, ~int表示底层类型为int的所有类型
+type TypeTerm struct {
+	Tilde bool
+	Type  *Type
+}
+
+func (t *InterfaceType) Copy() *InterfaceType {
+	if t == nil {
+		return nil
+	}
+
+	termList := make([]*TypeTerm, 0)
+
+	for _, term := range t.Terms {
+		termList = append(termList, CreateTypeTerm(term.Type.Copy(), term.Tilde))
+	}
+
+	return &InterfaceType{
+		Terms:      termList,
+		Comparable: t.Comparable,
+	}
+}
+
+func (t *InterfaceType) GetTypeName() string {
+	if t.Comparable {
+		return "comparable"
+	}
+
+	termNameList := []string{}
+
+	for _, term := range t.Terms {
+		termName := term.Type.GetTypeName()
+		if term.Tilde {
+			termName = "~" + termName
+		}
+		termNameList = append(termNameList, termName)
+	}
+
+	return fmt.Sprintf("interface{ %s }", strings.Join(termNameList, " | "))
+}
+
 //
 // create
 //
@@ -403,6 +543,26 @@ func CreateInterfaceType(pos Position) *Type {
 	return newType
 }
 
+func CreateConstraintType(pos Position, termList []*TypeTerm) *Type {
+	newType := CreateType(BasicTypeInterface, pos)
+	newType.interfaceType = &InterfaceType{Terms: termList}
+	return newType
+}
+
+func CreateTypeTerm(typ *Type, tilde bool) *TypeTerm {
+	return &TypeTerm{
+		Tilde: tilde,
+		Type:  typ,
+	}
+}
+
+// 泛型类型实例, eg: Stack[int], utils.Stack[T]
+func CreateGenericType(name string, typeArgs []*Type, pos Position) *Type {
+	typ := CreateTypeByName(name, pos)
+	typ.typeArgs = typeArgs
+	return typ
+}
+
 func CreateStructType(pos Position, fieldDeclList []*StructField) *Type {
 	newType := CreateType(BasicTypeStruct, pos)
 	newType.structType = NewStructType(fieldDeclList)
@@ -425,7 +585,7 @@ func (t *Type) IsFunc() bool {
 }
 
 func (t *Type) IsComposite() bool {
-	return t.IsArray() || t.IsFunc()
+	return t.IsArray() || t.IsFunc() || t.IsMap()
 }
 
 func (t *Type) IsVoid() bool {
@@ -440,8 +600,58 @@ func (t *Type) IsInt() bool {
 	return t.GetBasicType() == BasicTypeInt
 }
 
+// byte是uint8的别名
+func (t *Type) IsByte() bool {
+	return t.GetBasicType() == BasicTypeUint8
+}
+
+// rune是int32的别名
+func (t *Type) IsRune() bool {
+	return t.GetBasicType() == BasicTypeInt32
+}
+
+// 是否为整数类型, int, int8...uint64
+func (t *Type) IsInteger() bool {
+	return BasicTypeInt <= t.GetBasicType() && t.GetBasicType() <= BasicTypeUint64
+}
+
+func (t *Type) IsUnsigned() bool {
+	return BasicTypeUint <= t.GetBasicType() && t.GetBasicType() <= BasicTypeUint64
+}
+
+// 整数类型的位数
+func (t *Type) GetIntegerBits() int {
+	switch t.GetBasicType() {
+	case BasicTypeInt8, BasicTypeUint8:
+		return 8
+	case BasicTypeInt16, BasicTypeUint16:
+		return 16
+	case BasicTypeInt32, BasicTypeUint32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+func (t *Type) IsByteArray() bool {
+	return t.IsArray() && t.arrayType.ElementType.IsByte()
+}
+
+func (t *Type) IsRuneArray() bool {
+	return t.IsArray() && t.arrayType.ElementType.IsRune()
+}
+
+// float, float32
 func (t *Type) IsFloat() bool {
-	return t.GetBasicType() == BasicTypeFloat
+	return t.GetBasicType() == BasicTypeFloat || t.IsFloat32()
+}
+
+func (t *Type) IsFloat32() bool {
+	return t.GetBasicType() == BasicTypeFloat32
+}
+
+func (t *Type) IsNumber() bool {
+	return t.IsInteger() || t.IsFloat()
 }
 
 func (t *Type) IsString() bool {
@@ -476,13 +686,60 @@ func (t *Type) IsStruct() bool {
 	return t.GetBasicType() == BasicTypeStruct
 }
 
+// 可以使用==比较的类型, 切片, map, 函数只能与nil比较
+func (t *Type) IsComparable() bool {
+	if t.IsStruct() {
+		for _, field := range t.structType.Fields {
+			if !field.Type.IsComparable() {
+				return false
+			}
+		}
+		return true
+	}
+
+	return t.IsBool() || t.IsNumber() || t.IsString() || t.IsInterface()
+}
+
+// 可以比较大小的类型
+func (t *Type) IsOrdered() bool {
+	return t.IsNumber() || t.IsString()
+}
+
 func (t *Type) GetTypeName() string {
-	typeName := GetBasicTypeName(t.GetBasicType())
+	if t.IsNamed() {
+		if len(t.typeArgs) == 0 {
+			return t.name
+		}
+
+		return t.name + GetTypeArgListName(t.typeArgs)
+	}
 
 	switch {
 	case t.IsArray():
-		typeName = "[]" + typeName
+		return "[]" + t.arrayType.ElementType.GetTypeName()
+	case t.IsMap():
+		return fmt.Sprintf("map[%s]%s", t.mapType.Key.GetTypeName(), t.mapType.Value.GetTypeName())
+	case t.IsStruct():
+		return "struct"
+	case t.IsInterface():
+		if t.interfaceType != nil {
+			return t.interfaceType.GetTypeName()
+		}
+		return "interface{}"
+	case t.IsMultipleValues():
+		typeNameList := []string{}
+
+		for _, subType := range t.multipleValueType.List {
+			typeNameList = append(typeNameList, subType.GetTypeName())
+		}
+
+		return fmt.Sprintf("(%s)", strings.Join(typeNameList, ", "))
 	case t.IsFunc():
+		// 未实例化的泛型函数没有确定的参数类型
+		if t.funcType == nil {
+			return "func"
+		}
+
 		paramTypeNameList := []string{}
 		resultTypeNameList := []string{}
 
@@ -494,14 +751,58 @@ func (t *Type) GetTypeName() string {
 			resultTypeNameList = append(resultTypeNameList, p.Type.GetTypeName())
 		}
 
-		typeName = fmt.Sprintf(
+		return fmt.Sprintf(
 			"func(%s) (%s)",
 			strings.Join(paramTypeNameList, ", "),
 			strings.Join(resultTypeNameList, ", "),
 		)
 	}
 
-	return typeName
+	return GetBasicTypeName(t.GetBasicType())
+}
+
+// 运行时的类型名, 用于区分interface的动态类型
+// 自定义类型带上包的唯一标识, eg: main.MyInt, utils.Stack[int]
+func (t *Type) GetRuntimeTypeName() string {
+	if t.IsNamed() {
+		name := t.packageName + "." + t.name
+		if len(t.typeArgs) > 0 {
+			nameList := []string{}
+			for _, typeArg := range t.typeArgs {
+				nameList = append(nameList, typeArg.GetRuntimeTypeName())
+			}
+			name += "[" + strings.Join(nameList, ",") + "]"
+		}
+		return name
+	}
+
+	switch {
+	case t.IsArray():
+		return "[]" + t.arrayType.ElementType.GetRuntimeTypeName()
+	case t.IsMap():
+		return fmt.Sprintf("map[%s]%s", t.mapType.Key.GetRuntimeTypeName(), t.mapType.Value.GetRuntimeTypeName())
+	case t.IsStruct():
+		fieldList := []string{}
+		for _, field := range t.structType.Fields {
+			fieldList = append(fieldList, field.Name+" "+field.Type.GetRuntimeTypeName())
+		}
+		return "struct{" + strings.Join(fieldList, "; ") + "}"
+	case t.IsFunc():
+		paramList := []string{}
+		resultList := []string{}
+
+		for _, p := range t.funcType.Params {
+			paramList = append(paramList, p.Type.GetRuntimeTypeName())
+		}
+
+		for _, p := range t.funcType.Results {
+			resultList = append(resultList, p.Type.GetRuntimeTypeName())
+		}
+
+		return fmt.Sprintf("func(%s) (%s)", strings.Join(paramList, ", "), strings.Join(resultList, ", "))
+	}
+
+	return GetBasicTypeName(t.GetBasicType())
 }
 
 func GetBasicTypeName(typ BasicType) string {
@@ -510,8 +811,28 @@ func GetBasicTypeName(typ BasicType) string {
 		return "bool"
 	case BasicTypeInt:
 		return "int"
+	case BasicTypeInt8:
+		return "int8"
+	case BasicTypeInt16:
+		return "int16"
+	case BasicTypeInt32:
+		return "int32"
+	case BasicTypeInt64:
+		return "int64"
+	case BasicTypeUint:
+		return "uint"
+	case BasicTypeUint8:
+		return "uint8"
+	case BasicTypeUint16:
+		return "uint16"
+	case BasicTypeUint32:
+		return "uint32"
+	case BasicTypeUint64:
+		return "uint64"
 	case BasicTypeFloat:
 		return "float"
+	case BasicTypeFloat32:
+		return "float32"
 	case BasicTypeString:
 		return "string"
 	case BasicTypeNil:
@@ -529,18 +850,64 @@ func CreateTypeByName(name string, pos Position) *Type {
 
 	// TODO:
 	basicTypeMap := map[string]BasicType{
-		"bool":   BasicTypeBool,
-		"int":    BasicTypeInt,
-		"float":  BasicTypeFloat,
-		"string": BasicTypeString,
+		"bool":    BasicTypeBool,
+		"int":     BasicTypeInt,
+		"int8":    BasicTypeInt8,
+		"int16":   BasicTypeInt16,
+		"int32":   BasicTypeInt32,
+		"int64":   BasicTypeInt64,
+		"uint":    BasicTypeUint,
+		"uint8":   BasicTypeUint8,
+		"uint16":  BasicTypeUint16,
+		"uint32":  BasicTypeUint32,
+		"uint64":  BasicTypeUint64,
+		"float":   BasicTypeFloat,
+		"float32": BasicTypeFloat32,
+		"float64": BasicTypeFloat,
+		"string":  BasicTypeString,
+		"byte":    BasicTypeUint8,
+		"rune":    BasicTypeInt32,
+		"any":     BasicTypeInterface,
 	}
 
 	_, ok := basicTypeMap[name]
 	if ok {
 		basicType = basicTypeMap[name]
+		return CreateType(basicType, pos)
+	}
+
+	if name == "comparable" {
+		typ := CreateType(BasicTypeInterface, pos)
+		typ.interfaceType = &InterfaceType{Comparable: true}
+		return typ
+	}
+
+	// 泛型实例化时, 类型参数替换为实际类型
+	if typeArg, ok := GetCurrentPackage().typeArgs[name]; ok {
+		typ := typeArg.Copy()
+		typ.SetPosition(pos)
+		return typ
+	}
+
+	// 自定义类型, eg: MyInt, utils.MyInt
+	typ := CreateType(basicType, pos)
+
+	if index := strings.LastIndex(name, "."); index >= 0 {
+		typ.packageName = name[:index]
+		typ.name = name[index+1:]
+		if !IsExported(typ.name) {
+			compileError(pos, UNEXPORTED_NAME_ERR, name)
+		}
+		// 包的引用名替换为包的唯一标识
+		if imp := GetCurrentPackage().searchImportByName(typ.packageName); imp != nil {
+			typ.packageName = imp.GetPackageName()
+		}
+	} else {
+		typ.packageName = GetCurrentPackage().GetPackageName()
+		typ.name = name
 	}
 
-	return CreateType(basicType, pos)
+	return typ
 }
 
 func (t *Type) Copy() *Type {
@@ -551,6 +918,10 @@ func (t *Type) Copy() *Type {
 	newType.multipleValueType = t.multipleValueType.Copy()
 	newType.mapType = t.mapType.Copy()
 	newType.structType = t.structType.Copy()
+	newType.interfaceType = t.interfaceType.Copy()
+	newType.name = t.name
+	newType.packageName = t.packageName
+	newType.typeArgs = copyTypeList(t.typeArgs)
 
 	return newType
 }
@@ -4,11 +4,11 @@ import (
 	"github.com/lth-go/gogo/vm"
 )
 
-func (cm *Compiler) GetVmVariableList() []vm.Object {
-	variableList := make([]vm.Object, 0)
-
-	for _, decl := range cm.DeclarationList {
-		variableList = append(variableList, GetVmVariable(decl.Value))
+func (cm *Compiler) GetVmVariableList() []vm.Value {
+	// 全局变量由顶层代码按依赖顺序初始化
+	variableList := make([]vm.Value, len(cm.DeclarationList))
+	for i := range variableList {
+		variableList[i] = vm.NilValue
 	}
 
 	return variableList
@@ -18,31 +18,55 @@ func (cm *Compiler) GetVmFunctionList() []*vm.GoGoFunction {
 	vmFuncList := make([]*vm.GoGoFunction, 0)
 
 	for _, fd := range cm.FuncList {
-		// TODO: 过滤掉_sys, 由虚拟机自己添加
-		if fd.PackageName == "_sys" {
+		// 过滤掉原生函数, 由虚拟机自己添加
+		if fd.IsNative() {
 			continue
 		}
 
-		variableList := make([]vm.Object, 0)
+		variableList := make([]vm.Value, 0)
 
 		for _, variable := range fd.DeclarationList {
 			variableList = append(variableList, GetVmVariable(variable.Value))
 		}
 
+		localNameList := []string{}
+		for _, param := range fd.GetType().funcType.Params {
+			localNameList = append(localNameList, param.Name)
+		}
+		for _, variable := range fd.DeclarationList {
+			localNameList = append(localNameList, variable.Name)
+		}
+
 		vmFuncList = append(vmFuncList, &vm.GoGoFunction{
-			ParamCount:   len(fd.GetType().funcType.Params),
-			ResultCount:  len(fd.GetType().funcType.Results),
-			VariableList: variableList,
-			CodeList:     fd.CodeList,
+			PackageName:    fd.PackageName,
+			Name:           fd.Name,
+			ParamCount:     len(fd.GetType().funcType.Params),
+			ResultCount:    len(fd.GetType().funcType.Results),
+			VariableList:   variableList,
+			CodeList:       fd.CodeList,
+			MaxStackSize:   len(variableList) + fd.MaxStackDepth,
+			LineNumberList: fd.LineNumberList,
+			LocalNameList:  localNameList,
 		})
 	}
 
 	return vmFuncList
 }
 
-func GetVmVariable(valueIFS Expression) vm.Object {
+// GetImage 编译结果, 可以保存为字节码文件
+func (cm *Compiler) GetImage() *vm.Image {
+	return &vm.Image{
+		ConstantList:   cm.ConstantList,
+		VariableList:   cm.GetVmVariableList(),
+		FunctionList:   cm.GetVmFunctionList(),
+		CodeList:       cm.CodeList,
+		LineNumberList: cm.LineNumberList,
+	}
+}
+
+func GetVmVariable(valueIFS Expression) vm.Value {
 	if valueIFS == nil {
-		return nil
+		return vm.Value{}
 	}
 
 	switch value := valueIFS.(type) {
@@ -51,37 +75,38 @@ func GetVmVariable(valueIFS Expression) vm.Object {
 		if value.Value {
 			v = 1
 		}
-		return vm.NewObjectInt(v)
+		return vm.IntValue(int64(v))
 	case *IntExpression:
-		return vm.NewObjectInt(value.Value)
+		value.CheckRange()
+		return vm.IntValue(value.Int64())
 	case *FloatExpression:
-		return vm.NewObjectFloat(value.Value)
+		return vm.FloatValue(value.Value)
 	case *StringExpression:
-		return vm.NewObjectString(value.Value)
+		return vm.StringValue(value.Value)
 	case *InterfaceExpression:
-		return vm.NewObjectInterface(GetVmVariable(value.Data))
+		return vm.ObjectValue(vm.NewObjectInterface(value.GetRuntimeType(), GetVmVariable(value.Data)))
 	case *NilExpression:
-		return vm.NilObject
+		return vm.NilValue
 	case *ArrayExpression:
 		arrayValue := vm.NewObjectArray(len(value.List))
 		for i, subValue := range value.List {
 			arrayValue.List[i] = GetVmVariable(subValue)
 		}
-		return arrayValue
+		return vm.ObjectValue(arrayValue)
 	case *MapExpression:
 		mapValue := vm.NewObjectMap()
 		length := len(value.KeyList)
 		for i := 0; i < length; i++ {
 			mapValue.Set(GetVmVariable(value.KeyList[i]), GetVmVariable(value.ValueList[i]))
 		}
-		return mapValue
+		return vm.ObjectValue(mapValue)
 	case *StructExpression:
 		structValue := vm.NewObjectStruct(len(value.FieldList))
 		for i, subValue := range value.FieldList {
 			structValue.FieldList[i] = GetVmVariable(subValue)
 		}
-		return structValue
+		return vm.ObjectValue(structValue)
 	}
 
-	return nil
+	return vm.Value{}
 }
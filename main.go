@@ -1,34 +1,162 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/lth-go/gogo/compiler"
 	"github.com/lth-go/gogo/vm"
 )
 
+const (
+	sourceSuffix = ".gogo"
+	imageSuffix  = ".gogoc"
+	asmSuffix    = ".gogoasm"
+)
+
+const usage = `用法:
+	gogo <源文件>                          编译并执行
+	gogo run <源文件, 汇编文件或字节码文件>   执行
+	gogo build [-o 输出文件] <源文件或汇编文件> 编译为字节码文件
+	gogo disasm <源文件或字节码文件>         反汇编, 输出可以保存为汇编文件
+`
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("参数错误\n")
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		log.Fatalf("参数错误\n%s", usage)
+	}
+
+	switch os.Args[1] {
+	case "build":
+		build(os.Args[2:])
+	case "run":
+		run(os.Args[2:])
+	case "disasm":
+		disasm(os.Args[2:])
+	default:
+		run(os.Args[1:])
+	}
+}
+
+// 编译源文件或包目录, 保存为字节码文件
+func build(args []string) {
+	flagSet := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flagSet.String("o", "", "输出的字节码文件, 默认为源文件名加上"+imageSuffix)
+	flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		log.Fatalf("参数错误\n%s", usage)
+	}
+
+	filename := flagSet.Arg(0)
+	checkFileExist(filename)
+
+	if *output == "" {
+		name := filepath.Base(filename)
+		*output = strings.TrimSuffix(strings.TrimSuffix(name, sourceSuffix), asmSuffix) + imageSuffix
+	}
+
+	image := getImage(filename)
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("创建文件失败: %v\n", err)
+	}
+	defer file.Close()
+
+	if err := vm.WriteImage(file, image); err != nil {
+		log.Fatalf("写入字节码文件失败: %v\n", err)
+	}
+}
+
+// 执行字节码文件, 或者编译后执行源文件
+func run(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("参数错误\n%s", usage)
 	}
 
-	filename := os.Args[1]
+	filename := args[0]
+	checkFileExist(filename)
 
+	VM, err := vm.NewVirtualMachineFromImage(getImage(filename))
+	if err != nil {
+		log.Fatalf("%s: %v\n", filename, err)
+	}
+	VM.Execute()
+}
+
+// 反汇编字节码文件或源文件, 输出到标准输出
+func disasm(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("参数错误\n%s", usage)
+	}
+
+	filename := args[0]
+	checkFileExist(filename)
+
+	if err := vm.Disassemble(os.Stdout, getImage(filename)); err != nil {
+		log.Fatalf("%v\n", err)
+	}
+}
+
+// 字节码文件直接读取, 汇编文件先汇编, 源文件先编译
+func getImage(filename string) *vm.Image {
+	switch filepath.Ext(filename) {
+	case imageSuffix:
+		return loadImage(filename)
+	case asmSuffix:
+		return assemble(filename)
+	}
+
+	return compile(filename)
+}
+
+func checkFileExist(filename string) {
 	_, err := os.Stat(filename)
 	if err != nil {
 		log.Fatalf("文件不存在\n")
 	}
+}
 
+func compile(filename string) *vm.Image {
 	cm := compiler.NewCompilerManager()
 
 	cm.CompileFile(filename)
 
-	VM := vm.NewVirtualMachine(
-		cm.ConstantList,
-		cm.GetVmVariableList(),
-		cm.GetVmFunctionList(),
-		cm.CodeList,
-	)
-	VM.Execute()
+	return cm.GetImage()
+}
+
+func assemble(filename string) *vm.Image {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("打开文件失败: %v\n", err)
+	}
+	defer file.Close()
+
+	image, err := vm.Assemble(file)
+	if err != nil {
+		log.Fatalf("%s: %v\n", filename, err)
+	}
+
+	return image
+}
+
+func loadImage(filename string) *vm.Image {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("打开文件失败: %v\n", err)
+	}
+	defer file.Close()
+
+	image, err := vm.ReadImage(file)
+	if err != nil {
+		log.Fatalf("%s: %v\n", filename, err)
+	}
+
+	return image
 }
@@ -0,0 +1,749 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//
+// Assemble 汇编
+//
+// 读取.gogoasm文本, 生成可以由NewVirtualMachineFromImage执行的镜像.
+// 每行一条指令或伪指令, 分号之后为注释:
+//
+//	.const c0 int 40000                   常量, 类型为int, float, string或type
+//	.const c1 type "int" comparable
+//	.global nil                           全局变量的初始值, 按顺序编号
+//	.entry                                顶层代码
+//	.func main add params 2 results 1     函数, stack省略时自动计算
+//	.param a                              形参的名字
+//	.local sum int 0                      局部变量及其初始值
+//	.line 6                               之后指令的源代码行号
+//	L0:                                   label
+//	0007  pop_stack sum                   指令, 行首的地址会被忽略
+//
+// 操作数可以是数字, 也可以是常量名, 形参和局部变量名, 函数名(包名.函数名)以及label,
+// 值的格式为zero, nil, int 1, uint 1, float 1.5, string "s", array(...), struct(...),
+// map(k: v, ...), interface("int" comparable, v).
+// 操作数超出两个字节的范围时自动加上wide前缀
+//
+func Assemble(r io.Reader) (*Image, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &assembler{
+		image:              &Image{},
+		constantIndex:      map[string]int{},
+		funcIndex:          map[string]int{},
+		nativeFunctionList: newNativeFunctionList(),
+		entry:              newAsmCode(nil),
+	}
+
+	for i, f := range a.nativeFunctionList {
+		f := f.(*GoGoNativeFunction)
+		a.addFunctionName(f.PackageName+"."+f.Name, i)
+	}
+
+	for i, line := range strings.Split(string(b), "\n") {
+		a.line = i + 1
+		a.parseLine(line)
+		if a.err != nil {
+			return nil, a.wrapError()
+		}
+	}
+
+	a.image.CodeList, a.image.LineNumberList = a.encode(a.entry)
+	for _, code := range a.codeList {
+		f := code.function
+		f.CodeList, f.LineNumberList = a.encode(code)
+
+		if a.err == nil {
+			a.fixFunction(code)
+		}
+	}
+
+	if a.err != nil {
+		return nil, a.wrapError()
+	}
+
+	return a.image, nil
+}
+
+type assembler struct {
+	image              *Image
+	constantIndex      map[string]int // 常量名对应的常量池下标
+	funcIndex          map[string]int // 函数名对应的函数下标, 重名时为-1
+	nativeFunctionList []Function
+
+	entry    *asmCode
+	codeList []*asmCode // 函数
+	current  *asmCode
+
+	line   int // 出错时的行号
+	tokens []asmToken
+	pos    int
+	err    error
+}
+
+// 顶层代码或者一个函数
+type asmCode struct {
+	function      *GoGoFunction // 顶层代码为nil
+	line          int           // .entry或.func所在的行
+	stackSize     int           // 省略时为-1
+	paramNameList []string
+	localNameList []string
+	labels        map[string]int // label对应的指令下标
+	instList      []*asmInstruction
+	lineNumber    int
+}
+
+type asmInstruction struct {
+	line        int
+	lineNumber  int // 源代码行号
+	code        byte
+	wide        bool
+	operandList []asmToken
+	operands    []int
+	label       string // 跳转指令的label, 操作数为地址时为空
+	address     int
+}
+
+func newAsmCode(f *GoGoFunction) *asmCode {
+	return &asmCode{
+		function:  f,
+		stackSize: -1,
+		labels:    map[string]int{},
+	}
+}
+
+func (a *assembler) fail(errorNumber int, args ...interface{}) {
+	if a.err == nil {
+		a.err = newVmError(errorNumber, args...)
+	}
+}
+
+func (a *assembler) wrapError() error {
+	return fmt.Errorf("第%d行: %w", a.line, a.err)
+}
+
+// 重名的函数只能通过下标引用
+func (a *assembler) addFunctionName(name string, index int) {
+	if _, ok := a.funcIndex[name]; ok {
+		index = -1
+	}
+	a.funcIndex[name] = index
+}
+
+//
+// 词法
+//
+
+type asmTokenKind int
+
+const (
+	asmTokenWord asmTokenKind = iota
+	asmTokenString
+	asmTokenPunct
+)
+
+type asmToken struct {
+	kind asmTokenKind
+	text string
+}
+
+func isAsmWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune(`;"(),:`, r)
+}
+
+func (a *assembler) tokenize(line string) []asmToken {
+	tokens := []asmToken{}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			return tokens
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				a.fail(ASM_SYNTAX_ERR, "字符串没有结束")
+				return nil
+			}
+			s, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, asmToken{asmTokenString, s})
+			i += len(quoted)
+		case strings.IndexByte("(),:", c) >= 0:
+			tokens = append(tokens, asmToken{asmTokenPunct, string(c)})
+			i++
+		default:
+			end := strings.IndexFunc(line[i:], func(r rune) bool { return !isAsmWordRune(r) })
+			if end == -1 {
+				end = len(line) - i
+			}
+			tokens = append(tokens, asmToken{asmTokenWord, line[i : i+end]})
+			i += end
+		}
+	}
+
+	return tokens
+}
+
+func (a *assembler) peek() asmToken {
+	if a.pos >= len(a.tokens) {
+		return asmToken{asmTokenPunct, ""}
+	}
+	return a.tokens[a.pos]
+}
+
+func (a *assembler) next() asmToken {
+	if a.pos >= len(a.tokens) {
+		a.fail(ASM_SYNTAX_ERR, "缺少内容")
+		return asmToken{asmTokenPunct, ""}
+	}
+	a.pos++
+	return a.tokens[a.pos-1]
+}
+
+func (a *assembler) expect(punct string) {
+	if token := a.next(); token.kind != asmTokenPunct || token.text != punct {
+		a.fail(ASM_SYNTAX_ERR, "缺少"+punct)
+	}
+}
+
+func (a *assembler) end() {
+	if a.pos < len(a.tokens) {
+		a.fail(ASM_SYNTAX_ERR, "多余的"+a.tokens[a.pos].text)
+	}
+}
+
+func (a *assembler) word() string {
+	token := a.next()
+	if token.kind != asmTokenWord {
+		a.fail(ASM_SYNTAX_ERR, "缺少名字")
+	}
+	return token.text
+}
+
+// 名字可以加引号
+func (a *assembler) name() string {
+	token := a.next()
+	if token.kind == asmTokenPunct {
+		a.fail(ASM_SYNTAX_ERR, "缺少名字")
+	}
+	return token.text
+}
+
+func (a *assembler) quoted() string {
+	token := a.next()
+	if token.kind != asmTokenString {
+		a.fail(ASM_SYNTAX_ERR, "缺少字符串")
+	}
+	return token.text
+}
+
+func (a *assembler) integer() int64 {
+	text := a.word()
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		a.fail(ASM_SYNTAX_ERR, "无效的整数"+text)
+	}
+	return value
+}
+
+//
+// 语法
+//
+
+func (a *assembler) parseLine(line string) {
+	a.tokens = a.tokenize(line)
+	a.pos = 0
+
+	if a.err != nil || len(a.tokens) == 0 {
+		return
+	}
+
+	first := a.tokens[0]
+
+	if first.kind == asmTokenWord && strings.HasPrefix(first.text, ".") {
+		a.pos++
+		a.parseDirective(first.text)
+		a.end()
+		return
+	}
+
+	if a.current == nil {
+		a.fail(ASM_SYNTAX_ERR, "指令不在.entry或.func中")
+		return
+	}
+
+	// label
+	if len(a.tokens) >= 2 && first.kind == asmTokenWord && a.tokens[1].kind == asmTokenPunct && a.tokens[1].text == ":" {
+		if _, ok := a.current.labels[first.text]; ok {
+			a.fail(ASM_DUPLICATE_ERR, "label", first.text)
+			return
+		}
+		a.current.labels[first.text] = len(a.current.instList)
+		a.pos += 2
+
+		if a.pos == len(a.tokens) {
+			return
+		}
+	}
+
+	// 反汇编输出的地址
+	if _, err := strconv.Atoi(a.peek().text); err == nil && a.peek().kind == asmTokenWord {
+		a.pos++
+	}
+
+	a.parseInstruction()
+	a.end()
+}
+
+func (a *assembler) parseDirective(directive string) {
+	switch directive {
+	case ".const":
+		name := a.word()
+		if _, ok := a.constantIndex[name]; ok {
+			a.fail(ASM_DUPLICATE_ERR, "常量", name)
+			return
+		}
+		a.constantIndex[name] = len(a.image.ConstantList)
+		a.image.ConstantList = append(a.image.ConstantList, a.parseConstant())
+	case ".global":
+		a.image.VariableList = append(a.image.VariableList, a.parseValue())
+	case ".entry":
+		if a.entry.line != 0 {
+			a.fail(ASM_DUPLICATE_ERR, "伪指令", directive)
+			return
+		}
+		a.entry.line = a.line
+		a.current = a.entry
+	case ".func":
+		f := &GoGoFunction{
+			PackageName: a.name(),
+			Name:        a.name(),
+		}
+
+		code := newAsmCode(f)
+		code.line = a.line
+
+		for a.pos < len(a.tokens) && a.err == nil {
+			switch key := a.word(); key {
+			case "params":
+				f.ParamCount = int(a.integer())
+			case "results":
+				f.ResultCount = int(a.integer())
+			case "stack":
+				code.stackSize = int(a.integer())
+			default:
+				a.fail(ASM_SYNTAX_ERR, "未知的属性"+key)
+			}
+		}
+
+		a.addFunctionName(f.PackageName+"."+f.Name, len(a.nativeFunctionList)+len(a.image.FunctionList))
+		a.image.FunctionList = append(a.image.FunctionList, f)
+		a.codeList = append(a.codeList, code)
+		a.current = code
+	case ".param", ".local":
+		if a.current == nil || a.current.function == nil {
+			a.fail(ASM_SYNTAX_ERR, directive+"不在.func中")
+			return
+		}
+
+		name := a.name()
+		if name == "_" {
+			name = ""
+		}
+
+		if directive == ".param" {
+			a.current.paramNameList = append(a.current.paramNameList, name)
+		} else {
+			a.current.localNameList = append(a.current.localNameList, name)
+			a.current.function.VariableList = append(a.current.function.VariableList, a.parseValue())
+		}
+	case ".line":
+		if a.current == nil {
+			a.fail(ASM_SYNTAX_ERR, ".line不在.entry或.func中")
+			return
+		}
+		a.current.lineNumber = int(a.integer())
+	default:
+		a.fail(ASM_SYNTAX_ERR, "未知的伪指令"+directive)
+	}
+}
+
+// 注记符对应的指令
+var mnemonicMap = func() map[string]byte {
+	m := map[string]byte{}
+	for code, info := range OpcodeInfo {
+		if code != OP_CODE_WIDE {
+			m[info.Mnemonic] = code
+		}
+	}
+	return m
+}()
+
+func (a *assembler) parseInstruction() {
+	inst := &asmInstruction{
+		line:       a.line,
+		lineNumber: a.current.lineNumber,
+	}
+
+	mnemonic := a.word()
+	if mnemonic == "wide" {
+		inst.wide = true
+		mnemonic = a.word()
+	}
+
+	code, ok := mnemonicMap[mnemonic]
+	if !ok {
+		a.fail(ASM_SYNTAX_ERR, "未知的指令"+mnemonic)
+		return
+	}
+	inst.code = code
+
+	for range OpcodeInfo[code].Parameter {
+		inst.operandList = append(inst.operandList, a.next())
+	}
+
+	a.current.instList = append(a.current.instList, inst)
+}
+
+func (a *assembler) parseConstant() interface{} {
+	switch kind := a.word(); kind {
+	case "int":
+		return a.integer()
+	case "float":
+		return a.float()
+	case "string":
+		return a.quoted()
+	case "type":
+		return a.parseRuntimeType()
+	default:
+		a.fail(ASM_SYNTAX_ERR, "未知的常量类型"+kind)
+	}
+
+	return nil
+}
+
+func (a *assembler) float() float64 {
+	text := a.word()
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		a.fail(ASM_SYNTAX_ERR, "无效的浮点数"+text)
+	}
+	return value
+}
+
+func (a *assembler) parseRuntimeType() RuntimeType {
+	typ := RuntimeType{Name: a.quoted()}
+
+	if token := a.peek(); token.kind == asmTokenWord && token.text == "comparable" {
+		a.pos++
+		typ.Comparable = true
+	}
+
+	return typ
+}
+
+func (a *assembler) parseValue() Value {
+	switch kind := a.word(); kind {
+	case "zero":
+		return Value{}
+	case "nil":
+		return NilValue
+	case "int":
+		return IntValue(a.integer())
+	case "uint":
+		text := a.word()
+		value, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			a.fail(ASM_SYNTAX_ERR, "无效的整数"+text)
+		}
+		return UintValue(value)
+	case "float":
+		return FloatValue(a.float())
+	case "string":
+		return StringValue(a.quoted())
+	case "array":
+		list := a.parseValueList()
+		array := NewObjectArray(len(list))
+		copy(array.List, list)
+		return ObjectValue(array)
+	case "struct":
+		list := a.parseValueList()
+		struct_ := NewObjectStruct(len(list))
+		copy(struct_.FieldList, list)
+		return ObjectValue(struct_)
+	case "map":
+		map_ := NewObjectMap()
+		a.expect("(")
+		for a.err == nil && a.peek().text != ")" {
+			key := a.parseValue()
+			a.expect(":")
+			value := a.parseValue()
+			if _, ok := valueHash(key); !ok {
+				a.fail(UNHASHABLE_TYPE_ERR, unhashableTypeName(key))
+				break
+			}
+			map_.Set(key, value)
+			if a.peek().text != ")" {
+				a.expect(",")
+			}
+		}
+		a.expect(")")
+		return ObjectValue(map_)
+	case "interface":
+		a.expect("(")
+		typ := a.parseRuntimeType()
+		a.expect(",")
+		data := a.parseValue()
+		a.expect(")")
+		return ObjectValue(NewObjectInterface(typ, data))
+	default:
+		a.fail(ASM_SYNTAX_ERR, "未知的值"+kind)
+	}
+
+	return Value{}
+}
+
+func (a *assembler) parseValueList() []Value {
+	list := []Value{}
+
+	a.expect("(")
+	for a.err == nil && a.peek().text != ")" {
+		list = append(list, a.parseValue())
+		if a.peek().text != ")" {
+			a.expect(",")
+		}
+	}
+	a.expect(")")
+
+	return list
+}
+
+//
+// 编码
+//
+
+// 解析操作数, 确定跳转地址后编码为字节码
+func (a *assembler) encode(code *asmCode) ([]byte, []*LineNumber) {
+	for _, inst := range code.instList {
+		if a.err != nil {
+			return nil, nil
+		}
+
+		a.line = inst.line
+		a.resolveOperands(code, inst)
+	}
+
+	if a.err != nil {
+		return nil, nil
+	}
+
+	// 跳转地址超出两个字节时加上wide前缀, 指令变长后重新计算地址
+	for {
+		address := 0
+		for _, inst := range code.instList {
+			inst.address = address
+			address += inst.size()
+		}
+
+		changed := false
+		for _, inst := range code.instList {
+			if inst.label == "" {
+				continue
+			}
+
+			index := code.labels[inst.label]
+			if index == len(code.instList) {
+				inst.operands[0] = address
+			} else {
+				inst.operands[0] = code.instList[index].address
+			}
+
+			if !inst.wide && inst.operands[0] > MaxShortOperand {
+				inst.wide = true
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	codeList := []byte{}
+	lineNumberList := []*LineNumber{}
+
+	for _, inst := range code.instList {
+		if inst.wide {
+			codeList = append(codeList, OP_CODE_WIDE)
+		}
+		codeList = append(codeList, inst.code)
+
+		for i, param := range []byte(OpcodeInfo[inst.code].Parameter) {
+			size := OperandSize(param, inst.wide)
+			b := make([]byte, size)
+			SetOperand(b, size, inst.operands[i])
+			codeList = append(codeList, b...)
+		}
+
+		// 源代码中相同的一行合并
+		if n := len(lineNumberList); n > 0 && lineNumberList[n-1].LineNumber == inst.lineNumber {
+			lineNumberList[n-1].PcCount = len(codeList) - lineNumberList[n-1].StartPc
+		} else {
+			lineNumberList = append(lineNumberList, &LineNumber{
+				LineNumber: inst.lineNumber,
+				StartPc:    inst.address,
+				PcCount:    len(codeList) - inst.address,
+			})
+		}
+	}
+
+	if len(codeList) == 0 {
+		return nil, nil
+	}
+
+	return codeList, lineNumberList
+}
+
+func (inst *asmInstruction) size() int {
+	size := 1
+	if inst.wide {
+		size++
+	}
+
+	for _, param := range []byte(OpcodeInfo[inst.code].Parameter) {
+		size += OperandSize(param, inst.wide)
+	}
+
+	return size
+}
+
+func (a *assembler) resolveOperands(code *asmCode, inst *asmInstruction) {
+	mnemonic := OpcodeInfo[inst.code].Mnemonic
+
+	for i, param := range []byte(OpcodeInfo[inst.code].Parameter) {
+		token := inst.operandList[i]
+		value, err := strconv.ParseInt(token.text, 10, 64)
+		isNumber := err == nil && token.kind == asmTokenWord
+
+		switch {
+		case token.kind == asmTokenPunct:
+			a.fail(ASM_SYNTAX_ERR, "缺少操作数")
+			return
+		case param == 'l' && !isNumber:
+			if _, ok := code.labels[token.text]; !ok {
+				a.fail(ASM_UNDEFINED_ERR, "label", token.text)
+				return
+			}
+			inst.label = token.text
+		case isNumber:
+		case param == 'p':
+			index, ok := a.constantIndex[token.text]
+			if !ok {
+				a.fail(ASM_UNDEFINED_ERR, "常量", token.text)
+				return
+			}
+			value = int64(index)
+		case inst.code == OP_CODE_PUSH_FUNCTION:
+			index, ok := a.funcIndex[token.text]
+			if !ok {
+				a.fail(ASM_UNDEFINED_ERR, "函数", token.text)
+				return
+			}
+			if index == -1 {
+				a.fail(ASM_AMBIGUOUS_ERR, "函数", token.text)
+				return
+			}
+			value = int64(index)
+		case inst.code == OP_CODE_PUSH_STACK || inst.code == OP_CODE_POP_STACK:
+			value = int64(a.localIndex(code, token.text))
+		default:
+			a.fail(ASM_SYNTAX_ERR, "无效的操作数"+token.text)
+			return
+		}
+
+		switch param {
+		case 'b':
+			if value < 0 || value > 255 {
+				a.fail(ASM_SYNTAX_ERR, fmt.Sprintf("%s的操作数%d超出了范围", mnemonic, value))
+			}
+		case 's', 'p', 'l':
+			if value < MinWideOperand || value > MaxWideOperand {
+				a.fail(ASM_SYNTAX_ERR, fmt.Sprintf("%s的操作数%d超出了范围", mnemonic, value))
+			}
+			if value < MinShortOperand || value > MaxShortOperand {
+				inst.wide = true
+			}
+		}
+
+		inst.operands = append(inst.operands, int(value))
+	}
+}
+
+// 形参和局部变量在栈上的位置, 见GoGoFunction.LocalNameList
+func (a *assembler) localIndex(code *asmCode, name string) int {
+	index := 0
+	count := 0
+
+	if code.function != nil {
+		for i, paramName := range code.paramNameList {
+			if paramName == name {
+				index = i - code.function.ParamCount
+				count++
+			}
+		}
+	}
+	for i, localName := range code.localNameList {
+		if localName == name {
+			index = i + 1
+			count++
+		}
+	}
+
+	switch count {
+	case 0:
+		a.fail(ASM_UNDEFINED_ERR, "变量", name)
+	case 1:
+	default:
+		a.fail(ASM_AMBIGUOUS_ERR, "变量", name)
+	}
+
+	return index
+}
+
+// 设置变量名, 计算栈大小
+func (a *assembler) fixFunction(code *asmCode) {
+	f := code.function
+	a.line = code.line
+
+	if len(code.paramNameList) > f.ParamCount {
+		a.fail(ASM_SYNTAX_ERR, ".param多于params")
+		return
+	}
+
+	f.LocalNameList = make([]string, f.ParamCount, f.ParamCount+len(code.localNameList))
+	copy(f.LocalNameList, code.paramNameList)
+	f.LocalNameList = append(f.LocalNameList, code.localNameList...)
+
+	if code.stackSize >= 0 {
+		f.MaxStackSize = code.stackSize
+		return
+	}
+
+	depth, err := MaxStackDepth(f.CodeList)
+	if err != nil {
+		a.err = err
+		return
+	}
+	f.MaxStackSize = len(f.VariableList) + depth
+}
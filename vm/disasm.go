@@ -0,0 +1,318 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Disassemble 反汇编
+//
+// 输出为.gogoasm格式, 可以由Assemble重新汇编, 格式见asm.go.
+// 每条指令前是字节码地址, 常量池的值, 函数名, 变量名附在注释中,
+// 跳转地址替换为label, 源代码行号以.line给出
+//
+func Disassemble(w io.Writer, image *Image) error {
+	d := &disassembler{
+		w:     bufio.NewWriter(w),
+		image: image,
+	}
+
+	// 函数下标与虚拟机一致: 原生函数在前, 然后是镜像中的函数
+	nativeFunctionList := newNativeFunctionList()
+	for _, f := range nativeFunctionList {
+		f := f.(*GoGoNativeFunction)
+		d.funcNameList = append(d.funcNameList, f.PackageName+"."+f.Name)
+	}
+	for _, f := range image.FunctionList {
+		d.funcNameList = append(d.funcNameList, f.PackageName+"."+f.Name)
+	}
+
+	fmt.Fprintf(d.w, "; constants %d, globals %d, functions %d\n", len(image.ConstantList), len(image.VariableList), len(image.FunctionList))
+
+	for i, c := range image.ConstantList {
+		fmt.Fprintf(d.w, ".const c%d %s\n", i, d.formatConstant(c))
+	}
+	for _, value := range image.VariableList {
+		fmt.Fprintf(d.w, ".global %s\n", d.formatValue(value))
+	}
+
+	fmt.Fprintf(d.w, "\n.entry\n")
+	d.disassembleCode(nil, image.CodeList, image.LineNumberList)
+
+	for i, f := range image.FunctionList {
+		header := fmt.Sprintf(".func %s %s params %d results %d stack %d",
+			asmName(f.PackageName), asmName(f.Name), f.ParamCount, f.ResultCount, f.MaxStackSize)
+		fmt.Fprintf(d.w, "\n%-52s ; index %d\n", header, len(nativeFunctionList)+i)
+
+		// 形参和局部变量的名字只用于调试, 可以没有
+		nameList := f.LocalNameList
+		if len(nameList) != f.ParamCount+len(f.VariableList) {
+			nameList = make([]string, f.ParamCount+len(f.VariableList))
+		}
+
+		if len(f.LocalNameList) > 0 {
+			for _, name := range nameList[:f.ParamCount] {
+				fmt.Fprintf(d.w, "    .param %s\n", asmLocalName(name))
+			}
+		}
+		for i, value := range f.VariableList {
+			fmt.Fprintf(d.w, "    .local %s %s\n", asmLocalName(nameList[f.ParamCount+i]), d.formatValue(value))
+		}
+
+		d.disassembleCode(f, f.CodeList, f.LineNumberList)
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+
+	return d.w.Flush()
+}
+
+type disassembler struct {
+	w            *bufio.Writer
+	image        *Image
+	funcNameList []string
+	err          error
+}
+
+// 只记录第一个错误
+func (d *disassembler) fail(value interface{}) {
+	if d.err == nil {
+		d.err = newVmError(DISASM_VALUE_ERR, value)
+	}
+}
+
+func (d *disassembler) disassembleCode(f *GoGoFunction, codeList []byte, lineNumberList []*LineNumber) {
+	// 指令的起始位置, 跳转目标不是指令的起始位置时直接输出地址
+	boundaries := map[int]bool{len(codeList): true}
+	for pc := 0; pc < len(codeList); pc += DecodeInstruction(codeList, pc).Size {
+		boundaries[pc] = true
+	}
+
+	// 跳转目标按地址顺序编号
+	labels := map[int]string{}
+	targetList := []int{}
+	for pc := 0; pc < len(codeList); {
+		inst := DecodeInstruction(codeList, pc)
+		if OpcodeInfo[inst.Code].Parameter == "l" && boundaries[inst.Operands[0]] {
+			if _, ok := labels[inst.Operands[0]]; !ok {
+				labels[inst.Operands[0]] = ""
+				targetList = append(targetList, inst.Operands[0])
+			}
+		}
+		pc += inst.Size
+	}
+	sort.Ints(targetList)
+	for i, target := range targetList {
+		labels[target] = fmt.Sprintf("L%d", i)
+	}
+
+	lineIndex := 0
+	lastLine := 0
+	for pc := 0; pc < len(codeList); {
+		for lineIndex < len(lineNumberList) && lineNumberList[lineIndex].StartPc <= pc {
+			// 编译器生成的指令行号为0, 不输出, 汇编时沿用上一个行号
+			if line := lineNumberList[lineIndex].LineNumber; line != 0 && line != lastLine {
+				fmt.Fprintf(d.w, "    .line %d\n", line)
+				lastLine = line
+			}
+			lineIndex++
+		}
+
+		if label, ok := labels[pc]; ok {
+			fmt.Fprintf(d.w, "%s:\n", label)
+		}
+
+		inst := DecodeInstruction(codeList, pc)
+
+		mnemonic := OpcodeInfo[inst.Code].Mnemonic
+		if inst.Wide {
+			mnemonic = "wide " + mnemonic
+		}
+
+		operandList := []string{}
+		for i, p := range []byte(OpcodeInfo[inst.Code].Parameter) {
+			operand := inst.Operands[i]
+			switch {
+			case p == 'p' && operand >= 0 && operand < len(d.image.ConstantList):
+				operandList = append(operandList, fmt.Sprintf("c%d", operand))
+			case p == 'l' && labels[operand] != "":
+				operandList = append(operandList, labels[operand])
+			default:
+				operandList = append(operandList, strconv.Itoa(operand))
+			}
+		}
+
+		line := fmt.Sprintf("    %04d  %-24s %s", pc, mnemonic, strings.Join(operandList, " "))
+		if comment := d.comment(f, inst); comment != "" {
+			line = fmt.Sprintf("%-52s ; %s", line, comment)
+		}
+		fmt.Fprintln(d.w, strings.TrimRight(line, " "))
+
+		pc += inst.Size
+	}
+
+	// 跳转到字节码末尾
+	if label, ok := labels[len(codeList)]; ok {
+		fmt.Fprintf(d.w, "%s:\n", label)
+	}
+}
+
+// 操作数的说明
+func (d *disassembler) comment(f *GoGoFunction, inst Instruction) string {
+	switch inst.Code {
+	case OP_CODE_PUSH_INT, OP_CODE_PUSH_FLOAT, OP_CODE_PUSH_STRING, OP_CODE_NEW_INTERFACE:
+		index := inst.Operands[0]
+		if index < 0 || index >= len(d.image.ConstantList) {
+			return "?"
+		}
+
+		switch c := d.image.ConstantList[index].(type) {
+		case string:
+			return strconv.Quote(c)
+		case Value:
+			return strconv.Quote(c.String())
+		case RuntimeType:
+			return c.Name
+		default:
+			return fmt.Sprint(c)
+		}
+	case OP_CODE_PUSH_FUNCTION:
+		index := inst.Operands[0]
+		if index < 0 || index >= len(d.funcNameList) {
+			return "?"
+		}
+		return d.funcNameList[index]
+	case OP_CODE_PUSH_STACK, OP_CODE_POP_STACK:
+		// 形参在返回信息之前, 局部变量在之后
+		if f == nil || len(f.LocalNameList) == 0 {
+			return ""
+		}
+
+		index := inst.Operands[0] + f.ParamCount
+		if inst.Operands[0] > 0 {
+			index--
+		}
+		if index < 0 || index >= len(f.LocalNameList) {
+			return "?"
+		}
+		return localName(f.LocalNameList[index])
+	case OP_CODE_INVOKE:
+		return fmt.Sprintf("%d args", inst.Operands[0])
+	}
+
+	return ""
+}
+
+// 编译器生成的临时变量没有名字
+func localName(name string) string {
+	if name == "" {
+		return "<temp>"
+	}
+	return name
+}
+
+// 汇编中没有名字的形参和局部变量写作_
+func asmLocalName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return asmName(name)
+}
+
+// 包名和函数名含有分隔符时加上引号, eg: "Map[int,float]"
+func asmName(name string) string {
+	if name == "" {
+		return `""`
+	}
+
+	for _, r := range name {
+		if !isAsmWordRune(r) {
+			return strconv.Quote(name)
+		}
+	}
+
+	return name
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatRuntimeType(typ RuntimeType) string {
+	if typ.Comparable {
+		return strconv.Quote(typ.Name) + " comparable"
+	}
+	return strconv.Quote(typ.Name)
+}
+
+func (d *disassembler) formatConstant(c interface{}) string {
+	switch c := c.(type) {
+	case int64:
+		return "int " + strconv.FormatInt(c, 10)
+	case float64:
+		return "float " + formatFloat(c)
+	case string:
+		return "string " + strconv.Quote(c)
+	case Value:
+		// 虚拟机中字符串常量已创建为对象
+		return "string " + strconv.Quote(c.String())
+	case RuntimeType:
+		return "type " + formatRuntimeType(c)
+	}
+
+	d.fail(c)
+	return ""
+}
+
+func (d *disassembler) formatValue(value Value) string {
+	switch value.kind {
+	case ValueKindInt:
+		return "int " + strconv.FormatInt(value.Int(), 10)
+	case ValueKindUint:
+		return "uint " + strconv.FormatUint(value.Uint(), 10)
+	case ValueKindFloat:
+		return "float " + formatFloat(value.Float())
+	}
+
+	formatList := func(list []Value) string {
+		s := []string{}
+		for _, v := range list {
+			s = append(s, d.formatValue(v))
+		}
+		return strings.Join(s, ", ")
+	}
+
+	switch obj := value.obj.(type) {
+	case nil:
+		return "zero"
+	case *ObjectNil:
+		return "nil"
+	case *ObjectString:
+		return "string " + strconv.Quote(obj.Value)
+	case *ObjectArray:
+		return "array(" + formatList(obj.List) + ")"
+	case *ObjectMap:
+		s := []string{}
+		for _, entry := range obj.entries {
+			if entry.deleted {
+				continue
+			}
+			s = append(s, d.formatValue(entry.key)+": "+d.formatValue(entry.value))
+		}
+		return "map(" + strings.Join(s, ", ") + ")"
+	case *ObjectStruct:
+		return "struct(" + formatList(obj.FieldList) + ")"
+	case *ObjectInterface:
+		return "interface(" + formatRuntimeType(obj.Type) + ", " + d.formatValue(obj.Data) + ")"
+	}
+
+	d.fail(value.obj)
+	return ""
+}
@@ -0,0 +1,156 @@
+package vm
+
+import (
+	"math"
+)
+
+//
+// ValueEqual 按值比较, 用于==, !=
+//
+// interface比较动态类型和值, 结构体逐个比较字段,
+// 切片, map, 函数只能与nil比较, 因此按引用比较即可
+//
+func ValueEqual(a Value, b Value) bool {
+	if a.kind != b.kind {
+		return false
+	}
+
+	switch a.kind {
+	case ValueKindInt, ValueKindUint:
+		return a.bits == b.bits
+	case ValueKindFloat:
+		return a.Float() == b.Float()
+	}
+
+	switch a := a.obj.(type) {
+	case *ObjectNil:
+		_, ok := b.obj.(*ObjectNil)
+		return ok
+	case *ObjectString:
+		b, ok := b.obj.(*ObjectString)
+		return ok && a.Value == b.Value
+	case *ObjectInterface:
+		b, ok := b.obj.(*ObjectInterface)
+		if !ok || a.Type.Name != b.Type.Name {
+			return false
+		}
+		if !a.Type.Comparable {
+			vmError(UNCOMPARABLE_TYPE_ERR, a.Type.Name)
+		}
+		return ValueEqual(a.Data, b.Data)
+	case *ObjectStruct:
+		b, ok := b.obj.(*ObjectStruct)
+		if !ok || len(a.FieldList) != len(b.FieldList) {
+			return false
+		}
+		for i := range a.FieldList {
+			if !ValueEqual(a.FieldList[i], b.FieldList[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a.obj == b.obj
+}
+
+// map的键的哈希值, 相等的键哈希值相同
+// 键不可比较时返回false, 例如动态类型为切片的interface
+func valueHash(key Value) (uint64, bool) {
+	switch key.kind {
+	case ValueKindInt, ValueKindUint:
+		return hashUint64(key.bits), true
+	case ValueKindFloat:
+		return hashFloat(key.Float()), true
+	}
+
+	switch obj := key.obj.(type) {
+	case *ObjectNil:
+		return 0, true
+	case *ObjectString:
+		return hashString(obj.Value), true
+	case *ObjectInterface:
+		if !obj.Type.Comparable {
+			return 0, false
+		}
+		hash, ok := valueHash(obj.Data)
+		return hashCombine(hashString(obj.Type.Name), hash), ok
+	case *ObjectStruct:
+		hash := uint64(len(obj.FieldList))
+		for _, field := range obj.FieldList {
+			fieldHash, ok := valueHash(field)
+			if !ok {
+				return 0, false
+			}
+			hash = hashCombine(hash, fieldHash)
+		}
+		return hash, true
+	}
+
+	return 0, false
+}
+
+// 不可比较的键的类型名, 用于报错
+func unhashableTypeName(key Value) string {
+	switch obj := key.obj.(type) {
+	case *ObjectInterface:
+		if obj.Type.Comparable {
+			return unhashableTypeName(obj.Data)
+		}
+		return obj.Type.Name
+	case *ObjectStruct:
+		for _, field := range obj.FieldList {
+			if _, ok := valueHash(field); !ok {
+				return unhashableTypeName(field)
+			}
+		}
+	case *ObjectArray:
+		return "slice"
+	case *ObjectMap:
+		return "map"
+	}
+
+	return "unknown"
+}
+
+// splitmix64
+func hashUint64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
+
+// NaN与任何值都不相等, 每次使用不同的哈希值, 避免所有NaN落在同一个桶
+var nanHashSeed uint64
+
+func hashFloat(f float64) uint64 {
+	switch {
+	case f != f:
+		nanHashSeed++
+		return hashUint64(nanHashSeed)
+	case f == 0:
+		// 0.0与-0.0相等
+		return hashUint64(0)
+	}
+
+	return hashUint64(math.Float64bits(f))
+}
+
+// FNV-1a
+func hashString(s string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint64(s[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
+
+func hashCombine(hash uint64, x uint64) uint64 {
+	return hashUint64(hash*31 + x)
+}
@@ -18,13 +18,40 @@ const (
 	CLASS_NOT_FOUND_ERR
 	CLASS_CAST_ERR
 	DYNAMIC_LOAD_WITHOUT_PACKAGE_ERR
+	NIL_MAP_ASSIGN_ERR
+	UNCOMPARABLE_TYPE_ERR
+	UNHASHABLE_TYPE_ERR
+	NATIVE_ARGUMENT_ERR
+	MEM_STAT_FIELD_ERR
+	STACK_UNDERFLOW_ERR
+	STACK_DEPTH_MISMATCH_ERR
+	STACK_DEPTH_ERR
+	JUMP_OUT_OF_RANGE_ERR
+	IMAGE_FORMAT_ERR
+	IMAGE_VERSION_ERR
+	IMAGE_VALUE_ERR
+	NATIVE_FUNCTION_MISMATCH_ERR
+	DISASM_VALUE_ERR
+	UNKNOWN_OPCODE_ERR
+	TRUNCATED_INSTRUCTION_ERR
+	OPERAND_OUT_OF_RANGE_ERR
+	JUMP_TARGET_ERR
+	MISSING_RETURN_ERR
+	STACK_SIZE_ERR
+	RESULT_COUNT_ERR
+	INVALID_FUNCTION_ERR
+	ARGUMENT_COUNT_ERR
+	ASM_SYNTAX_ERR
+	ASM_UNDEFINED_ERR
+	ASM_DUPLICATE_ERR
+	ASM_AMBIGUOUS_ERR
 )
 
 var errMessageMap map[int]string = map[int]string{
 	BAD_MULTIBYTE_CHARACTER_ERR:      "不正确的多字节字符。",
 	FUNCTION_NOT_FOUND_ERR:           "找不到函数$(name)。",
 	FUNCTION_MULTIPLE_DEFINE_ERR:     "重复定义了函数%s.%s。",
-	INDEX_OUT_OF_BOUNDS_ERR:          "数组下标越界。数组大小为$(size)，访问的下标为[$(index)]。",
+	INDEX_OUT_OF_BOUNDS_ERR:          "下标越界。访问的下标为[%d]，长度为%d。",
 	DIVISION_BY_ZERO_ERR:             "整数值不能被0除。",
 	NULL_POINTER_ERR:                 "引用了null。",
 	LOAD_FILE_NOT_FOUND_ERR:          "没有找到要加载的文件$(file)",
@@ -33,6 +60,33 @@ var errMessageMap map[int]string = map[int]string{
 	CLASS_NOT_FOUND_ERR:              "没有找到类$(name)。",
 	CLASS_CAST_ERR:                   "对象的类型为$(org)。,不能向下转型为$(target)。",
 	DYNAMIC_LOAD_WITHOUT_PACKAGE_ERR: "由于函数$(name)没有指定包，不能动态加载。",
+	NIL_MAP_ASSIGN_ERR:               "不能向nil map赋值。",
+	UNCOMPARABLE_TYPE_ERR:            "比较了不可比较的类型%s。",
+	UNHASHABLE_TYPE_ERR:              "不可比较的类型%s不能作为map的键。",
+	NATIVE_ARGUMENT_ERR:              "函数%s不支持%T类型的参数。",
+	MEM_STAT_FIELD_ERR:               "runtime.memStat没有统计项%s。",
+	STACK_UNDERFLOW_ERR:              "字节码地址%d处操作数栈下溢。",
+	STACK_DEPTH_MISMATCH_ERR:         "字节码地址%d处的操作数栈深度不一致(%d, %d)。",
+	STACK_DEPTH_ERR:                  "顶层代码的字节码有误: %v",
+	JUMP_OUT_OF_RANGE_ERR:            "跳转地址%d超出了字节码的范围。",
+	IMAGE_FORMAT_ERR:                 "不是有效的字节码文件。",
+	IMAGE_VERSION_ERR:                "字节码文件的版本%d与虚拟机支持的版本%d不一致。",
+	IMAGE_VALUE_ERR:                  "%T类型的值不能保存到字节码文件。",
+	NATIVE_FUNCTION_MISMATCH_ERR:     "字节码文件中的原生函数%s.%s与虚拟机不一致。",
+	DISASM_VALUE_ERR:                 "%T类型的值不能反汇编。",
+	UNKNOWN_OPCODE_ERR:               "字节码地址%d处的指令%d无效。",
+	TRUNCATED_INSTRUCTION_ERR:        "字节码地址%d处的指令不完整。",
+	OPERAND_OUT_OF_RANGE_ERR:         "字节码地址%d处指令%s的操作数%d超出了范围。",
+	JUMP_TARGET_ERR:                  "字节码地址%d处的跳转地址%d不是指令的起始位置。",
+	MISSING_RETURN_ERR:               "没有以return指令结束。",
+	STACK_SIZE_ERR:                   "栈大小%d不足, 至少需要%d。",
+	RESULT_COUNT_ERR:                 "形参数量%d和返回值数量%d不能为负数。",
+	INVALID_FUNCTION_ERR:             "调用的函数%d不存在。",
+	ARGUMENT_COUNT_ERR:               "函数%s.%s需要%d个参数, 调用时传入了%d个。",
+	ASM_SYNTAX_ERR:                   "语法错误, %s。",
+	ASM_UNDEFINED_ERR:                "未定义的%s %s。",
+	ASM_DUPLICATE_ERR:                "重复定义了%s %s。",
+	ASM_AMBIGUOUS_ERR:                "%s %s有多个定义, 需要使用下标。",
 }
 
 func vmError(errorNumber int, a ...interface{}) {
@@ -40,3 +94,7 @@ func vmError(errorNumber int, a ...interface{}) {
 	errMsg := fmt.Sprintf(errMessageMap[errorNumber], a...)
 	log.Fatalf("%d\n%s", errorNumber, errMsg)
 }
+
+func newVmError(errorNumber int, a ...interface{}) error {
+	return fmt.Errorf(errMessageMap[errorNumber], a...)
+}
@@ -10,28 +10,49 @@ type Function interface{}
 // 用户函数
 //
 type GoGoFunction struct {
-	ParamCount   int
-	ResultCount  int
-	VariableList []Object
-	CodeList     []byte
+	PackageName    string
+	Name           string
+	ParamCount     int
+	ResultCount    int
+	VariableList   []Value
+	CodeList       []byte
+	MaxStackSize   int           // 调用时需要的栈空间, 包括局部变量和操作数栈的最大深度
+	LineNumberList []*LineNumber // 行号表
+	LocalNameList  []string      // 形参和局部变量的名字, 用于反汇编
 }
 
 //
 // 原生函数
 //
 type GoGoNativeFunction struct {
+	PackageName string
+	Name        string
 	ParamCount  int                // 参数数量
 	ResultCount int                // 返回值数量
 	Proc        NativeFunctionProc // 函数指针
 }
 
-type NativeFunctionProc func(vm *VirtualMachine, paramCount int, args []Object) []Object
+type NativeFunctionProc func(vm *VirtualMachine, paramCount int, args []Value) []Value
 
 func (vm *VirtualMachine) AddNativeFunctions() {
 	vm.addNativeFunction("_sys", "printf", nativeFuncPrintf, 2, 0)
 	vm.addNativeFunction("_sys", "len", nativeFuncLen, 1, 1)
 	vm.addNativeFunction("_sys", "append", nativeFuncAppend, 2, 1)
 	vm.addNativeFunction("_sys", "delete", nativeFuncDelete, 2, 0)
+
+	vm.addNativeFunction("runtime", "GC", nativeFuncRuntimeGC, 0, 0)
+	vm.addNativeFunction("runtime", "NumGoroutine", nativeFuncRuntimeNumGoroutine, 0, 1)
+	vm.addNativeFunction("runtime", "readMemStats", nativeFuncRuntimeReadMemStats, 0, 0)
+	vm.addNativeFunction("runtime", "memStat", nativeFuncRuntimeMemStat, 1, 1)
+	vm.addNativeFunction("runtime", "objectCount", nativeFuncRuntimeObjectCount, 1, 1)
+}
+
+// 原生函数表, 与虚拟机中的顺序一致
+func newNativeFunctionList() []Function {
+	vm := &VirtualMachine{}
+	vm.AddNativeFunctions()
+
+	return vm.funcList
 }
 
 func (vm *VirtualMachine) addNativeFunction(
@@ -42,6 +63,8 @@ func (vm *VirtualMachine) addNativeFunction(
 	resultCount int,
 ) {
 	function := &GoGoNativeFunction{
+		PackageName: packageName,
+		Name:        funcName,
 		Proc:        proc,
 		ParamCount:  paramCount,
 		ResultCount: resultCount,
@@ -50,29 +73,48 @@ func (vm *VirtualMachine) addNativeFunction(
 	vm.funcList = append(vm.funcList, function)
 }
 
-func nativeFuncPrintf(vm *VirtualMachine, paramCount int, args []Object) []Object {
-	format := args[0].(*ObjectString).Value
+func nativeFuncPrintf(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	format := args[0].String()
 
-	switch a := args[1].(type) {
+	switch a := args[1].obj.(type) {
 	case *ObjectNil:
 		fmt.Printf(format)
 	case *ObjectArray:
 		list := make([]interface{}, 0)
-		for _, valueIFS := range a.List {
-			switch value := valueIFS.(type) {
-			case *ObjectInt:
-				list = append(list, value.Value)
-			case *ObjectFloat:
-				list = append(list, value.Value)
-			case *ObjectString:
-				list = append(list, value.Value)
+		for _, value := range a.List {
+			// 打印interface中保存的值
+			typeName := ""
+			if ifs, ok := value.obj.(*ObjectInterface); ok {
+				value = ifs.Data
+				typeName = ifs.Type.Name
+			}
+
+			switch value.kind {
+			case ValueKindInt:
+				list = append(list, value.Int())
+			case ValueKindUint:
+				list = append(list, value.Uint())
+			case ValueKindFloat:
+				// float32按32位精度输出, eg: 0.3而不是0.30000001192092896
+				if typeName == "float32" {
+					list = append(list, float32(value.Float()))
+				} else {
+					list = append(list, value.Float())
+				}
 			default:
-				panic("TODO")
+				switch obj := value.obj.(type) {
+				case *ObjectNil:
+					list = append(list, nil)
+				case *ObjectString:
+					list = append(list, obj.Value)
+				default:
+					vmError(NATIVE_ARGUMENT_ERR, "printf", obj)
+				}
 			}
 		}
 		fmt.Printf(format, list...)
 	default:
-		panic("TODO")
+		vmError(NATIVE_ARGUMENT_ERR, "printf", a)
 	}
 
 	fmt.Printf("")
@@ -80,37 +122,100 @@ func nativeFuncPrintf(vm *VirtualMachine, paramCount int, args []Object) []Objec
 	return nil
 }
 
-func nativeFuncLen(vm *VirtualMachine, paramCount int, args []Object) []Object {
+func nativeFuncLen(vm *VirtualMachine, paramCount int, args []Value) []Value {
 	var length int
 
-	switch obj := args[0].(type) {
+	switch obj := args[0].obj.(type) {
 	case *ObjectString:
 		length = len(obj.Value)
 	case *ObjectArray:
 		length = obj.Len()
 	case *ObjectMap:
-		length = len(obj.Map)
+		length = obj.Len()
+	case *ObjectNil:
+		length = 0
 	default:
-		panic("TODO")
+		vmError(NATIVE_ARGUMENT_ERR, "len", obj)
 	}
 
-	return []Object{NewObjectInt(length)}
+	return []Value{IntValue(int64(length))}
 }
 
-func nativeFuncAppend(vm *VirtualMachine, paramCount int, args []Object) []Object {
-	obj := args[0].(*ObjectArray)
-	arg := args[1].(*ObjectArray)
+func nativeFuncAppend(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	arg := args[1].obj.(*ObjectArray)
 
+	// 向nil切片追加时创建新的数组
+	obj, ok := args[0].obj.(*ObjectArray)
+	if !ok {
+		obj = vm.NewEmptyObjectArray(0)
+	}
+
+	for _, value := range arg.List {
+		vm.writeBarrier(value)
+	}
+
+	// 扩容的部分计入堆大小
+	size := obj.Size()
 	obj.List = append(obj.List, arg.List...)
+	vm.heap.size += obj.Size() - size
 
-	return []Object{obj}
+	return []Value{ObjectValue(obj)}
 }
 
-func nativeFuncDelete(vm *VirtualMachine, paramCount int, args []Object) []Object {
-	obj := args[0].(*ObjectMap)
+func nativeFuncDelete(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	// 删除nil map中的元素不做处理
+	obj, ok := args[0].obj.(*ObjectMap)
+	if !ok {
+		return nil
+	}
 	key := args[1]
 
 	obj.Delete(key)
 
 	return nil
 }
+
+//
+// runtime包
+//
+
+func nativeFuncRuntimeGC(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	vm.GC()
+	return nil
+}
+
+// 没有协程, 只有主程序在执行
+func nativeFuncRuntimeNumGoroutine(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	return []Value{IntValue(1)}
+}
+
+// 保存统计信息的快照, 由memStat, objectCount读取
+func nativeFuncRuntimeReadMemStats(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	vm.memStats = vm.Stats()
+	return nil
+}
+
+func nativeFuncRuntimeMemStat(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	var value int
+
+	switch args[0].String() {
+	case "HeapObjects":
+		value = vm.memStats.HeapObjects
+	case "HeapAlloc":
+		value = vm.memStats.HeapAlloc
+	case "NumGC":
+		value = vm.memStats.NumGC
+	case "PauseTotalNs":
+		value = int(vm.memStats.PauseTotal.Nanoseconds())
+	case "MaxStackDepth":
+		value = vm.memStats.MaxStackDepth
+	default:
+		vmError(MEM_STAT_FIELD_ERR, args[0].String())
+	}
+
+	return []Value{IntValue(int64(value))}
+}
+
+func nativeFuncRuntimeObjectCount(vm *VirtualMachine, paramCount int, args []Value) []Value {
+	return []Value{IntValue(int64(vm.memStats.ObjectCounts[args[0].String()]))}
+}
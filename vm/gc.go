@@ -1,63 +1,277 @@
 package vm
 
-var HeapThresholdSize = 1024 * 256
+import (
+	"math"
+	"time"
+)
 
 //
-// Check 判断是否下需要gc
+// 增量三色标记清理
 //
-func (vm *VirtualMachine) Check() {
-	if len(vm.heap.list) > vm.heap.currentThreshold {
+// 白色: 未标记; 灰色: 已标记, 在标记栈中等待遍历; 黑色: 已标记且已遍历.
+// 标记和清理分多步进行, 穿插在对象分配之间, 每步的工作量与分配的字节数成正比.
+// 标记阶段向对象和静态区写入值时通过写屏障将值置为灰色, 保证黑色对象不会引用白色对象,
+// 栈没有写屏障, 标记结束时重新扫描根集合.
+//
+
+// SetGCParams 设置gc的步调参数
+func (vm *VirtualMachine) SetGCParams(params GCParams) {
+	vm.heap.params = params
+	vm.setThreshold()
+}
+
+//
+// Check 分配对象前调用
+//
+// 堆超过阈值时开始新一轮gc, gc进行中时按分配的字节数推进增量工作
+//
+func (vm *VirtualMachine) Check(size int) {
+	heap := vm.heap
+
+	// 完整模式下每次分配都执行一轮完整的gc, 漏标的对象会立即被回收
+	if heap.stress == gcStressFull {
 		vm.GC()
-		vm.heap.currentThreshold += HeapThresholdSize
+		return
 	}
-}
 
-func (vm *VirtualMachine) GC() {
-	vm.Mark()
-	vm.Sweep()
+	if heap.phase == gcPhaseIdle {
+		if heap.stress == gcStressOff && heap.size < heap.currentThreshold {
+			return
+		}
+		vm.startCycle()
+	}
+
+	// 增量模式下每次分配都推进少量工作, 标记和清理与程序尽量多地穿插执行, 暴露写屏障的问题
+	if heap.stress == gcStressIncremental {
+		vm.step(size * 2)
+		return
+	}
+
+	heap.debt += size
+	if heap.debt < heap.params.StepSize {
+		return
+	}
+
+	work := heap.debt * heap.params.StepMul / 100
+	heap.debt = 0
+	vm.step(work)
 }
 
 //
-// Mark 标记
+// GC 完整执行一轮gc
 //
-func (vm *VirtualMachine) Mark() {
-	for _, obj := range vm.heap.list {
-		obj.ResetMark()
+func (vm *VirtualMachine) GC() {
+	// 先完成进行中的一轮, 其中的存活对象可能已经不可达
+	if vm.heap.phase != gcPhaseIdle {
+		vm.step(math.MaxInt)
 	}
 
-	// 静态区
-	for _, obj := range vm.static.list {
-		if obj != nil {
-			obj.Mark()
+	vm.startCycle()
+	vm.step(math.MaxInt)
+}
+
+// 开始新一轮gc, 根集合置为灰色
+func (vm *VirtualMachine) startCycle() {
+	vm.heap.phase = gcPhaseMark
+	vm.heap.debt = 0
+	vm.markRoots()
+}
+
+// 推进增量工作, work为本次标记或清理的字节数
+func (vm *VirtualMachine) step(work int) {
+	heap := vm.heap
+
+	start := time.Now()
+	defer func() {
+		heap.pauseTotal += time.Since(start)
+	}()
+
+	for work > 0 && heap.phase != gcPhaseIdle {
+		switch heap.phase {
+		case gcPhaseMark:
+			work -= vm.markStep()
+		case gcPhaseSweep:
+			work -= vm.sweepStep()
 		}
 	}
+}
+
+// 遍历一个灰色对象, 返回对象的字节数
+func (vm *VirtualMachine) markStep() int {
+	heap := vm.heap
 
+	if len(heap.markStack) == 0 {
+		vm.finishMark()
+		return 1
+	}
+
+	obj := heap.markStack[len(heap.markStack)-1]
+	heap.markStack[len(heap.markStack)-1] = nil
+	heap.markStack = heap.markStack[:len(heap.markStack)-1]
+
+	obj.Walk(vm.markValue)
+
+	return obj.Size()
+}
+
+// 标记结束: 重新扫描根集合, 一次性标记完剩余的灰色对象, 然后进入清理阶段
+func (vm *VirtualMachine) finishMark() {
+	vm.markRoots()
+	vm.drainMarkStack(nil)
+
+	heap := vm.heap
+	heap.phase = gcPhaseSweep
+	heap.sweepIndex = 0
+	heap.liveCount = 0
+	heap.liveSize = 0
+}
+
+// 根集合: 栈, 静态区, 常量池以及函数局部变量的初始值
+func (vm *VirtualMachine) markRoots() {
 	for i := 0; i < vm.stack.stackPointer; i++ {
-		obj := vm.stack.Get(i)
-		if obj != nil {
-			obj.Mark()
+		vm.markValue(vm.stack.Get(i))
+	}
+
+	for _, value := range vm.static.list {
+		vm.markValue(value)
+	}
+
+	for _, c := range vm.constant {
+		if value, ok := c.(Value); ok {
+			vm.markValue(value)
+		}
+	}
+
+	for _, f := range vm.funcList {
+		if f, ok := f.(*GoGoFunction); ok {
+			for _, value := range f.VariableList {
+				vm.markValue(value)
+			}
+		}
+	}
+}
+
+// 白色对象置为灰色
+func (vm *VirtualMachine) markValue(value Value) {
+	obj := value.obj
+	if obj == nil || obj.IsMarked() {
+		return
+	}
+
+	obj.Mark()
+	vm.heap.markStack = append(vm.heap.markStack, obj)
+}
+
+// 依次取出灰色对象, 标记其引用的对象, visit不为nil时对每个对象调用
+func (vm *VirtualMachine) drainMarkStack(visit func(Object)) {
+	heap := vm.heap
+
+	for len(heap.markStack) > 0 {
+		obj := heap.markStack[len(heap.markStack)-1]
+		heap.markStack[len(heap.markStack)-1] = nil
+		heap.markStack = heap.markStack[:len(heap.markStack)-1]
+
+		if visit != nil {
+			visit(obj)
 		}
+
+		obj.Walk(vm.markValue)
 	}
 }
 
 //
-// Sweep 清理
+// writeBarrier 写屏障
 //
-func (vm *VirtualMachine) Sweep() {
-	newObjectList := []Object{}
-	for _, obj := range vm.heap.list {
-		if !obj.IsMarked() {
-			obj.Sweep()
-		} else {
-			newObjectList = append(newObjectList, obj)
+// 标记阶段向对象或静态区写入值时调用, 将值置为灰色
+//
+func (vm *VirtualMachine) writeBarrier(value Value) {
+	if vm.heap.phase == gcPhaseMark {
+		vm.markValue(value)
+	}
+}
+
+// 检查一个对象, 回收白色对象, 存活对象重置为白色并移动到list前部, 返回对象的字节数
+func (vm *VirtualMachine) sweepStep() int {
+	heap := vm.heap
+
+	// 清理过程中分配的对象追加在list末尾, 同样会被检查
+	if heap.sweepIndex >= len(heap.list) {
+		vm.finishSweep()
+		return 1
+	}
+
+	obj := heap.list[heap.sweepIndex]
+	heap.sweepIndex++
+
+	size := obj.Size()
+
+	if !obj.IsMarked() {
+		obj.Sweep()
+		return size
+	}
+
+	obj.ResetMark()
+	heap.list[heap.liveCount] = obj
+	heap.liveCount++
+	heap.liveSize += size
+
+	return size
+}
+
+// 清理结束, 根据存活的字节数计算下一轮的阈值
+func (vm *VirtualMachine) finishSweep() {
+	heap := vm.heap
+
+	// 释放被回收对象的引用
+	for i := heap.liveCount; i < len(heap.list); i++ {
+		heap.list[i] = nil
+	}
+
+	heap.list = heap.list[:heap.liveCount]
+	heap.size = heap.liveSize
+	heap.liveBytes = heap.liveSize
+	heap.phase = gcPhaseIdle
+	heap.numGC++
+
+	vm.setThreshold()
+}
+
+func (vm *VirtualMachine) setThreshold() {
+	heap := vm.heap
+
+	heap.currentThreshold = heap.size / 100 * heap.params.Pause
+	if heap.currentThreshold < heap.params.MinHeapSize {
+		heap.currentThreshold = heap.params.MinHeapSize
+	}
+}
+
+// 编译期创建的对象(全局变量, 局部变量的初始值, 字符串常量)加入堆中, 与运行时创建的对象一起回收
+func (vm *VirtualMachine) addRootObjects() {
+	vm.markRoots()
+	vm.drainMarkStack(func(obj Object) {
+		if obj != Object(NilObject) {
+			vm.heap.Append(obj)
 		}
+	})
+
+	for _, obj := range vm.heap.list {
+		obj.ResetMark()
 	}
-	vm.heap.list = newObjectList
 }
 
 // AddObject 添加对象到堆, 用于垃圾回收
 func (vm *VirtualMachine) AddObject(value Object) {
-	vm.Check()
+	vm.Check(value.Size())
+
+	// 标记阶段新分配的对象置为灰色, 遍历时内容已经填好;
+	// 清理阶段置为黑色, 本轮不会被回收
 	value.ResetMark()
+	switch vm.heap.phase {
+	case gcPhaseMark:
+		vm.markValue(ObjectValue(value))
+	case gcPhaseSweep:
+		value.Mark()
+	}
+
 	vm.heap.Append(value)
 }
@@ -1,24 +1,93 @@
 package vm
 
+import (
+	"os"
+	"time"
+)
+
+//
+// GCParams gc的步调参数
+//
+type GCParams struct {
+	MinHeapSize int // 堆的字节数超过该值才开始gc
+	Pause       int // 一轮gc结束后, 堆增长到存活字节数的百分之多少时开始下一轮
+	StepSize    int // gc进行中每分配多少字节推进一次增量工作
+	StepMul     int // 每分配一个字节, 标记或清理百分之多少字节的对象
+}
+
+var DefaultGCParams = GCParams{
+	MinHeapSize: 1024 * 1024,
+	Pause:       200,
+	StepSize:    8 * 1024,
+	StepMul:     200,
+}
+
+// gc阶段
+const (
+	gcPhaseIdle  = iota // 两轮gc之间
+	gcPhaseMark         // 增量标记
+	gcPhaseSweep        // 增量清理
+)
+
+// 压力模式, 由环境变量GOGO_GC_STRESS设置
+// GOGO_GC_STRESS=incremental时为增量模式, 其它非空值为完整模式
 const (
-	heapThresholdSize = 10240
+	gcStressOff         = iota
+	gcStressFull        // 每次分配对象前完整执行一轮gc, 回收所有不可达的对象
+	gcStressIncremental // 每次分配对象前推进少量增量工作, gc与程序尽量多地穿插执行, 暴露写屏障的问题
 )
 
+func gcStressMode() int {
+	switch os.Getenv("GOGO_GC_STRESS") {
+	case "":
+		return gcStressOff
+	case "incremental":
+		return gcStressIncremental
+	}
+
+	return gcStressFull
+}
+
 // 虚拟机堆
 type Heap struct {
 	list             []Object
-	currentThreshold int
+	size             int           // 对象占用的字节数, 上次gc后分配的对象按分配时的大小计算
+	currentThreshold int           // 超过阈值时开始新一轮gc
+	stress           int           // 压力模式, 每次分配对象时都推进gc, 用于排查漏标的对象
+	params           GCParams      // 步调参数
+	phase            int           // 当前所处的gc阶段
+	debt             int           // gc进行中分配了但还没有推进增量工作的字节数
+	markStack        []Object      // 灰色对象: 已标记, 等待遍历引用的对象
+	sweepIndex       int           // 清理阶段下一个要检查的对象
+	liveCount        int           // 清理阶段已检查的存活对象数量, 存活对象移动到list前部
+	liveSize         int           // 清理阶段已检查的存活对象的字节数
+	liveBytes        int           // 上一轮gc结束时存活对象的字节数
+	numGC            int           // 完成的gc轮数
+	pauseTotal       time.Duration // gc暂停的总时间
 }
 
 func NewHeap() *Heap {
 	h := &Heap{
 		list:             make([]Object, 0),
-		currentThreshold: heapThresholdSize,
+		currentThreshold: DefaultGCParams.MinHeapSize,
+		stress:           gcStressMode(),
+		params:           DefaultGCParams,
+		phase:            gcPhaseIdle,
 	}
 	return h
 }
 
 func (h *Heap) Append(value Object) {
 	h.list = append(h.list, value)
-	h.currentThreshold += value.Len()
+	h.size += value.Size()
+}
+
+// 遍历堆上的对象, 跳过清理阶段已经检查过的位置
+func (h *Heap) each(visit func(Object)) {
+	for i, obj := range h.list {
+		if h.phase == gcPhaseSweep && i >= h.liveCount && i < h.sweepIndex {
+			continue
+		}
+		visit(obj)
+	}
 }
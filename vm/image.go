@@ -0,0 +1,531 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+//
+// Image 编译后的字节码镜像, 可以保存为.gogoc文件, 不需要源码即可执行
+//
+type Image struct {
+	ConstantList   []interface{}   // 常量池: int64, float64, string, RuntimeType
+	VariableList   []Value         // 全局变量的初始值
+	FunctionList   []*GoGoFunction // 函数表, 不包括原生函数
+	CodeList       []byte          // 入口: 初始化全局变量, 调用init和main函数的顶层代码
+	LineNumberList []*LineNumber   // 顶层代码的行号表
+
+	verified bool // 读取时已经校验过, 创建虚拟机时不再校验
+}
+
+// 文件格式: magic "GOGC", 版本号, 原生函数表, 常量池, 全局变量, 函数表(包括调试用的名字), 顶层代码及其行号表
+// 整数使用varint编码, 字符串和字节码先写长度
+// 函数下标包括原生函数, 读取时原生函数表必须与虚拟机一致
+const (
+	imageMagic   = "GOGC"
+	ImageVersion = 3
+)
+
+// 常量和值的类型标记
+const (
+	imageTagInt byte = iota + 1
+	imageTagUint
+	imageTagFloat
+	imageTagString
+	imageTagRuntimeType
+	imageTagZero // 未初始化的值
+	imageTagNil
+	imageTagArray
+	imageTagMap
+	imageTagStruct
+	imageTagInterface
+)
+
+// NewVirtualMachineFromImage 由镜像创建虚拟机, 镜像先经过校验, ReadImage读取的镜像已经校验过
+func NewVirtualMachineFromImage(image *Image) (*VirtualMachine, error) {
+	if !image.verified {
+		if err := Verify(image); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewVirtualMachine(
+		image.ConstantList,
+		image.VariableList,
+		image.FunctionList,
+		image.CodeList,
+	), nil
+}
+
+//
+// 写入
+//
+
+type imageWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+// WriteImage 将镜像写入w
+func WriteImage(w io.Writer, image *Image) error {
+	iw := &imageWriter{w: bufio.NewWriter(w)}
+
+	iw.writeBytes([]byte(imageMagic))
+	iw.writeUint(ImageVersion)
+
+	nativeList := newNativeFunctionList()
+	iw.writeUint(uint64(len(nativeList)))
+	for _, f := range nativeList {
+		f := f.(*GoGoNativeFunction)
+		iw.writeString(f.PackageName)
+		iw.writeString(f.Name)
+		iw.writeInt(int64(f.ParamCount))
+		iw.writeInt(int64(f.ResultCount))
+	}
+
+	iw.writeUint(uint64(len(image.ConstantList)))
+	for _, c := range image.ConstantList {
+		iw.writeConstant(c)
+	}
+
+	iw.writeUint(uint64(len(image.VariableList)))
+	for _, value := range image.VariableList {
+		iw.writeValue(value)
+	}
+
+	iw.writeUint(uint64(len(image.FunctionList)))
+	for _, f := range image.FunctionList {
+		iw.writeString(f.PackageName)
+		iw.writeString(f.Name)
+		iw.writeInt(int64(f.ParamCount))
+		iw.writeInt(int64(f.ResultCount))
+		iw.writeInt(int64(f.MaxStackSize))
+
+		iw.writeUint(uint64(len(f.VariableList)))
+		for _, value := range f.VariableList {
+			iw.writeValue(value)
+		}
+
+		iw.writeCode(f.CodeList, f.LineNumberList)
+
+		iw.writeUint(uint64(len(f.LocalNameList)))
+		for _, name := range f.LocalNameList {
+			iw.writeString(name)
+		}
+	}
+
+	iw.writeCode(image.CodeList, image.LineNumberList)
+
+	if iw.err != nil {
+		return iw.err
+	}
+
+	return iw.w.Flush()
+}
+
+// 不能保存的常量或值, 例如函数调用信息
+func (iw *imageWriter) fail(value interface{}) {
+	if iw.err == nil {
+		iw.err = newVmError(IMAGE_VALUE_ERR, value)
+	}
+}
+
+func (iw *imageWriter) writeBytes(b []byte) {
+	if iw.err != nil {
+		return
+	}
+	_, iw.err = iw.w.Write(b)
+}
+
+func (iw *imageWriter) writeByte(b byte) {
+	iw.writeBytes([]byte{b})
+}
+
+func (iw *imageWriter) writeUint(value uint64) {
+	n := binary.PutUvarint(iw.buf[:], value)
+	iw.writeBytes(iw.buf[:n])
+}
+
+func (iw *imageWriter) writeInt(value int64) {
+	n := binary.PutVarint(iw.buf[:], value)
+	iw.writeBytes(iw.buf[:n])
+}
+
+func (iw *imageWriter) writeString(value string) {
+	iw.writeUint(uint64(len(value)))
+	iw.writeBytes([]byte(value))
+}
+
+func (iw *imageWriter) writeCode(codeList []byte, lineNumberList []*LineNumber) {
+	iw.writeUint(uint64(len(codeList)))
+	iw.writeBytes(codeList)
+
+	iw.writeUint(uint64(len(lineNumberList)))
+	for _, lineNumber := range lineNumberList {
+		iw.writeInt(int64(lineNumber.LineNumber))
+		iw.writeInt(int64(lineNumber.StartPc))
+		iw.writeInt(int64(lineNumber.PcCount))
+	}
+}
+
+func (iw *imageWriter) writeConstant(c interface{}) {
+	switch c := c.(type) {
+	case int64:
+		iw.writeByte(imageTagInt)
+		iw.writeInt(c)
+	case float64:
+		iw.writeByte(imageTagFloat)
+		iw.writeUint(math.Float64bits(c))
+	case string:
+		iw.writeByte(imageTagString)
+		iw.writeString(c)
+	case Value:
+		// 虚拟机中字符串常量已创建为对象
+		iw.writeByte(imageTagString)
+		iw.writeString(c.String())
+	case RuntimeType:
+		iw.writeByte(imageTagRuntimeType)
+		iw.writeRuntimeType(c)
+	default:
+		iw.fail(c)
+	}
+}
+
+func (iw *imageWriter) writeRuntimeType(typ RuntimeType) {
+	iw.writeString(typ.Name)
+	if typ.Comparable {
+		iw.writeByte(1)
+	} else {
+		iw.writeByte(0)
+	}
+}
+
+func (iw *imageWriter) writeValue(value Value) {
+	switch value.kind {
+	case ValueKindInt:
+		iw.writeByte(imageTagInt)
+		iw.writeInt(value.Int())
+		return
+	case ValueKindUint:
+		iw.writeByte(imageTagUint)
+		iw.writeUint(value.Uint())
+		return
+	case ValueKindFloat:
+		iw.writeByte(imageTagFloat)
+		iw.writeUint(value.bits)
+		return
+	}
+
+	switch obj := value.obj.(type) {
+	case nil:
+		iw.writeByte(imageTagZero)
+	case *ObjectNil:
+		iw.writeByte(imageTagNil)
+	case *ObjectString:
+		iw.writeByte(imageTagString)
+		iw.writeString(obj.Value)
+	case *ObjectArray:
+		iw.writeByte(imageTagArray)
+		iw.writeUint(uint64(len(obj.List)))
+		for _, v := range obj.List {
+			iw.writeValue(v)
+		}
+	case *ObjectMap:
+		iw.writeByte(imageTagMap)
+		iw.writeUint(uint64(obj.count))
+		for _, entry := range obj.entries {
+			if entry.deleted {
+				continue
+			}
+			iw.writeValue(entry.key)
+			iw.writeValue(entry.value)
+		}
+	case *ObjectStruct:
+		iw.writeByte(imageTagStruct)
+		iw.writeUint(uint64(len(obj.FieldList)))
+		for _, v := range obj.FieldList {
+			iw.writeValue(v)
+		}
+	case *ObjectInterface:
+		iw.writeByte(imageTagInterface)
+		iw.writeRuntimeType(obj.Type)
+		iw.writeValue(obj.Data)
+	default:
+		iw.fail(obj)
+	}
+}
+
+//
+// 读取
+//
+
+var errImageFormat = errors.New(errMessageMap[IMAGE_FORMAT_ERR])
+
+type imageReader struct {
+	b     []byte
+	err   error
+	depth int // 值的嵌套深度
+}
+
+// 值的最大嵌套深度, 避免损坏的文件导致过深的递归
+const imageMaxValueDepth = 1000
+
+// ReadImage 从r读取镜像
+func ReadImage(r io.Reader) (*Image, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ir := &imageReader{b: b}
+
+	magic := ir.readBytes(len(imageMagic))
+	if ir.err != nil || string(magic) != imageMagic {
+		return nil, errImageFormat
+	}
+
+	version := ir.readUint()
+	if ir.err == nil && version != ImageVersion {
+		return nil, newVmError(IMAGE_VERSION_ERR, version, ImageVersion)
+	}
+
+	// 原生函数的数量, 顺序, 参数和返回值数量都必须一致, 否则函数下标会指向错误的函数
+	nativeList := newNativeFunctionList()
+	nativeCount := ir.readLength()
+	for i := 0; i < nativeCount && ir.err == nil; i++ {
+		packageName, name := ir.readString(), ir.readString()
+		paramCount, resultCount := int(ir.readInt()), int(ir.readInt())
+		if ir.err != nil {
+			break
+		}
+
+		if i >= len(nativeList) {
+			return nil, newVmError(NATIVE_FUNCTION_MISMATCH_ERR, packageName, name)
+		}
+		f := nativeList[i].(*GoGoNativeFunction)
+		if f.PackageName != packageName || f.Name != name || f.ParamCount != paramCount || f.ResultCount != resultCount {
+			return nil, newVmError(NATIVE_FUNCTION_MISMATCH_ERR, packageName, name)
+		}
+	}
+	if ir.err == nil && nativeCount < len(nativeList) {
+		f := nativeList[nativeCount].(*GoGoNativeFunction)
+		return nil, newVmError(NATIVE_FUNCTION_MISMATCH_ERR, f.PackageName, f.Name)
+	}
+
+	image := &Image{}
+
+	image.ConstantList = make([]interface{}, ir.readLength())
+	for i := range image.ConstantList {
+		image.ConstantList[i] = ir.readConstant()
+	}
+
+	image.VariableList = make([]Value, ir.readLength())
+	for i := range image.VariableList {
+		image.VariableList[i] = ir.readValue()
+	}
+
+	image.FunctionList = make([]*GoGoFunction, ir.readLength())
+	for i := range image.FunctionList {
+		f := &GoGoFunction{
+			PackageName:  ir.readString(),
+			Name:         ir.readString(),
+			ParamCount:   int(ir.readInt()),
+			ResultCount:  int(ir.readInt()),
+			MaxStackSize: int(ir.readInt()),
+		}
+
+		f.VariableList = make([]Value, ir.readLength())
+		for j := range f.VariableList {
+			f.VariableList[j] = ir.readValue()
+		}
+
+		f.CodeList, f.LineNumberList = ir.readCode()
+
+		f.LocalNameList = make([]string, ir.readLength())
+		for j := range f.LocalNameList {
+			f.LocalNameList[j] = ir.readString()
+		}
+
+		image.FunctionList[i] = f
+	}
+
+	image.CodeList, image.LineNumberList = ir.readCode()
+
+	if ir.err == nil && len(ir.b) != 0 {
+		ir.fail()
+	}
+
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	// 读取的字节码不可信, 执行前校验
+	if err := Verify(image); err != nil {
+		return nil, err
+	}
+	image.verified = true
+
+	return image, nil
+}
+
+func (ir *imageReader) fail() {
+	if ir.err == nil {
+		ir.err = errImageFormat
+	}
+	ir.b = nil
+}
+
+func (ir *imageReader) readBytes(n int) []byte {
+	if n > len(ir.b) {
+		ir.fail()
+		return nil
+	}
+
+	b := ir.b[:n:n]
+	ir.b = ir.b[n:]
+
+	return b
+}
+
+func (ir *imageReader) readByte() byte {
+	b := ir.readBytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (ir *imageReader) readUint() uint64 {
+	value, n := binary.Uvarint(ir.b)
+	if n <= 0 {
+		ir.fail()
+		return 0
+	}
+
+	ir.b = ir.b[n:]
+	return value
+}
+
+func (ir *imageReader) readInt() int64 {
+	value, n := binary.Varint(ir.b)
+	if n <= 0 {
+		ir.fail()
+		return 0
+	}
+
+	ir.b = ir.b[n:]
+	return value
+}
+
+// 每个元素至少占一个字节, 长度超过剩余的字节数时文件已损坏, 避免分配过大的内存
+func (ir *imageReader) readLength() int {
+	length := ir.readUint()
+	if length > uint64(len(ir.b)) {
+		ir.fail()
+		return 0
+	}
+
+	return int(length)
+}
+
+func (ir *imageReader) readString() string {
+	return string(ir.readBytes(ir.readLength()))
+}
+
+func (ir *imageReader) readCode() ([]byte, []*LineNumber) {
+	codeList := ir.readBytes(ir.readLength())
+
+	lineNumberList := make([]*LineNumber, ir.readLength())
+	for i := range lineNumberList {
+		lineNumberList[i] = &LineNumber{
+			LineNumber: int(ir.readInt()),
+			StartPc:    int(ir.readInt()),
+			PcCount:    int(ir.readInt()),
+		}
+	}
+
+	return codeList, lineNumberList
+}
+
+func (ir *imageReader) readConstant() interface{} {
+	switch ir.readByte() {
+	case imageTagInt:
+		return ir.readInt()
+	case imageTagFloat:
+		return math.Float64frombits(ir.readUint())
+	case imageTagString:
+		return ir.readString()
+	case imageTagRuntimeType:
+		return ir.readRuntimeType()
+	}
+
+	ir.fail()
+	return nil
+}
+
+func (ir *imageReader) readRuntimeType() RuntimeType {
+	return RuntimeType{
+		Name:       ir.readString(),
+		Comparable: ir.readByte() == 1,
+	}
+}
+
+func (ir *imageReader) readValue() Value {
+	ir.depth++
+	defer func() {
+		ir.depth--
+	}()
+
+	if ir.depth > imageMaxValueDepth {
+		ir.fail()
+		return Value{}
+	}
+
+	switch ir.readByte() {
+	case imageTagInt:
+		return IntValue(ir.readInt())
+	case imageTagUint:
+		return UintValue(ir.readUint())
+	case imageTagFloat:
+		return FloatValue(math.Float64frombits(ir.readUint()))
+	case imageTagZero:
+		return Value{}
+	case imageTagNil:
+		return NilValue
+	case imageTagString:
+		return StringValue(ir.readString())
+	case imageTagArray:
+		array := NewObjectArray(ir.readLength())
+		for i := range array.List {
+			array.List[i] = ir.readValue()
+		}
+		return ObjectValue(array)
+	case imageTagMap:
+		map_ := NewObjectMap()
+		for i, count := 0, ir.readLength(); i < count && ir.err == nil; i++ {
+			key := ir.readValue()
+			if _, ok := valueHash(key); !ok {
+				ir.fail()
+				break
+			}
+			map_.Set(key, ir.readValue())
+		}
+		return ObjectValue(map_)
+	case imageTagStruct:
+		struct_ := NewObjectStruct(ir.readLength())
+		for i := range struct_.FieldList {
+			struct_.FieldList[i] = ir.readValue()
+		}
+		return ObjectValue(struct_)
+	case imageTagInterface:
+		typ := ir.readRuntimeType()
+		return ObjectValue(NewObjectInterface(typ, ir.readValue()))
+	}
+
+	ir.fail()
+	return Value{}
+}
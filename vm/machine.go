@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"unicode/utf8"
+
 	"github.com/lth-go/gogo/utils"
 )
 
@@ -15,11 +17,12 @@ type VirtualMachine struct {
 	constant []interface{} // 常量池
 	funcList []Function    // 函数引用列表
 	codeList []byte        // 字节码
+	memStats Stats         // runtime.ReadMemStats读取的快照
 }
 
 func NewVirtualMachine(
 	constant []interface{},
-	variableList []Object,
+	variableList []Value,
 	functionList []*GoGoFunction,
 	codeList []byte,
 ) *VirtualMachine {
@@ -27,7 +30,7 @@ func NewVirtualMachine(
 		stack:    NewStack(),
 		heap:     NewHeap(),
 		static:   NewStatic(),
-		constant: constant,
+		constant: append([]interface{}{}, constant...),
 		funcList: make([]Function, 0),
 		codeList: codeList,
 	}
@@ -43,6 +46,15 @@ func NewVirtualMachine(
 		vm.funcList = append(vm.funcList, f)
 	}
 
+	// 字符串常量预先创建为对象, 压栈时不需要再分配
+	for i, c := range vm.constant {
+		if value, ok := c.(string); ok {
+			vm.constant[i] = StringValue(value)
+		}
+	}
+
+	vm.addRootObjects()
+
 	return vm
 }
 
@@ -54,7 +66,12 @@ func (vm *VirtualMachine) Execute() {
 
 	codeList := vm.codeList
 
-	vm.stack.Expand(codeList)
+	maxDepth, err := MaxStackDepth(codeList)
+	if err != nil {
+		vmError(STACK_DEPTH_ERR, err)
+	}
+
+	vm.stack.Expand(maxDepth)
 	vm.execute(nil, codeList)
 }
 
@@ -66,21 +83,30 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 	constant := vm.constant
 
 	for pc < len(codeList) {
-		switch codeList[pc] {
+		// wide前缀, 下一条指令的操作数为4个字节
+		code := codeList[pc]
+		operandSize := 2
+		if code == OP_CODE_WIDE {
+			pc++
+			code = codeList[pc]
+			operandSize = 4
+		}
+
+		switch code {
 		case OP_CODE_PUSH_INT_1BYTE:
 			stack.SetIntPlus(0, int(codeList[pc+1]))
 			vm.stack.stackPointer++
 			pc += 2
 		case OP_CODE_PUSH_INT_2BYTE:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			stack.SetIntPlus(0, index)
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_INT:
-			index := utils.Get2ByteInt(codeList[pc+1:])
-			stack.SetIntPlus(0, constant[index].(int))
+			index := GetOperand(codeList[pc+1:], operandSize)
+			stack.SetInt64Plus(0, constant[index].(int64))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_FLOAT_0:
 			stack.SetFloatPlus(0, 0.0)
 			vm.stack.stackPointer++
@@ -90,39 +116,40 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer++
 			pc++
 		case OP_CODE_PUSH_FLOAT:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			stack.SetFloatPlus(0, constant[index].(float64))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_STRING:
-			index := utils.Get2ByteInt(codeList[pc+1:])
-			stack.SetStringPlus(0, constant[index].(string))
+			index := GetOperand(codeList[pc+1:], operandSize)
+			stack.SetPlus(0, constant[index].(Value))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_NIL:
-			stack.SetPlus(0, NilObject)
+			stack.SetPlus(0, NilValue)
 			vm.stack.stackPointer++
 			pc++
 		case OP_CODE_PUSH_STACK:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			stack.SetPlus(0, stack.Get(base+index))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_POP_STACK:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			stack.Set(base+index, stack.GetPlus(-1))
 			vm.stack.stackPointer--
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_STATIC:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			stack.SetPlus(0, static.Get(index))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_POP_STATIC:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
+			vm.writeBarrier(stack.GetPlus(-1))
 			static.Set(index, stack.GetPlus(-1))
 			vm.stack.stackPointer--
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_PUSH_ARRAY:
 			array := stack.GetArrayPlus(-2)
 			index := stack.GetIntPlus(-1)
@@ -137,26 +164,65 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			array := stack.GetArrayPlus(-2)
 			index := stack.GetIntPlus(-1)
 
+			vm.writeBarrier(value)
 			array.Set(index, value)
 			vm.stack.stackPointer -= 3
 			pc++
 		case OP_CODE_PUSH_MAP:
-			map_ := stack.GetMapPlus(-2)
+			// 栈上依次为: 默认值, map, key
 			index := stack.GetPlus(-1)
 
-			object := map_.Get(index)
+			map_, ok := stack.GetPlus(-2).obj.(*ObjectMap)
+			if ok {
+				object, ok := map_.Get(index)
+				if ok {
+					stack.SetPlus(-3, object)
+				}
+			}
 
-			stack.SetPlus(-2, object)
-			vm.stack.stackPointer--
+			vm.stack.stackPointer -= 2
 			pc++
 		case OP_CODE_POP_MAP:
 			value := stack.GetPlus(-3)
-			map_ := stack.GetMapPlus(-2)
 			index := stack.GetPlus(-1)
 
+			map_, ok := stack.GetPlus(-2).obj.(*ObjectMap)
+			if !ok {
+				vmError(NIL_MAP_ASSIGN_ERR)
+			}
+
+			vm.writeBarrier(index)
+			vm.writeBarrier(value)
 			map_.Set(index, value)
 			vm.stack.stackPointer -= 3
 			pc++
+		case OP_CODE_PUSH_MAP_OK:
+			index := stack.GetPlus(-1)
+			exists := false
+
+			map_, ok := stack.GetPlus(-2).obj.(*ObjectMap)
+			if ok {
+				object, ok := map_.Get(index)
+				if ok {
+					stack.SetPlus(-3, object)
+					exists = true
+				}
+			}
+
+			stack.SetIntPlus(-2, utils.BoolToInt(exists))
+			vm.stack.stackPointer--
+			pc++
+		case OP_CODE_PUSH_STRING_BYTE:
+			str := stack.GetStringPlus(-2)
+			index := stack.GetIntPlus(-1)
+
+			if index < 0 || index >= len(str) {
+				vmError(INDEX_OUT_OF_BOUNDS_ERR, index, len(str))
+			}
+
+			stack.SetInt64Plus(-2, int64(str[index]))
+			vm.stack.stackPointer--
+			pc++
 		case OP_CODE_PUSH_STRUCT:
 			struct_ := stack.GetStructPlus(-2)
 			index := stack.GetIntPlus(-1)
@@ -171,11 +237,12 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			struct_ := stack.GetStructPlus(-2)
 			index := stack.GetIntPlus(-1)
 
+			vm.writeBarrier(value)
 			struct_.SetField(index, value)
 			vm.stack.stackPointer -= 3
 			pc++
 		case OP_CODE_ADD_INT:
-			stack.SetIntPlus(-2, stack.GetIntPlus(-2)+stack.GetIntPlus(-1))
+			stack.SetInt64Plus(-2, stack.GetInt64Plus(-2)+stack.GetInt64Plus(-1))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_ADD_FLOAT:
@@ -183,11 +250,11 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_ADD_STRING:
-			stack.SetStringPlus(-2, stack.GetStringPlus(-2)+stack.GetStringPlus(-1))
+			stack.SetPlus(-2, ObjectValue(vm.NewObjectString(stack.GetStringPlus(-2)+stack.GetStringPlus(-1))))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_SUB_INT:
-			stack.SetIntPlus(-2, stack.GetIntPlus(-2)-stack.GetIntPlus(-1))
+			stack.SetInt64Plus(-2, stack.GetInt64Plus(-2)-stack.GetInt64Plus(-1))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_SUB_FLOAT:
@@ -195,7 +262,7 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_MUL_INT:
-			stack.SetIntPlus(-2, stack.GetIntPlus(-2)*stack.GetIntPlus(-1))
+			stack.SetInt64Plus(-2, stack.GetInt64Plus(-2)*stack.GetInt64Plus(-1))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_MUL_FLOAT:
@@ -203,10 +270,10 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_DIV_INT:
-			if stack.GetIntPlus(-1) == 0 {
+			if stack.GetInt64Plus(-1) == 0 {
 				vmError(DIVISION_BY_ZERO_ERR)
 			}
-			stack.SetIntPlus(-2, stack.GetIntPlus(-2)/stack.GetIntPlus(-1))
+			stack.SetInt64Plus(-2, stack.GetInt64Plus(-2)/stack.GetInt64Plus(-1))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_DIV_FLOAT:
@@ -214,13 +281,13 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_MINUS_INT:
-			stack.SetIntPlus(-1, -stack.GetIntPlus(-1))
+			stack.SetInt64Plus(-1, -stack.GetInt64Plus(-1))
 			pc++
 		case OP_CODE_MINUS_FLOAT:
 			stack.SetFloatPlus(-1, -stack.GetFloatPlus(-1))
 			pc++
 		case OP_CODE_EQ_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) == stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) == stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_EQ_FLOAT:
@@ -228,15 +295,15 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_EQ_STRING:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetStringPlus(-2) != stack.GetStringPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetStringPlus(-2) == stack.GetStringPlus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_EQ_OBJECT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetPlus(-2) == stack.GetPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(ValueEqual(stack.GetPlus(-2), stack.GetPlus(-1))))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_GT_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) > stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) > stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_GT_FLOAT:
@@ -248,7 +315,7 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_GE_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) >= stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) >= stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_GE_FLOAT:
@@ -260,7 +327,7 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_LT_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) < stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) < stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_LT_FLOAT:
@@ -272,7 +339,7 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_LE_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) <= stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) <= stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_LE_FLOAT:
@@ -284,7 +351,7 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_NE_INT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetIntPlus(-2) != stack.GetIntPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetInt64Plus(-2) != stack.GetInt64Plus(-1)))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_NE_FLOAT:
@@ -292,21 +359,13 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_NE_OBJECT:
-			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetPlus(-2) != stack.GetPlus(-1)))
+			stack.SetIntPlus(-2, utils.BoolToInt(!ValueEqual(stack.GetPlus(-2), stack.GetPlus(-1))))
 			vm.stack.stackPointer--
 			pc++
 		case OP_CODE_NE_STRING:
 			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetStringPlus(-2) != stack.GetStringPlus(-1)))
 			vm.stack.stackPointer--
 			pc++
-		case OP_CODE_LOGICAL_AND:
-			stack.SetIntPlus(-2, utils.BoolToInt(utils.IntToBool(stack.GetIntPlus(-2)) && utils.IntToBool(stack.GetIntPlus(-1))))
-			vm.stack.stackPointer--
-			pc++
-		case OP_CODE_LOGICAL_OR:
-			stack.SetIntPlus(-2, utils.BoolToInt(utils.IntToBool(stack.GetIntPlus(-2)) || utils.IntToBool(stack.GetIntPlus(-1))))
-			vm.stack.stackPointer--
-			pc++
 		case OP_CODE_LOGICAL_NOT:
 			stack.SetIntPlus(-1, utils.BoolToInt(!utils.IntToBool(stack.GetIntPlus(-1))))
 			pc++
@@ -318,79 +377,224 @@ func (vm *VirtualMachine) execute(caller *GoGoFunction, codeList []byte) {
 			vm.stack.stackPointer++
 			pc++
 		case OP_CODE_DUPLICATE_OFFSET:
-			offset := utils.Get2ByteInt(codeList[pc+1:])
+			offset := GetOperand(codeList[pc+1:], operandSize)
 			stack.Set(vm.stack.stackPointer, stack.Get(vm.stack.stackPointer-1-offset))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_JUMP:
-			index := utils.Get2ByteInt(codeList[pc+1:])
+			index := GetOperand(codeList[pc+1:], operandSize)
 			pc = index
 		case OP_CODE_JUMP_IF_TRUE:
 			if utils.IntToBool(stack.GetIntPlus(-1)) {
-				index := utils.Get2ByteInt(codeList[pc+1:])
+				index := GetOperand(codeList[pc+1:], operandSize)
 				pc = index
 			} else {
-				pc += 3
+				pc += 1 + operandSize
 			}
 			vm.stack.stackPointer--
 		case OP_CODE_JUMP_IF_FALSE:
 			if !utils.IntToBool(stack.GetIntPlus(-1)) {
-				index := utils.Get2ByteInt(codeList[pc+1:])
+				index := GetOperand(codeList[pc+1:], operandSize)
 				pc = index
 			} else {
-				pc += 3
+				pc += 1 + operandSize
 			}
 			vm.stack.stackPointer--
 		case OP_CODE_PUSH_FUNCTION:
-			value := utils.Get2ByteInt(codeList[pc+1:])
+			value := GetOperand(codeList[pc+1:], operandSize)
 			stack.SetIntPlus(0, value)
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_INVOKE:
 			funcIdx := stack.GetIntPlus(-1)
+			argCount := GetOperand(codeList[pc+1:], operandSize)
+			pc += 1 + operandSize
+			// 校验不检查栈上值的类型, 被调用的函数和实参数量在执行时检查
+			if funcIdx < 0 || funcIdx >= len(vm.funcList) {
+				vmError(INVALID_FUNCTION_ERR, funcIdx)
+			}
 			switch callee := vm.funcList[funcIdx].(type) {
 			case *GoGoNativeFunction:
+				if argCount != callee.ParamCount {
+					vmError(ARGUMENT_COUNT_ERR, callee.PackageName, callee.Name, callee.ParamCount, argCount)
+				}
 				vm.InvokeNativeFunction(callee, &vm.stack.stackPointer)
-				pc++
 			case *GoGoFunction:
+				if argCount != callee.ParamCount {
+					vmError(ARGUMENT_COUNT_ERR, callee.PackageName, callee.Name, callee.ParamCount, argCount)
+				}
 				vm.InvokeFunction(&caller, callee, &codeList, &pc, &vm.stack.stackPointer, &base)
 			default:
-				panic("TODO")
+				vmError(INVALID_FUNCTION_ERR, funcIdx)
 			}
 		case OP_CODE_RETURN:
 			vm.ReturnFunction(&caller, &codeList, &pc, &vm.stack.stackPointer, &base)
 		case OP_CODE_NEW_ARRAY:
-			size := utils.Get2ByteInt(codeList[pc+1:])
+			size := GetOperand(codeList[pc+1:], operandSize)
 			array := vm.NewObjectArray(size)
 
 			vm.stack.stackPointer -= size
-			stack.SetPlus(0, array)
+			stack.SetPlus(0, ObjectValue(array))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CDOE_NEW_MAP:
-			size := utils.Get2ByteInt(codeList[pc+1:])
+			size := GetOperand(codeList[pc+1:], operandSize)
 			objectMap := vm.NewObjectMap(size)
 
 			vm.stack.stackPointer -= size * 2
-			stack.SetPlus(0, objectMap)
+			stack.SetPlus(0, ObjectValue(objectMap))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_NEW_INTERFACE:
+			typ := constant[GetOperand(codeList[pc+1:], operandSize)].(RuntimeType)
 			data := stack.GetPlus(-1)
-			ifs := vm.NewObjectInterface(data)
+			ifs := vm.NewObjectInterface(typ, data)
 
 			vm.stack.stackPointer -= 1
-			stack.SetPlus(0, ifs)
+			stack.SetPlus(0, ObjectValue(ifs))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
 		case OP_CODE_NEW_STRUCT:
-			size := utils.Get2ByteInt(codeList[pc+1:])
+			size := GetOperand(codeList[pc+1:], operandSize)
 			struct_ := vm.NewObjectStruct(size)
 
 			vm.stack.stackPointer -= size
-			stack.SetPlus(0, struct_)
+			stack.SetPlus(0, ObjectValue(struct_))
 			vm.stack.stackPointer++
-			pc += 3
+			pc += 1 + operandSize
+		case OP_CODE_CAST_INT_TO_STRING:
+			value := stack.GetInt64Plus(-1)
+
+			// 超出unicode范围的值转为"\uFFFD"
+			r := utf8.RuneError
+			if value >= 0 && value <= utf8.MaxRune {
+				r = rune(value)
+			}
+
+			stack.SetPlus(-1, ObjectValue(vm.NewObjectString(string(r))))
+			pc++
+		case OP_CODE_CAST_INT_TO_INT8:
+			stack.SetInt64Plus(-1, int64(int8(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_INT16:
+			stack.SetInt64Plus(-1, int64(int16(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_INT32:
+			stack.SetInt64Plus(-1, int64(int32(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_UINT8:
+			stack.SetInt64Plus(-1, int64(uint8(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_UINT16:
+			stack.SetInt64Plus(-1, int64(uint16(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_UINT32:
+			stack.SetInt64Plus(-1, int64(uint32(stack.GetInt64Plus(-1))))
+			pc++
+		case OP_CODE_CAST_INT_TO_FLOAT:
+			stack.SetFloatPlus(-1, float64(stack.GetInt64Plus(-1)))
+			pc++
+		case OP_CODE_CAST_UINT_TO_FLOAT:
+			stack.SetFloatPlus(-1, float64(stack.GetUint64Plus(-1)))
+			pc++
+		case OP_CODE_CAST_FLOAT_TO_INT:
+			stack.SetInt64Plus(-1, int64(stack.GetFloatPlus(-1)))
+			pc++
+		case OP_CODE_CAST_FLOAT_TO_UINT:
+			stack.SetInt64Plus(-1, int64(uint64(stack.GetFloatPlus(-1))))
+			pc++
+		case OP_CODE_CAST_FLOAT_TO_FLOAT32:
+			stack.SetFloatPlus(-1, float64(float32(stack.GetFloatPlus(-1))))
+			pc++
+		case OP_CODE_CAST_UINT_TO_INTERFACE:
+			stack.SetPlus(-1, UintValue(stack.GetUint64Plus(-1)))
+			pc++
+		case OP_CODE_CAST_STRING_TO_BYTES:
+			str := stack.GetStringPlus(-1)
+			array := vm.NewEmptyObjectArray(len(str))
+
+			for i := 0; i < len(str); i++ {
+				array.SetInt(i, int(str[i]))
+			}
+
+			stack.SetPlus(-1, ObjectValue(array))
+			pc++
+		case OP_CODE_CAST_STRING_TO_RUNES:
+			runeList := []rune(stack.GetStringPlus(-1))
+			array := vm.NewEmptyObjectArray(len(runeList))
+
+			for i, r := range runeList {
+				array.SetInt(i, int(r))
+			}
+
+			stack.SetPlus(-1, ObjectValue(array))
+			pc++
+		case OP_CODE_CAST_BYTES_TO_STRING:
+			var byteList []byte
+
+			array, ok := stack.GetPlus(-1).obj.(*ObjectArray)
+			if ok {
+				byteList = make([]byte, array.Len())
+				for i := range byteList {
+					byteList[i] = byte(array.GetInt(i))
+				}
+			}
+
+			stack.SetPlus(-1, ObjectValue(vm.NewObjectString(string(byteList))))
+			pc++
+		case OP_CODE_CAST_RUNES_TO_STRING:
+			var runeList []rune
+
+			array, ok := stack.GetPlus(-1).obj.(*ObjectArray)
+			if ok {
+				runeList = make([]rune, array.Len())
+				for i := range runeList {
+					runeList[i] = rune(array.GetInt(i))
+				}
+			}
+
+			stack.SetPlus(-1, ObjectValue(vm.NewObjectString(string(runeList))))
+			pc++
+		case OP_CODE_NEW_ITERATOR:
+			iterator := vm.NewObjectIterator(stack.GetPlus(-1).obj)
+			stack.SetPlus(-1, ObjectValue(iterator))
+			pc++
+		case OP_CODE_ITERATE:
+			iterator := stack.GetPlus(-1).obj.(*ObjectIterator)
+
+			key, value, ok := iterator.Next()
+			if !ok {
+				vm.stack.stackPointer--
+				pc = GetOperand(codeList[pc+1:], operandSize)
+			} else {
+				stack.SetPlus(-1, key)
+				stack.SetPlus(0, value)
+				vm.stack.stackPointer++
+				pc += 1 + operandSize
+			}
+		case OP_CODE_DIV_UINT:
+			if stack.GetUint64Plus(-1) == 0 {
+				vmError(DIVISION_BY_ZERO_ERR)
+			}
+			stack.SetInt64Plus(-2, int64(stack.GetUint64Plus(-2)/stack.GetUint64Plus(-1)))
+			vm.stack.stackPointer--
+			pc++
+		case OP_CODE_GT_UINT:
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetUint64Plus(-2) > stack.GetUint64Plus(-1)))
+			vm.stack.stackPointer--
+			pc++
+		case OP_CODE_GE_UINT:
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetUint64Plus(-2) >= stack.GetUint64Plus(-1)))
+			vm.stack.stackPointer--
+			pc++
+		case OP_CODE_LT_UINT:
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetUint64Plus(-2) < stack.GetUint64Plus(-1)))
+			vm.stack.stackPointer--
+			pc++
+		case OP_CODE_LE_UINT:
+			stack.SetIntPlus(-2, utils.BoolToInt(stack.GetUint64Plus(-2) <= stack.GetUint64Plus(-1)))
+			vm.stack.stackPointer--
+			pc++
 		default:
 			panic("TODO")
 		}
@@ -424,7 +628,7 @@ func (vm *VirtualMachine) InvokeFunction(
 	bpP *int,
 ) {
 	// 拓展栈大小
-	vm.stack.Expand(callee.CodeList)
+	vm.stack.Expand(callee.MaxStackSize)
 
 	// 设置返回值信息
 	callInfo := &ObjectCallInfo{
@@ -434,7 +638,7 @@ func (vm *VirtualMachine) InvokeFunction(
 	}
 
 	// 栈上保存返回信息
-	vm.stack.Set(*spP-1, callInfo)
+	vm.stack.Set(*spP-1, ObjectValue(callInfo))
 
 	//
 	// 设置新环境
@@ -466,11 +670,10 @@ func (vm *VirtualMachine) ReturnFunction(
 
 	for i := 0; i < resultCount; i++ {
 		vm.stack.Set(*bpP-paramCount-resultCount+i, vm.stack.Get(*spP-resultCount+i))
-		*spP++
 	}
 
 	// 恢复调用栈
-	callInfo := vm.stack.Get(*bpP).(*ObjectCallInfo)
+	callInfo := vm.stack.Get(*bpP).obj.(*ObjectCallInfo)
 
 	if callInfo.caller != nil {
 		*codeListP = callInfo.caller.CodeList
@@ -480,7 +683,7 @@ func (vm *VirtualMachine) ReturnFunction(
 
 	*callerP = callInfo.caller
 	*spP = *bpP - paramCount
-	*pcP = callInfo.callerAddress + 1
+	*pcP = callInfo.callerAddress
 	*bpP = callInfo.bp
 }
 
@@ -488,6 +691,14 @@ func (vm *VirtualMachine) ReturnFunction(
 // New
 //
 
+func (vm *VirtualMachine) NewObjectString(value string) Object {
+	obj := NewObjectString(value)
+
+	vm.AddObject(obj)
+
+	return obj
+}
+
 func (vm *VirtualMachine) NewObjectArray(size int) Object {
 	obj := NewObjectArray(size)
 
@@ -517,8 +728,24 @@ func (vm *VirtualMachine) NewObjectMap(size int) Object {
 	return obj
 }
 
-func (vm *VirtualMachine) NewObjectInterface(data Object) Object {
-	obj := NewObjectInterface(data)
+func (vm *VirtualMachine) NewEmptyObjectArray(size int) *ObjectArray {
+	obj := NewObjectArray(size)
+
+	vm.AddObject(obj)
+
+	return obj
+}
+
+func (vm *VirtualMachine) NewObjectIterator(target Object) Object {
+	obj := NewObjectIterator(target)
+
+	vm.AddObject(obj)
+
+	return obj
+}
+
+func (vm *VirtualMachine) NewObjectInterface(typ RuntimeType, data Value) Object {
+	obj := NewObjectInterface(typ, data)
 
 	vm.AddObject(obj)
 
@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"unsafe"
+)
+
+//
+// ObjectMap 哈希表
+//
+// 元素按插入顺序保存在entries中, buckets使用开放寻址保存元素的下标,
+// 删除元素时只做标记, 扩容时再整理, 因此迭代过程中可以删除元素
+//
+type ObjectMap struct {
+	ObjectBase
+	entries    []mapEntry
+	buckets    []int32 // 元素下标加1, 0为空
+	count      int     // 元素数量, 不包括已删除的元素
+	generation int     // 扩容次数, 扩容后entries不再与迭代器共用
+}
+
+type mapEntry struct {
+	hash    uint64
+	key     Value
+	value   Value
+	deleted bool
+}
+
+// 最小的桶数量
+const mapMinBucketCount = 8
+
+func (obj *ObjectMap) Walk(visit func(Value)) {
+	for _, entry := range obj.entries {
+		if entry.deleted {
+			continue
+		}
+		visit(entry.key)
+		visit(entry.value)
+	}
+}
+
+func (obj *ObjectMap) Sweep() {
+	obj.entries = nil
+	obj.buckets = nil
+	obj.count = 0
+}
+
+func (obj *ObjectMap) Size() int {
+	return objectHeaderSize + cap(obj.entries)*int(unsafe.Sizeof(mapEntry{})) + cap(obj.buckets)*4
+}
+
+func (obj *ObjectMap) Len() int {
+	return obj.count
+}
+
+// 键不可比较时报错
+func (obj *ObjectMap) hash(key Value) uint64 {
+	hash, ok := valueHash(key)
+	if !ok {
+		vmError(UNHASHABLE_TYPE_ERR, unhashableTypeName(key))
+	}
+
+	return hash
+}
+
+func (obj *ObjectMap) Get(key Value) (Value, bool) {
+	index := obj.find(key, obj.hash(key))
+	if index == -1 {
+		return Value{}, false
+	}
+
+	return obj.entries[index].value, true
+}
+
+func (obj *ObjectMap) Set(key Value, value Value) {
+	hash := obj.hash(key)
+
+	index := obj.find(key, hash)
+	if index != -1 {
+		obj.entries[index].value = value
+		return
+	}
+
+	// 装载因子超过3/4时扩容, 已删除的元素也占用桶
+	if (len(obj.entries)+1)*4 > len(obj.buckets)*3 {
+		obj.grow()
+	}
+
+	obj.entries = append(obj.entries, mapEntry{hash: hash, key: key, value: value})
+	obj.insertBucket(hash, len(obj.entries))
+	obj.count++
+}
+
+func (obj *ObjectMap) Delete(key Value) {
+	index := obj.find(key, obj.hash(key))
+	if index == -1 {
+		return
+	}
+
+	// 保留桶中的下标, 查找时跳过
+	obj.entries[index] = mapEntry{hash: obj.entries[index].hash, deleted: true}
+	obj.count--
+}
+
+// 返回元素下标, 不存在时返回-1
+func (obj *ObjectMap) find(key Value, hash uint64) int {
+	if len(obj.buckets) == 0 {
+		return -1
+	}
+
+	mask := uint64(len(obj.buckets) - 1)
+
+	for i := hash & mask; obj.buckets[i] != 0; i = (i + 1) & mask {
+		index := int(obj.buckets[i] - 1)
+		entry := &obj.entries[index]
+
+		if !entry.deleted && entry.hash == hash && ValueEqual(entry.key, key) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (obj *ObjectMap) insertBucket(hash uint64, slot int) {
+	mask := uint64(len(obj.buckets) - 1)
+
+	i := hash & mask
+	for obj.buckets[i] != 0 {
+		i = (i + 1) & mask
+	}
+
+	obj.buckets[i] = int32(slot)
+}
+
+// 按元素数量重新分配, 去掉已删除的元素
+// entries总是重新分配, 正在迭代的迭代器仍然使用原来的元素
+func (obj *ObjectMap) grow() {
+	bucketCount := mapMinBucketCount
+	for (obj.count+1)*2 > bucketCount {
+		bucketCount *= 2
+	}
+
+	entries := make([]mapEntry, 0, bucketCount*3/4)
+	for _, entry := range obj.entries {
+		if !entry.deleted {
+			entries = append(entries, entry)
+		}
+	}
+
+	obj.entries = entries
+	obj.buckets = make([]int32, bucketCount)
+	obj.generation++
+
+	for i, entry := range obj.entries {
+		obj.insertBucket(entry.hash, i+1)
+	}
+}
+
+func NewObjectMap() *ObjectMap {
+	return &ObjectMap{}
+}
@@ -1,18 +1,27 @@
 package vm
 
 import (
-	"github.com/lth-go/gogo/utils"
+	"unicode/utf8"
+	"unsafe"
 )
 
 // 虚拟机对象接口
 type Object interface {
-	IsMarked() bool // 是否设置标记位
-	Mark()          // 设置标记位
-	ResetMark()     // 重置标记位
-	Sweep()         // 垃圾回收
-	Len() int       // 计算堆阈值
-	Hash() int      // 哈希
-}
+	IsMarked() bool   // 是否设置标记位
+	Mark()            // 设置标记位
+	ResetMark()       // 重置标记位
+	Walk(func(Value)) // 遍历引用的值, 用于标记
+	Sweep()           // 回收时释放引用的值
+	Size() int        // 占用的字节数, 用于计算gc阈值
+	Len() int         // 元素数量
+	Hash() int        // 哈希
+}
+
+// 估算对象大小时使用
+const (
+	objectHeaderSize = int(unsafe.Sizeof(ObjectBase{})) + 16
+	valueSize        = int(unsafe.Sizeof(Value{}))
+)
 
 //
 // ObjectBase
@@ -26,9 +35,6 @@ func (obj *ObjectBase) IsMarked() bool {
 }
 
 func (obj *ObjectBase) Mark() {
-	if obj == nil {
-		return
-	}
 	obj.marked = true
 }
 
@@ -36,8 +42,14 @@ func (obj *ObjectBase) ResetMark() {
 	obj.marked = false
 }
 
+func (obj *ObjectBase) Walk(visit func(Value)) {
+}
+
 func (obj *ObjectBase) Sweep() {
-	// TODO:
+}
+
+func (obj *ObjectBase) Size() int {
+	return objectHeaderSize
 }
 
 func (obj *ObjectBase) Len() int {
@@ -48,34 +60,6 @@ func (obj *ObjectBase) Hash() int {
 	return 0
 }
 
-//
-// ObjectInt
-//
-type ObjectInt struct {
-	ObjectBase
-	Value int
-}
-
-func NewObjectInt(value int) *ObjectInt {
-	return &ObjectInt{
-		Value: value,
-	}
-}
-
-//
-// ObjectFloat
-//
-type ObjectFloat struct {
-	ObjectBase
-	Value float64
-}
-
-func NewObjectFloat(value float64) *ObjectFloat {
-	return &ObjectFloat{
-		Value: value,
-	}
-}
-
 //
 // ObjectString
 //
@@ -84,6 +68,10 @@ type ObjectString struct {
 	Value string
 }
 
+func (obj *ObjectString) Size() int {
+	return objectHeaderSize + len(obj.Value)
+}
+
 func NewObjectString(value string) *ObjectString {
 	return &ObjectString{
 		Value: value,
@@ -104,69 +92,52 @@ var NilObject = &ObjectNil{}
 //
 type ObjectArray struct {
 	ObjectBase
-	List []Object
+	List []Value
 }
 
-func (obj *ObjectArray) Mark() {
-	if obj == nil {
-		return
-	}
-
-	obj.ObjectBase.Mark()
-
-	for _, subObj := range obj.List {
-		if subObj == nil {
-			continue
-		}
-		subObj.Mark()
+func (obj *ObjectArray) Walk(visit func(Value)) {
+	for _, value := range obj.List {
+		visit(value)
 	}
 }
 
-func (obj *ObjectArray) ResetMark() {
-	obj.ObjectBase.ResetMark()
-
-	for _, subObj := range obj.List {
-		if subObj == nil {
-			continue
-		}
-		subObj.ResetMark()
-	}
+// 回收后再访问时报nil错误
+func (obj *ObjectArray) Sweep() {
+	obj.List = nil
 }
 
-func (obj *ObjectArray) Sweep() {
-	for _, subObj := range obj.List {
-		subObj.Sweep()
-	}
+func (obj *ObjectArray) Size() int {
+	return objectHeaderSize + cap(obj.List)*valueSize
 }
 
 func (obj *ObjectArray) Len() int {
 	return len(obj.List)
 }
 
-func (obj *ObjectArray) Set(index int, value Object) {
+func (obj *ObjectArray) Set(index int, value Value) {
 	obj.Check(index)
 	obj.List[index] = value
 }
 
 func (obj *ObjectArray) SetInt(index int, value int) {
-	obj.Set(index, NewObjectInt(value))
+	obj.Set(index, IntValue(int64(value)))
 }
 
 func (obj *ObjectArray) SetFloat(index int, value float64) {
-	obj.Set(index, NewObjectFloat(value))
+	obj.Set(index, FloatValue(value))
 }
 
-func (obj *ObjectArray) Get(index int) Object {
+func (obj *ObjectArray) Get(index int) Value {
 	obj.Check(index)
 	return obj.List[index]
 }
 
 func (obj *ObjectArray) GetInt(index int) int {
-	return obj.Get(index).(*ObjectInt).Value
+	return int(obj.Get(index).Int())
 }
 
 func (obj *ObjectArray) GetFloat(index int) float64 {
-	return obj.Get(index).(*ObjectFloat).Value
+	return obj.Get(index).Float()
 }
 
 func (obj *ObjectArray) Check(index int) {
@@ -176,61 +147,49 @@ func (obj *ObjectArray) Check(index int) {
 	}
 
 	length := obj.Len()
-	if length < 0 || index >= length {
+	if index < 0 || index >= length {
 		vmError(INDEX_OUT_OF_BOUNDS_ERR, index, length)
 	}
 }
 
 func NewObjectArray(size int) *ObjectArray {
 	return &ObjectArray{
-		List: make([]Object, size),
+		List: make([]Value, size),
 	}
 }
 
 //
-// ObjectMap
+// RuntimeType interface的动态类型
 //
-type ObjectMap struct {
-	ObjectBase
-	// TODO: 临时简单处理, 键为对象hash,值为key,
-	Map map[string][2]Object
+type RuntimeType struct {
+	Name       string // 类型名, 自定义类型带上包名, eg: main.MyInt
+	Comparable bool   // 是否可以比较, 切片, map, 函数不可比较
 }
 
-func (obj *ObjectMap) Get(key Object) Object {
-	hash := utils.Hash(key)
-	v, ok := obj.Map[hash]
-	if !ok {
-		return nil
-	}
-
-	return v[1]
-}
-
-func (obj *ObjectMap) Set(key Object, value Object) {
-	obj.Map[utils.Hash(key)] = [2]Object{key, value}
+//
+// ObjectInterface 非nil的interface, 保存动态类型和值
+//
+type ObjectInterface struct {
+	ObjectBase
+	Type RuntimeType
+	Data Value
 }
 
-func (obj *ObjectMap) Delete(key Object) {
-	hash := utils.Hash(key)
-	delete(obj.Map, hash)
+func (obj *ObjectInterface) Walk(visit func(Value)) {
+	visit(obj.Data)
 }
 
-func NewObjectMap() *ObjectMap {
-	return &ObjectMap{
-		Map: make(map[string][2]Object),
-	}
+func (obj *ObjectInterface) Sweep() {
+	obj.Data = NilValue
 }
 
-//
-// ObjectInterface
-//
-type ObjectInterface struct {
-	ObjectBase
-	Data Object
+func (obj *ObjectInterface) Size() int {
+	return objectHeaderSize + valueSize
 }
 
-func NewObjectInterface(data Object) *ObjectInterface {
+func NewObjectInterface(typ RuntimeType, data Value) *ObjectInterface {
 	return &ObjectInterface{
+		Type: typ,
 		Data: data,
 	}
 }
@@ -240,20 +199,34 @@ func NewObjectInterface(data Object) *ObjectInterface {
 //
 type ObjectStruct struct {
 	ObjectBase
-	FieldList []Object
+	FieldList []Value
+}
+
+func (obj *ObjectStruct) Walk(visit func(Value)) {
+	for _, value := range obj.FieldList {
+		visit(value)
+	}
 }
 
-func (obj *ObjectStruct) GetField(i int) Object {
+func (obj *ObjectStruct) Sweep() {
+	obj.FieldList = nil
+}
+
+func (obj *ObjectStruct) Size() int {
+	return objectHeaderSize + cap(obj.FieldList)*valueSize
+}
+
+func (obj *ObjectStruct) GetField(i int) Value {
 	return obj.FieldList[i]
 }
 
-func (obj *ObjectStruct) SetField(i int, value Object) {
+func (obj *ObjectStruct) SetField(i int, value Value) {
 	obj.FieldList[i] = value
 }
 
 func NewObjectStruct(size int) *ObjectStruct {
 	return &ObjectStruct{
-		FieldList: make([]Object, size),
+		FieldList: make([]Value, size),
 	}
 }
 
@@ -264,12 +237,106 @@ type ObjectPointer struct {
 	ObjectBase
 }
 
+//
+// ObjectIterator for range 迭代器
+//
+type ObjectIterator struct {
+	ObjectBase
+	target     Object
+	entries    []mapEntry // 开始迭代时map的元素, 迭代过程中新增的元素不会被遍历
+	generation int
+	index      int
+}
+
+// map扩容后entries不再与map共用, 需要单独标记
+func (obj *ObjectIterator) Walk(visit func(Value)) {
+	visit(ObjectValue(obj.target))
+
+	for _, entry := range obj.entries {
+		if !entry.deleted {
+			visit(entry.key)
+			visit(entry.value)
+		}
+	}
+}
+
+func (obj *ObjectIterator) Sweep() {
+	obj.target = NilObject
+	obj.entries = nil
+}
+
+// Next 返回下一组key, value, 迭代结束时ok为false
+func (obj *ObjectIterator) Next() (key Value, value Value, ok bool) {
+	switch target := obj.target.(type) {
+	case *ObjectString:
+		if obj.index >= len(target.Value) {
+			return Value{}, Value{}, false
+		}
+
+		r, size := utf8.DecodeRuneInString(target.Value[obj.index:])
+		key = IntValue(int64(obj.index))
+		value = IntValue(int64(r))
+		obj.index += size
+
+		return key, value, true
+	case *ObjectArray:
+		if obj.index >= len(target.List) {
+			return Value{}, Value{}, false
+		}
+
+		key = IntValue(int64(obj.index))
+		value = target.List[obj.index]
+		obj.index++
+
+		return key, value, true
+	case *ObjectMap:
+		for obj.index < len(obj.entries) {
+			entry := &obj.entries[obj.index]
+			obj.index++
+
+			// 跳过迭代过程中被删除的元素
+			if entry.deleted {
+				continue
+			}
+
+			// 没有扩容时与map共用元素, 扩容后需要重新查找
+			if obj.generation == target.generation {
+				return entry.key, entry.value, true
+			}
+
+			value, ok = target.Get(entry.key)
+			if ok {
+				return entry.key, value, true
+			}
+		}
+
+		return Value{}, Value{}, false
+	}
+
+	// nil
+	return Value{}, Value{}, false
+}
+
+func NewObjectIterator(target Object) *ObjectIterator {
+	obj := &ObjectIterator{
+		target: target,
+	}
+
+	map_, ok := target.(*ObjectMap)
+	if ok {
+		obj.entries = map_.entries
+		obj.generation = map_.generation
+	}
+
+	return obj
+}
+
 //
 // ObjectCallInfo 函数返回体 TODO: 临时定义为对象
 //
 type ObjectCallInfo struct {
 	ObjectBase                  // TODO: 兼容
 	caller        *GoGoFunction // 调用的函数
-	callerAddress int           // 保存执行函数前的pc
+	callerAddress int           // 函数返回后继续执行的pc
 	bp            int           // 栈基
 }
@@ -1,5 +1,10 @@
 package vm
 
+import (
+	"encoding/binary"
+	"math"
+)
+
 // 字节码
 const (
 	OP_CODE_PUSH_INT_1BYTE byte = iota
@@ -21,10 +26,12 @@ const (
 	OP_CODE_POP_ARRAY
 	OP_CODE_PUSH_MAP
 	OP_CODE_POP_MAP
+	OP_CODE_PUSH_MAP_OK
 	OP_CODE_PUSH_STRUCT
 	OP_CODE_POP_STRUCT
 	OP_CODE_PUSH_INTERFACE
 	OP_CODE_POP_INTERFACE
+	OP_CODE_PUSH_STRING_BYTE
 
 	OP_CODE_ADD_INT
 	OP_CODE_ADD_FLOAT
@@ -59,8 +66,6 @@ const (
 	OP_CODE_NE_FLOAT
 	OP_CODE_NE_STRING
 	OP_CODE_NE_OBJECT
-	OP_CODE_LOGICAL_AND
-	OP_CODE_LOGICAL_OR
 	OP_CODE_LOGICAL_NOT
 	OP_CODE_POP
 	OP_CODE_DUPLICATE
@@ -77,6 +82,37 @@ const (
 	OP_CDOE_NEW_MAP
 	OP_CODE_NEW_INTERFACE
 	OP_CODE_NEW_STRUCT
+
+	OP_CODE_CAST_INT_TO_STRING
+	OP_CODE_CAST_INT_TO_INT8
+	OP_CODE_CAST_INT_TO_INT16
+	OP_CODE_CAST_INT_TO_INT32
+	OP_CODE_CAST_INT_TO_UINT8
+	OP_CODE_CAST_INT_TO_UINT16
+	OP_CODE_CAST_INT_TO_UINT32
+	OP_CODE_CAST_INT_TO_FLOAT
+	OP_CODE_CAST_UINT_TO_FLOAT
+	OP_CODE_CAST_FLOAT_TO_INT
+	OP_CODE_CAST_FLOAT_TO_UINT
+	OP_CODE_CAST_FLOAT_TO_FLOAT32
+	OP_CODE_CAST_UINT_TO_INTERFACE
+	OP_CODE_CAST_STRING_TO_BYTES
+	OP_CODE_CAST_STRING_TO_RUNES
+	OP_CODE_CAST_BYTES_TO_STRING
+	OP_CODE_CAST_RUNES_TO_STRING
+
+	OP_CODE_NEW_ITERATOR
+	OP_CODE_ITERATE
+
+	// 无符号整数, 除法和比较与有符号不同
+	OP_CODE_DIV_UINT
+	OP_CODE_GT_UINT
+	OP_CODE_GE_UINT
+	OP_CODE_LT_UINT
+	OP_CODE_LE_UINT
+
+	// 前缀, 下一条指令的s, p, l操作数为4个字节
+	OP_CODE_WIDE
 )
 
 type opcodeInfo struct {
@@ -86,7 +122,10 @@ type opcodeInfo struct {
 	// `b` 一个字节整数
 	// `s` 两个字节整数
 	// `p` 常量池索引值
-	Parameter      string
+	// `l` 跳转地址
+	// 带wide前缀时s, p, l为四个字节整数
+	Parameter string
+	// 对操作数栈深度的影响, 与操作数相关的指令见StackEffect
 	stackIncrement int
 }
 
@@ -106,15 +145,18 @@ var OpcodeInfo map[byte]opcodeInfo = map[byte]opcodeInfo{
 	OP_CODE_PUSH_STATIC: {"push_static", "s", 1},
 	OP_CODE_POP_STATIC:  {"pop_static", "s", -1},
 
-	OP_CODE_PUSH_ARRAY:     {"push_array", "", 1},
-	OP_CODE_POP_ARRAY:      {"pop_array", "", -1},
-	OP_CODE_PUSH_MAP:       {"push_map", "", 1},
-	OP_CODE_POP_MAP:        {"pop_map", "", -1},
-	OP_CODE_PUSH_STRUCT:    {"push_struct", "", 1},
-	OP_CODE_POP_STRUCT:     {"pop_struct", "", -1},
+	OP_CODE_PUSH_ARRAY:     {"push_array", "", -1},
+	OP_CODE_POP_ARRAY:      {"pop_array", "", -3},
+	OP_CODE_PUSH_MAP:       {"push_map", "", -2},
+	OP_CODE_POP_MAP:        {"pop_map", "", -3},
+	OP_CODE_PUSH_MAP_OK:    {"push_map_ok", "", -1},
+	OP_CODE_PUSH_STRUCT:    {"push_struct", "", -1},
+	OP_CODE_POP_STRUCT:     {"pop_struct", "", -3},
 	OP_CODE_PUSH_INTERFACE: {"push_interface", "", 1},
 	OP_CODE_POP_INTERFACE:  {"pop_interface", "", -1},
 
+	OP_CODE_PUSH_STRING_BYTE: {"push_string_byte", "", -1},
+
 	OP_CODE_ADD_INT:          {"add_int", "", -1},
 	OP_CODE_ADD_FLOAT:        {"add_float", "", -1},
 	OP_CODE_ADD_STRING:       {"add_string", "", -1},
@@ -148,24 +190,148 @@ var OpcodeInfo map[byte]opcodeInfo = map[byte]opcodeInfo{
 	OP_CODE_NE_FLOAT:         {"ne_float", "", -1},
 	OP_CODE_NE_STRING:        {"ne_string", "", -1},
 	OP_CODE_NE_OBJECT:        {"ne_object", "", -1},
-	OP_CODE_LOGICAL_AND:      {"logical_and", "", -1},
-	OP_CODE_LOGICAL_OR:       {"logical_or", "", -1},
 	OP_CODE_LOGICAL_NOT:      {"logical_not", "", 0},
 	OP_CODE_POP:              {"pop", "", -1},
 	OP_CODE_DUPLICATE:        {"duplicate", "", 1},
 	OP_CODE_DUPLICATE_OFFSET: {"duplicate_offset", "s", 1},
-	OP_CODE_JUMP:             {"jump", "s", 0},
-	OP_CODE_JUMP_IF_TRUE:     {"jump_if_true", "s", -1},
-	OP_CODE_JUMP_IF_FALSE:    {"jump_if_false", "s", -1},
+	OP_CODE_JUMP:             {"jump", "l", 0},
+	OP_CODE_JUMP_IF_TRUE:     {"jump_if_true", "l", -1},
+	OP_CODE_JUMP_IF_FALSE:    {"jump_if_false", "l", -1},
 
+	// invoke的操作数为实参数量, 弹出实参和函数, 返回值写入调用前预留的位置
 	OP_CODE_PUSH_FUNCTION: {"push_function", "s", 1},
-	OP_CODE_INVOKE:        {"invoke", "", -1},
-	OP_CODE_RETURN:        {"return", "", -1},
+	OP_CODE_INVOKE:        {"invoke", "s", -1},
+	OP_CODE_RETURN:        {"return", "", 0},
 
 	OP_CODE_NEW_ARRAY:     {"new_array", "s", 1},
 	OP_CDOE_NEW_MAP:       {"new_map", "s", 1},
-	OP_CODE_NEW_INTERFACE: {"new_interface", "s", 1},
+	OP_CODE_NEW_INTERFACE: {"new_interface", "p", 0},
 	OP_CODE_NEW_STRUCT:    {"new_struct", "s", 1},
+
+	OP_CODE_CAST_INT_TO_STRING:     {"cast_int_to_string", "", 0},
+	OP_CODE_CAST_INT_TO_INT8:       {"cast_int_to_int8", "", 0},
+	OP_CODE_CAST_INT_TO_INT16:      {"cast_int_to_int16", "", 0},
+	OP_CODE_CAST_INT_TO_INT32:      {"cast_int_to_int32", "", 0},
+	OP_CODE_CAST_INT_TO_UINT8:      {"cast_int_to_uint8", "", 0},
+	OP_CODE_CAST_INT_TO_UINT16:     {"cast_int_to_uint16", "", 0},
+	OP_CODE_CAST_INT_TO_UINT32:     {"cast_int_to_uint32", "", 0},
+	OP_CODE_CAST_INT_TO_FLOAT:      {"cast_int_to_float", "", 0},
+	OP_CODE_CAST_UINT_TO_FLOAT:     {"cast_uint_to_float", "", 0},
+	OP_CODE_CAST_FLOAT_TO_INT:      {"cast_float_to_int", "", 0},
+	OP_CODE_CAST_FLOAT_TO_UINT:     {"cast_float_to_uint", "", 0},
+	OP_CODE_CAST_FLOAT_TO_FLOAT32:  {"cast_float_to_float32", "", 0},
+	OP_CODE_CAST_UINT_TO_INTERFACE: {"cast_uint_to_interface", "", 0},
+	OP_CODE_CAST_STRING_TO_BYTES:   {"cast_string_to_bytes", "", 0},
+	OP_CODE_CAST_STRING_TO_RUNES:   {"cast_string_to_runes", "", 0},
+	OP_CODE_CAST_BYTES_TO_STRING:   {"cast_bytes_to_string", "", 0},
+	OP_CODE_CAST_RUNES_TO_STRING:   {"cast_runes_to_string", "", 0},
+
+	// 迭代结束时跳转, 否则弹出迭代器, 压入key, value
+	OP_CODE_NEW_ITERATOR: {"new_iterator", "", 0},
+	OP_CODE_ITERATE:      {"iterate", "l", 1},
+
+	OP_CODE_DIV_UINT: {"div_uint", "", -1},
+	OP_CODE_GT_UINT:  {"gt_uint", "", -1},
+	OP_CODE_GE_UINT:  {"ge_uint", "", -1},
+	OP_CODE_LT_UINT:  {"lt_uint", "", -1},
+	OP_CODE_LE_UINT:  {"le_uint", "", -1},
+
+	OP_CODE_WIDE: {"wide", "", 0},
+}
+
+// 操作数的取值范围, 超出short范围时使用wide前缀
+const (
+	MaxShortOperand = math.MaxInt16
+	MinShortOperand = math.MinInt16
+	MaxWideOperand  = math.MaxInt32
+	MinWideOperand  = math.MinInt32
+)
+
+// 操作数占用的字节数
+// 参数类型只有OpcodeInfo中的b, s, p, l, 其它值是调用方的错误, 直接panic
+func OperandSize(param byte, wide bool) int {
+	switch param {
+	case 'b':
+		return 1
+	case 's', 'p', 'l':
+		if wide {
+			return 4
+		}
+		return 2
+	}
+
+	panic("invalid operand type " + string(param))
+}
+
+func GetOperand(b []byte, size int) int {
+	switch size {
+	case 1:
+		return int(b[0])
+	case 2:
+		return int(int16(binary.BigEndian.Uint16(b)))
+	}
+
+	return int(int32(binary.BigEndian.Uint32(b)))
+}
+
+func SetOperand(b []byte, size int, value int) {
+	switch size {
+	case 1:
+		b[0] = byte(value)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(value))
+	default:
+		binary.BigEndian.PutUint32(b, uint32(value))
+	}
+}
+
+//
+// Instruction 解码后的指令
+//
+type Instruction struct {
+	Code     byte
+	Wide     bool
+	Operands []int
+	Size     int // 指令的字节数, 包括wide前缀
+}
+
+// DecodeInstruction 解码pc处的指令
+func DecodeInstruction(codeList []byte, pc int) Instruction {
+	inst := Instruction{}
+
+	start := pc
+	if codeList[pc] == OP_CODE_WIDE {
+		inst.Wide = true
+		pc++
+	}
+
+	inst.Code = codeList[pc]
+	pc++
+
+	for _, p := range []byte(OpcodeInfo[inst.Code].Parameter) {
+		size := OperandSize(p, inst.Wide)
+		inst.Operands = append(inst.Operands, GetOperand(codeList[pc:], size))
+		pc += size
+	}
+
+	inst.Size = pc - start
+
+	return inst
+}
+
+// StackEffect 指令执行后操作数栈深度的变化
+// iterate迭代结束跳转时弹出迭代器, 栈深度变化为-1
+func StackEffect(inst Instruction) int {
+	switch inst.Code {
+	case OP_CODE_INVOKE:
+		return -inst.Operands[0] - 1
+	case OP_CODE_NEW_ARRAY, OP_CODE_NEW_STRUCT:
+		return 1 - inst.Operands[0]
+	case OP_CDOE_NEW_MAP:
+		return 1 - inst.Operands[0]*2
+	}
+
+	return OpcodeInfo[inst.Code].stackIncrement
 }
 
 //
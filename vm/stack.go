@@ -6,56 +6,130 @@ const (
 
 // 虚拟机栈
 type Stack struct {
-	list         []Object // 对象栈
-	stackPointer int      // 栈偏移量, 指向当前最大空栈
+	list         []Value // 值栈
+	stackPointer int     // 栈偏移量, 指向当前最大空栈
+	maxDepth     int     // 栈的最大深度, 用于统计
 }
 
 func NewStack() *Stack {
 	s := &Stack{
-		list:         make([]Object, stackAllocSize, (stackAllocSize+1)*2),
+		list:         make([]Value, stackAllocSize, (stackAllocSize+1)*2),
 		stackPointer: 0,
 	}
 	return s
 }
 
 //
-// 栈伸缩
+// 栈伸缩, size为接下来需要的栈空间
 //
-func (s *Stack) Expand(codeList []byte) {
-	needStackSize := getNeedStackSize(codeList)
+func (s *Stack) Expand(size int) {
+	if s.stackPointer+size > s.maxDepth {
+		s.maxDepth = s.stackPointer + size
+	}
 
 	rest := s.Len() - s.stackPointer
 
-	if rest <= needStackSize {
-		size := s.Len() + needStackSize - rest
+	if rest <= size {
+		newSize := s.Len() + size - rest
+
+		// 预留了容量时直接扩展长度, 避免深度递归时反复复制
+		if newSize <= cap(s.list) {
+			s.list = s.list[:newSize]
+			return
+		}
 
-		newObjectList := make([]Object, size, (size+1)*2)
-		copy(newObjectList, s.list)
-		s.list = newObjectList
+		newValueList := make([]Value, newSize, (newSize+1)*2)
+		copy(newValueList, s.list)
+		s.list = newValueList
 	}
 }
 
-func getNeedStackSize(codeList []byte) int {
-	stackSize := 0
+//
+// MaxStackDepth 沿控制流计算字节码执行时操作数栈的最大深度
+//
+// 同一条指令从不同路径到达时栈深度必须相同
+//
+func MaxStackDepth(codeList []byte) (int, error) {
+	return walkStackDepth(codeList, nil)
+}
+
+// 沿控制流遍历可以到达的指令, visit不为nil时以指令执行前的栈深度调用
+func walkStackDepth(codeList []byte, visit func(pc int, inst Instruction, depth int) error) (int, error) {
+	// 每个字节码地址处执行前的栈深度, -1表示未到达
+	depthList := make([]int, len(codeList))
+	for i := range depthList {
+		depthList[i] = -1
+	}
+
+	maxDepth := 0
+	workList := []int{}
+
+	setDepth := func(pc int, depth int) error {
+		if pc < 0 || pc > len(codeList) {
+			return newVmError(JUMP_OUT_OF_RANGE_ERR, pc)
+		}
+		if depth < 0 {
+			return newVmError(STACK_UNDERFLOW_ERR, pc)
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		if pc == len(codeList) {
+			// 顶层代码执行到结尾
+			return nil
+		}
+
+		if depthList[pc] == -1 {
+			depthList[pc] = depth
+			workList = append(workList, pc)
+		} else if depthList[pc] != depth {
+			return newVmError(STACK_DEPTH_MISMATCH_ERR, pc, depthList[pc], depth)
+		}
+		return nil
+	}
+
+	if err := setDepth(0, 0); err != nil {
+		return 0, err
+	}
+
+	for len(workList) > 0 {
+		pc := workList[len(workList)-1]
+		workList = workList[:len(workList)-1]
+
+		inst := DecodeInstruction(codeList, pc)
+		depth := depthList[pc]
+		next := depth + StackEffect(inst)
 
-	for i := 0; i < len(codeList); i++ {
-		info := OpcodeInfo[codeList[i]]
-		if info.stackIncrement > 0 {
-			stackSize += info.stackIncrement
+		if visit != nil {
+			if err := visit(pc, inst, depth); err != nil {
+				return 0, err
+			}
 		}
-		for _, p := range []byte(info.Parameter) {
-			switch p {
-			case 'b':
-				i++
-			case 's', 'p':
-				i += 2
-			default:
-				panic("TODO")
+
+		var err error
+		switch inst.Code {
+		case OP_CODE_RETURN:
+		case OP_CODE_JUMP:
+			err = setDepth(inst.Operands[0], depth)
+		case OP_CODE_JUMP_IF_TRUE, OP_CODE_JUMP_IF_FALSE:
+			if err = setDepth(inst.Operands[0], next); err == nil {
+				err = setDepth(pc+inst.Size, next)
+			}
+		case OP_CODE_ITERATE:
+			// 迭代结束时只弹出迭代器
+			if err = setDepth(inst.Operands[0], depth-1); err == nil {
+				err = setDepth(pc+inst.Size, next)
 			}
+		default:
+			err = setDepth(pc+inst.Size, next)
+		}
+
+		if err != nil {
+			return 0, err
 		}
 	}
 
-	return stackSize
+	return maxDepth, nil
 }
 
 func (s *Stack) Len() int {
@@ -72,35 +146,39 @@ func (s *Stack) getIndex(incr int) int {
 }
 
 // 直据sp返回栈中元素
-func (s *Stack) Get(sp int) Object {
+func (s *Stack) Get(sp int) Value {
 	return s.list[sp]
 }
 
 // 根据incr以及stackPointer向栈中写入元素
-func (s *Stack) GetPlus(incr int) Object {
+func (s *Stack) GetPlus(incr int) Value {
 	index := s.getIndex(incr)
 	return s.Get(index)
 }
 
-func (s *Stack) Set(sp int, v Object) {
+func (s *Stack) Set(sp int, v Value) {
 	s.list[sp] = v
 }
 
-func (s *Stack) SetPlus(incr int, value Object) {
+func (s *Stack) SetPlus(incr int, value Value) {
 	index := s.getIndex(incr)
 	s.Set(index, value)
 }
 
 func (s *Stack) GetInt(sp int) int {
-	return s.Get(sp).(*ObjectInt).Value
+	return int(s.GetInt64(sp))
+}
+
+func (s *Stack) GetInt64(sp int) int64 {
+	return s.list[sp].Int()
 }
 
 func (s *Stack) GetFloat(sp int) float64 {
-	return s.Get(sp).(*ObjectFloat).Value
+	return s.list[sp].Float()
 }
 
 func (s *Stack) GetString(sp int) string {
-	return s.Get(sp).(*ObjectString).Value
+	return s.list[sp].String()
 }
 
 func (s *Stack) GetIntPlus(incr int) int {
@@ -108,6 +186,16 @@ func (s *Stack) GetIntPlus(incr int) int {
 	return s.GetInt(index)
 }
 
+func (s *Stack) GetInt64Plus(incr int) int64 {
+	index := s.getIndex(incr)
+	return s.GetInt64(index)
+}
+
+// 无符号整数与有符号整数使用相同的位模式保存
+func (s *Stack) GetUint64Plus(incr int) uint64 {
+	return uint64(s.GetInt64Plus(incr))
+}
+
 func (s *Stack) GetFloatPlus(incr int) float64 {
 	index := s.getIndex(incr)
 	return s.GetFloat(index)
@@ -120,29 +208,29 @@ func (s *Stack) GetStringPlus(incr int) string {
 
 func (s *Stack) GetArrayPlus(incr int) *ObjectArray {
 	index := s.getIndex(incr)
-	return s.Get(index).(*ObjectArray)
+	return s.Get(index).obj.(*ObjectArray)
 }
 
 func (s *Stack) GetMapPlus(incr int) *ObjectMap {
 	index := s.getIndex(incr)
-	return s.Get(index).(*ObjectMap)
+	return s.Get(index).obj.(*ObjectMap)
 }
 
 func (s *Stack) GetStructPlus(incr int) *ObjectStruct {
 	index := s.getIndex(incr)
-	return s.Get(index).(*ObjectStruct)
+	return s.Get(index).obj.(*ObjectStruct)
 }
 
 func (s *Stack) SetInt(sp int, value int) {
-	s.Set(sp, NewObjectInt(value))
+	s.SetInt64(sp, int64(value))
 }
 
-func (s *Stack) SetFloat(sp int, value float64) {
-	s.Set(sp, NewObjectFloat(value))
+func (s *Stack) SetInt64(sp int, value int64) {
+	s.list[sp] = IntValue(value)
 }
 
-func (s *Stack) SetString(sp int, value string) {
-	s.Set(sp, NewObjectString(value))
+func (s *Stack) SetFloat(sp int, value float64) {
+	s.list[sp] = FloatValue(value)
 }
 
 func (s *Stack) SetIntPlus(incr int, value int) {
@@ -150,12 +238,12 @@ func (s *Stack) SetIntPlus(incr int, value int) {
 	s.SetInt(index, value)
 }
 
-func (s *Stack) SetFloatPlus(incr int, value float64) {
+func (s *Stack) SetInt64Plus(incr int, value int64) {
 	index := s.getIndex(incr)
-	s.SetFloat(index, value)
+	s.SetInt64(index, value)
 }
 
-func (s *Stack) SetStringPlus(incr int, value string) {
+func (s *Stack) SetFloatPlus(incr int, value float64) {
 	index := s.getIndex(incr)
-	s.SetString(index, value)
+	s.SetFloat(index, value)
 }
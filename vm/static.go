@@ -4,23 +4,23 @@ package vm
 // Static
 //
 type Static struct {
-	list []Object
+	list []Value
 }
 
 func NewStatic() *Static {
 	return &Static{
-		list: make([]Object, 0),
+		list: make([]Value, 0),
 	}
 }
 
-func (s *Static) Append(obj Object) {
-	s.list = append(s.list, obj)
+func (s *Static) Append(value Value) {
+	s.list = append(s.list, value)
 }
 
-func (s *Static) Get(index int) Object {
+func (s *Static) Get(index int) Value {
 	return s.list[index]
 }
 
-func (s *Static) Set(index int, obj Object) {
-	s.list[index] = obj
+func (s *Static) Set(index int, value Value) {
+	s.list[index] = value
 }
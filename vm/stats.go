@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"time"
+)
+
+//
+// Stats 虚拟机的内存统计信息
+//
+type Stats struct {
+	HeapObjects   int            // 堆上的对象数量
+	HeapAlloc     int            // 上一轮gc结束时存活对象占用的字节数, 不包括之后分配的对象
+	ObjectCounts  map[string]int // 按类型统计的对象数量
+	NumGC         int            // 完成的gc轮数
+	PauseTotal    time.Duration  // gc暂停的总时间, 增量gc的每一步都计入
+	MaxStackDepth int            // 栈的最大深度, 按每次调用需要的栈空间计算
+}
+
+// Stats 返回当前的统计信息
+func (vm *VirtualMachine) Stats() Stats {
+	heap := vm.heap
+
+	stats := Stats{
+		HeapAlloc:     heap.liveBytes,
+		ObjectCounts:  map[string]int{},
+		NumGC:         heap.numGC,
+		PauseTotal:    heap.pauseTotal,
+		MaxStackDepth: vm.stack.maxDepth,
+	}
+
+	heap.each(func(obj Object) {
+		stats.HeapObjects++
+		stats.ObjectCounts[objectTypeName(obj)]++
+	})
+
+	return stats
+}
+
+func objectTypeName(obj Object) string {
+	switch obj.(type) {
+	case *ObjectString:
+		return "string"
+	case *ObjectArray:
+		return "array"
+	case *ObjectMap:
+		return "map"
+	case *ObjectStruct:
+		return "struct"
+	case *ObjectInterface:
+		return "interface"
+	case *ObjectIterator:
+		return "iterator"
+	}
+
+	return "other"
+}
@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"math"
+)
+
+type ValueKind byte
+
+const (
+	ValueKindObject ValueKind = iota // 堆对象, 字符串以及复合类型
+	ValueKindInt                     // 有符号整数, 布尔值以及按位保存的无符号整数
+	ValueKindUint                    // 无符号整数赋值给interface时使用
+	ValueKindFloat
+)
+
+//
+// Value 栈, 静态区以及对象中保存的值
+//
+// 整数, 浮点数, 布尔值直接保存在bits中, 不需要分配对象
+//
+type Value struct {
+	kind ValueKind
+	bits uint64
+	obj  Object
+}
+
+func IntValue(value int64) Value {
+	return Value{kind: ValueKindInt, bits: uint64(value)}
+}
+
+func UintValue(value uint64) Value {
+	return Value{kind: ValueKindUint, bits: value}
+}
+
+func FloatValue(value float64) Value {
+	return Value{kind: ValueKindFloat, bits: math.Float64bits(value)}
+}
+
+func ObjectValue(obj Object) Value {
+	return Value{kind: ValueKindObject, obj: obj}
+}
+
+func StringValue(value string) Value {
+	return ObjectValue(NewObjectString(value))
+}
+
+var NilValue = ObjectValue(NilObject)
+
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+func (v Value) Int() int64 {
+	return int64(v.bits)
+}
+
+func (v Value) Uint() uint64 {
+	return v.bits
+}
+
+func (v Value) Float() float64 {
+	return math.Float64frombits(v.bits)
+}
+
+func (v Value) Object() Object {
+	return v.obj
+}
+
+func (v Value) String() string {
+	return v.obj.(*ObjectString).Value
+}
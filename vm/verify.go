@@ -0,0 +1,279 @@
+package vm
+
+import (
+	"fmt"
+)
+
+//
+// Verify 执行前校验镜像中的字节码
+//
+// 指令必须有效且完整, 操作数(常量, 全局变量, 函数, 局部变量)不能越界,
+// 跳转地址必须是指令的起始位置, 各条路径上的操作数栈深度一致且不会下溢,
+// 函数必须以return结束.
+// 不检查操作数栈上值的类型, 类型不符的字节码在执行时仍可能出错
+//
+func Verify(image *Image) error {
+	nativeList := newNativeFunctionList()
+
+	v := &verifier{
+		image:      image,
+		nativeList: nativeList,
+		funcCount:  len(nativeList) + len(image.FunctionList),
+	}
+
+	if _, err := v.verifyCode(nil, image.CodeList); err != nil {
+		return fmt.Errorf("顶层代码: %w", err)
+	}
+
+	for _, f := range image.FunctionList {
+		if err := v.verifyFunction(f); err != nil {
+			return fmt.Errorf("函数%s.%s: %w", f.PackageName, f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+type verifier struct {
+	image      *Image
+	nativeList []Function
+	funcCount  int
+}
+
+func (v *verifier) verifyFunction(f *GoGoFunction) error {
+	if f.ParamCount < 0 || f.ResultCount < 0 {
+		return newVmError(RESULT_COUNT_ERR, f.ParamCount, f.ResultCount)
+	}
+
+	depth, err := v.verifyCode(f, f.CodeList)
+	if err != nil {
+		return err
+	}
+
+	// 调用时按MaxStackSize扩展栈
+	if size := len(f.VariableList) + depth; f.MaxStackSize < size {
+		return newVmError(STACK_SIZE_ERR, f.MaxStackSize, size)
+	}
+
+	return nil
+}
+
+// 校验一段字节码, 返回操作数栈的最大深度, f为nil时是顶层代码
+func (v *verifier) verifyCode(f *GoGoFunction, codeList []byte) (int, error) {
+	// 先逐条解码, 确认指令和操作数有效, 记录指令的起始位置
+	boundaries := map[int]bool{}
+	jumpList := []int{}
+	last := Instruction{}
+
+	for pc := 0; pc < len(codeList); {
+		inst, err := v.decode(codeList, pc)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := v.checkOperand(f, pc, inst, last); err != nil {
+			return 0, err
+		}
+
+		boundaries[pc] = true
+		if OpcodeInfo[inst.Code].Parameter == "l" {
+			jumpList = append(jumpList, pc)
+		}
+
+		last = inst
+		pc += inst.Size
+	}
+
+	// 顶层代码可以执行到结尾, 函数必须返回
+	// 泛型函数本身没有字节码, 不能被调用, 见checkOperand
+	if f == nil {
+		boundaries[len(codeList)] = true
+	} else if len(codeList) > 0 && last.Code != OP_CODE_RETURN {
+		return 0, newVmError(MISSING_RETURN_ERR)
+	}
+
+	for _, pc := range jumpList {
+		target := DecodeInstruction(codeList, pc).Operands[0]
+		if target < 0 || target > len(codeList) {
+			return 0, newVmError(JUMP_OUT_OF_RANGE_ERR, target)
+		}
+		if !boundaries[target] {
+			return 0, newVmError(JUMP_TARGET_ERR, pc, target)
+		}
+	}
+
+	// 沿控制流检查每条指令执行前的栈深度
+	return walkStackDepth(codeList, func(pc int, inst Instruction, depth int) error {
+		need := stackRead(inst)
+		if inst.Code == OP_CODE_RETURN {
+			if f == nil {
+				return newVmError(UNKNOWN_OPCODE_ERR, pc, inst.Code)
+			}
+			need = f.ResultCount
+		}
+
+		if depth < need {
+			return newVmError(STACK_UNDERFLOW_ERR, pc)
+		}
+		return nil
+	})
+}
+
+// 解码pc处的指令, 指令无效或者不完整时返回错误
+func (v *verifier) decode(codeList []byte, pc int) (Instruction, error) {
+	start := pc
+
+	wide := codeList[pc] == OP_CODE_WIDE
+	if wide {
+		pc++
+		if pc >= len(codeList) {
+			return Instruction{}, newVmError(TRUNCATED_INSTRUCTION_ERR, start)
+		}
+	}
+
+	code := codeList[pc]
+	info, ok := OpcodeInfo[code]
+	if !ok || code == OP_CODE_WIDE {
+		return Instruction{}, newVmError(UNKNOWN_OPCODE_ERR, start, code)
+	}
+	pc++
+
+	size := 0
+	widened := false
+	for _, p := range []byte(info.Parameter) {
+		size += OperandSize(p, wide)
+		widened = widened || p != 'b'
+	}
+
+	// wide前缀只能用于有两个字节操作数的指令
+	if wide && !widened {
+		return Instruction{}, newVmError(UNKNOWN_OPCODE_ERR, start, code)
+	}
+
+	if pc+size > len(codeList) {
+		return Instruction{}, newVmError(TRUNCATED_INSTRUCTION_ERR, start)
+	}
+
+	return DecodeInstruction(codeList, start), nil
+}
+
+// 检查操作数的范围, last为上一条指令
+func (v *verifier) checkOperand(f *GoGoFunction, pc int, inst Instruction, last Instruction) error {
+	if len(inst.Operands) == 0 {
+		return nil
+	}
+
+	operand := inst.Operands[0]
+	ok := true
+
+	switch inst.Code {
+	case OP_CODE_PUSH_INT, OP_CODE_PUSH_FLOAT, OP_CODE_PUSH_STRING, OP_CODE_NEW_INTERFACE:
+		ok = v.checkConstant(inst.Code, operand)
+	case OP_CODE_PUSH_STATIC, OP_CODE_POP_STATIC:
+		ok = operand >= 0 && operand < len(v.image.VariableList)
+	case OP_CODE_PUSH_STACK, OP_CODE_POP_STACK:
+		// 形参在返回信息之前, 局部变量在之后, 顶层代码没有局部变量
+		ok = f != nil &&
+			((operand < 0 && operand >= -f.ParamCount) || (operand > 0 && operand <= len(f.VariableList)))
+	case OP_CODE_PUSH_FUNCTION:
+		ok = operand >= 0 && operand < v.funcCount
+		if f := v.userFunction(operand); ok && f != nil {
+			ok = len(f.CodeList) > 0
+		}
+	case OP_CODE_INVOKE:
+		ok = operand >= 0
+		// 直接调用的函数, 实参数量必须与形参一致
+		if ok && last.Code == OP_CODE_PUSH_FUNCTION {
+			ok = operand == v.paramCount(last.Operands[0])
+		}
+	case OP_CODE_NEW_ARRAY, OP_CODE_NEW_STRUCT, OP_CDOE_NEW_MAP, OP_CODE_DUPLICATE_OFFSET:
+		ok = operand >= 0
+	}
+
+	if !ok {
+		return newVmError(OPERAND_OUT_OF_RANGE_ERR, pc, OpcodeInfo[inst.Code].Mnemonic, operand)
+	}
+
+	return nil
+}
+
+// 函数下标对应的形参数量, 下标越界时返回-1
+func (v *verifier) paramCount(index int) int {
+	if index >= 0 && index < len(v.nativeList) {
+		return v.nativeList[index].(*GoGoNativeFunction).ParamCount
+	}
+
+	if f := v.userFunction(index); f != nil {
+		return f.ParamCount
+	}
+
+	return -1
+}
+
+// 函数下标对应的用户函数, 原生函数返回nil
+func (v *verifier) userFunction(index int) *GoGoFunction {
+	index -= v.funcCount - len(v.image.FunctionList)
+	if index < 0 || index >= len(v.image.FunctionList) {
+		return nil
+	}
+
+	return v.image.FunctionList[index]
+}
+
+// 常量池下标不能越界, 常量的类型必须与指令一致
+func (v *verifier) checkConstant(code byte, index int) bool {
+	if index < 0 || index >= len(v.image.ConstantList) {
+		return false
+	}
+
+	c := v.image.ConstantList[index]
+
+	switch code {
+	case OP_CODE_PUSH_INT:
+		_, ok := c.(int64)
+		return ok
+	case OP_CODE_PUSH_FLOAT:
+		_, ok := c.(float64)
+		return ok
+	case OP_CODE_PUSH_STRING:
+		switch c := c.(type) {
+		case string:
+			return true
+		case Value:
+			_, ok := c.obj.(*ObjectString)
+			return ok
+		}
+		return false
+	case OP_CODE_NEW_INTERFACE:
+		_, ok := c.(RuntimeType)
+		return ok
+	}
+
+	return false
+}
+
+// 指令执行时读取的栈顶元素数量, 栈深度不能小于该值
+func stackRead(inst Instruction) int {
+	switch inst.Code {
+	case OP_CODE_PUSH_INT_1BYTE, OP_CODE_PUSH_INT_2BYTE, OP_CODE_PUSH_INT,
+		OP_CODE_PUSH_FLOAT_0, OP_CODE_PUSH_FLOAT_1, OP_CODE_PUSH_FLOAT,
+		OP_CODE_PUSH_STRING, OP_CODE_PUSH_NIL,
+		OP_CODE_PUSH_STACK, OP_CODE_PUSH_STATIC, OP_CODE_PUSH_FUNCTION,
+		OP_CODE_JUMP, OP_CODE_RETURN:
+		return 0
+	case OP_CODE_DUPLICATE, OP_CODE_ITERATE:
+		return 1
+	case OP_CODE_DUPLICATE_OFFSET:
+		return inst.Operands[0] + 1
+	case OP_CODE_PUSH_MAP, OP_CODE_PUSH_MAP_OK:
+		// 默认值, map, key
+		return 3
+	case OP_CODE_POP_STACK, OP_CODE_POP_STATIC, OP_CODE_POP,
+		OP_CODE_POP_ARRAY, OP_CODE_POP_MAP, OP_CODE_POP_STRUCT, OP_CODE_POP_INTERFACE,
+		OP_CODE_JUMP_IF_TRUE, OP_CODE_JUMP_IF_FALSE, OP_CODE_INVOKE:
+		return -StackEffect(inst)
+	}
+
+	// 其余指令弹出操作数后压入一个结果
+	return 1 - StackEffect(inst)
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/lth-go/gogo/compiler"
@@ -11,7 +17,7 @@ import (
 var testFile = "test/test.gogo"
 
 func TestVmMachine(t *testing.T) {
-	os.Setenv("IMPORT_SEARCH_PATH", "./test")
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
 
 	cm := compiler.NewCompilerManager()
 
@@ -25,3 +31,896 @@ func TestVmMachine(t *testing.T) {
 	)
 	VM.Execute()
 }
+
+// 编译出错时返回错误信息
+func getCompileError(path string, searchPath string) (msg string) {
+	os.Setenv("IMPORT_SEARCH_PATH", searchPath)
+
+	defer func() {
+		msg, _ = recover().(string)
+	}()
+
+	cm := compiler.NewCompilerManager()
+
+	cm.CompileFile(path)
+
+	return ""
+}
+
+func TestImportCycle(t *testing.T) {
+	msg := getCompileError("test/cycle/main.gogo", "./test/cycle")
+
+	for _, want := range []string{
+		"a -> b -> c -> a",
+		`test/cycle/a.gogo:3:1: import "b"`,
+		`test/cycle/b.gogo:3:1: import "c"`,
+		`test/cycle/c.gogo:3:1: import "a"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("import cycle error %q does not contain %q", msg, want)
+		}
+	}
+}
+
+func TestPackageNameMismatch(t *testing.T) {
+	msg := getCompileError("test/mismatch/main.gogo", "./test/mismatch")
+
+	want := "包名other与同一目录下其它文件的包名pkg不一致"
+	if !strings.Contains(msg, want) {
+		t.Errorf("package name mismatch error %q does not contain %q", msg, want)
+	}
+}
+
+// 同一个目录以不同的写法导入, 只有一个包
+func TestImportSameDirectory(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test/samedir")
+
+	got := runFile(t, "test/samedir/main.gogo")
+
+	want := "1 2\n"
+	if got != want {
+		t.Errorf("same directory import output %q, want %q", got, want)
+	}
+}
+
+func TestImportNameCollision(t *testing.T) {
+	msg := getCompileError("test/collision/main.gogo", "./test:./test/third_party")
+
+	want := `包名proto重复, 同时指向了"company/net/proto"和"legacy/proto"`
+	if !strings.Contains(msg, want) {
+		t.Errorf("import name collision error %q does not contain %q", msg, want)
+	}
+}
+
+// 包名只在导入它的源文件中有效
+func TestFileScopedImport(t *testing.T) {
+	msg := getCompileError("test/fileimport/main.gogo", "./test/fileimport")
+
+	want := "找不到变量或函数(runtime)"
+	if !strings.Contains(msg, want) {
+		t.Errorf("file scoped import error %q does not contain %q", msg, want)
+	}
+}
+
+func TestUnexportedName(t *testing.T) {
+	for file, want := range map[string]string{
+		"func.gogo":  "lib.helper未导出",
+		"var.gogo":   "lib.count未导出",
+		"type.gogo":  "lib.celsius未导出",
+		"conv.gogo":  "lib.celsius未导出",
+		"field.gogo": "Point.y未导出",
+	} {
+		msg := getCompileError("test/export/"+file, "./test/export")
+		if !strings.Contains(msg, want) {
+			t.Errorf("%s: unexported name error %q does not contain %q", file, msg, want)
+		}
+	}
+}
+
+func TestInitialization(t *testing.T) {
+	for file, want := range map[string]string{
+		"cycle.gogo": "全局变量初始化循环: a -> f -> b -> a",
+		"init.gogo":  "init函数不能有参数和返回值",
+	} {
+		msg := getCompileError("test/initcycle/"+file, ".")
+		if !strings.Contains(msg, want) {
+			t.Errorf("%s: initialization error %q does not contain %q", file, msg, want)
+		}
+	}
+}
+
+func TestComparability(t *testing.T) {
+	for file, want := range map[string]string{
+		"slice.gogo":  "[]int类型的值只能与nil比较",
+		"struct.gogo": "pair类型的值不可比较",
+		"order.gogo":  "interface{}类型的值不能比较大小",
+		"mapkey.gogo": "map的键类型[]int不可比较",
+	} {
+		msg := getCompileError("test/compare/"+file, ".")
+		if !strings.Contains(msg, want) {
+			t.Errorf("%s: comparability error %q does not contain %q", file, msg, want)
+		}
+	}
+}
+
+func TestGenericErrors(t *testing.T) {
+	for file, want := range map[string]string{
+		"value.gogo": "泛型函数Max未实例化, 不能作为值使用",
+	} {
+		msg := getCompileError("test/generic/"+file, ".")
+		if !strings.Contains(msg, want) {
+			t.Errorf("%s: generic error %q does not contain %q", file, msg, want)
+		}
+	}
+}
+
+// 泛型类型实例与普通的自定义类型一样赋值和转换
+func TestGenericNamedType(t *testing.T) {
+	source := `package main;
+
+type List[T any] []T;
+
+type Pair[K comparable, V any] struct {
+    Key K;
+    Value V;
+};
+
+func main() {
+    var l List[int] = []int{1, 2};
+    var x []int = []int{3, 4, 5};
+    var m List[int] = List[int](x);
+    var p Pair[string, int];
+    p.Key = "a";
+    printf("%v %v %v %v\n", len(l), len(m), m[2], p.Key);
+};
+`
+
+	path := filepath.Join(t.TempDir(), "generic.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runFile(t, path)
+
+	want := "2 3 5 a\n"
+	if got != want {
+		t.Errorf("generic named type output %q, want %q", got, want)
+	}
+}
+
+// 编译并执行, 返回标准输出
+func runFile(t *testing.T, path string) string {
+	cm := compiler.NewCompilerManager()
+
+	cm.CompileFile(path)
+
+	return runImage(t, cm.GetImage())
+}
+
+// 执行字节码镜像, 返回标准输出
+func runImage(t *testing.T, image *vm.Image) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	output := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		output <- string(b)
+	}()
+
+	VM, err := vm.NewVirtualMachineFromImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	VM.Execute()
+
+	w.Close()
+
+	return <-output
+}
+
+// 常量池下标, 本地变量位置, 跳转地址超出两个字节时使用wide前缀
+func TestWideOperand(t *testing.T) {
+	const n = 40000
+
+	var sb strings.Builder
+
+	sb.WriteString("package main;\n\nfunc main() {\n")
+	sb.WriteString("    var ok bool = true;\n    var s string;\n    var i int;\n    var sum int = 0;\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "    var v%d int = %d;\n", i, n+i)
+	}
+	sb.WriteString("    if ok {\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "        s = \"s%d\";\n", i)
+	}
+	sb.WriteString("    };\n")
+	sb.WriteString("    for i = 0; i < 3; i = i + 1 {\n        sum = sum + v39999;\n    };\n")
+	sb.WriteString("    printf(\"%v %v\\n\", s, sum);\n};\n")
+
+	path := filepath.Join(t.TempDir(), "wide.gogo")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runFile(t, path)
+
+	want := "s39999 239997\n"
+	if got != want {
+		t.Errorf("wide operand output %q, want %q", got, want)
+	}
+}
+
+// 编译时计算操作数栈的最大深度, 递归调用时按需扩展栈
+func TestMaxStackDepth(t *testing.T) {
+	source := `package main;
+
+func calc(a int, b int) int {
+    return a + b * (a - b);
+};
+
+func sum(n int) int {
+    if n == 0 {
+        return 0;
+    };
+    var m int = n - 1;
+    return n + sum(m);
+};
+
+func main() {
+    var total int = 0;
+    for _, v := range []int{1, 2, 3} {
+        total = total + calc(v, 2);
+    };
+    printf("%v %v\n", total, sum(20000));
+};
+`
+
+	path := filepath.Join(t.TempDir(), "depth.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runFile(t, path)
+
+	want := "6 200010000\n"
+	if got != want {
+		t.Errorf("max stack depth output %q, want %q", got, want)
+	}
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(path)
+
+	for _, fd := range cm.FuncList {
+		if fd.Name == "calc" && fd.MaxStackDepth != 4 {
+			t.Errorf("calc max stack depth %d, want 4", fd.MaxStackDepth)
+		}
+	}
+
+	if _, err := vm.MaxStackDepth([]byte{
+		vm.OP_CODE_PUSH_INT_1BYTE, 1,
+		vm.OP_CODE_JUMP_IF_FALSE, 0, 7,
+		vm.OP_CODE_PUSH_NIL,
+		vm.OP_CODE_POP,
+		vm.OP_CODE_PUSH_NIL,
+	}); err != nil {
+		t.Errorf("unexpected stack depth error: %v", err)
+	}
+
+	if _, err := vm.MaxStackDepth([]byte{
+		vm.OP_CODE_PUSH_INT_1BYTE, 1,
+		vm.OP_CODE_JUMP_IF_FALSE, 0, 6,
+		vm.OP_CODE_PUSH_NIL,
+		vm.OP_CODE_PUSH_NIL,
+	}); err == nil {
+		t.Error("expected stack depth mismatch error")
+	}
+
+	// 执行到结尾时的栈深度也计入
+	if depth, err := vm.MaxStackDepth([]byte{vm.OP_CODE_PUSH_NIL, vm.OP_CODE_PUSH_NIL}); err != nil || depth != 2 {
+		t.Errorf("max stack depth at end of code %d, %v, want 2", depth, err)
+	}
+}
+
+// float32按32位精度输出
+func TestFloat32Format(t *testing.T) {
+	source := `package main;
+
+func main() {
+    var f float32 = 0.1;
+    var g float64 = 0.1;
+    printf("%v %v %v\n", f + 0.2, g + 0.2, []float32{f}[0]);
+};
+`
+
+	path := filepath.Join(t.TempDir(), "float32.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runFile(t, path)
+
+	want := "0.3 0.30000000000000004 0.1\n"
+	if got != want {
+		t.Errorf("float32 output %q, want %q", got, want)
+	}
+}
+
+// 每次分配对象时都完整gc, 或者推进增量gc, 输出应与正常执行时一致
+func TestGCStress(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
+
+	want := runFile(t, testFile)
+
+	defer os.Unsetenv("GOGO_GC_STRESS")
+
+	for _, mode := range []string{"1", "incremental"} {
+		os.Setenv("GOGO_GC_STRESS", mode)
+
+		got := runFile(t, testFile)
+		if got != want {
+			t.Errorf("gc stress (%s) output differs from normal output", mode)
+		}
+	}
+}
+
+// 分配的字节数超过阈值时gc, 存活的对象不受影响
+func TestGCThreshold(t *testing.T) {
+	source := `package main;
+
+func main() {
+    var keep []string = []string{};
+    var total int = 0;
+    var i int;
+    for i = 0; i < 100000; i = i + 1 {
+        var s string = "garbage-" + string([]byte("0123456789abcdef0123456789abcdef"));
+        total = total + len(s);
+        if i / 1000 * 1000 == i {
+            keep = append(keep, s + "!");
+        };
+    };
+    printf("%v %v %v\n", total, len(keep), keep[99]);
+};
+`
+
+	path := filepath.Join(t.TempDir(), "gc.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runFile(t, path)
+
+	want := "4000000 100 garbage-0123456789abcdef0123456789abcdef!\n"
+	if got != want {
+		t.Errorf("gc threshold output %q, want %q", got, want)
+	}
+}
+
+// 增量标记过程中在容器之间移动对象, 写屏障保证对象不会被漏标
+func TestGCWriteBarrier(t *testing.T) {
+	source := `package main;
+
+var global [][]int;
+
+func main() {
+    var list [][]int = [][]int{};
+    var holder struct {
+        List [][]int;
+    } = struct {
+        List [][]int;
+    }{
+        List: [][]int{},
+    };
+    var m map[int][]int = map[int][]int{};
+    var i int;
+    var j int;
+    var tmp []int;
+    for i = 0; i < 50; i = i + 1 {
+        list = append(list, []int{i, 1});
+        holder.List = append(holder.List, nil);
+    };
+    global = [][]int{};
+    for i = 0; i < 10; i = i + 1 {
+        for j = 0; j < 50; j = j + 1 {
+            tmp = list[j];
+            list[j] = nil;
+            m[j] = tmp;
+            tmp = nil;
+            var garbage []int = []int{i, j};
+        };
+        for j = 0; j < 50; j = j + 1 {
+            tmp = m[j];
+            delete(m, j);
+            holder.List[j] = tmp;
+            tmp = nil;
+            var garbage []int = []int{i, j};
+        };
+        for j = 0; j < 50; j = j + 1 {
+            global = append(global, holder.List[j]);
+            holder.List[j] = nil;
+            var garbage []int = []int{i, j};
+        };
+        for j = 0; j < 50; j = j + 1 {
+            list[j] = global[j];
+            var garbage []int = []int{i, j};
+        };
+        global = nil;
+    };
+    var sum int = 0;
+    for j = 0; j < 50; j = j + 1 {
+        sum = sum + list[j][0] + list[j][1];
+    };
+    printf("%v\n", sum);
+};
+`
+
+	path := filepath.Join(t.TempDir(), "barrier.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GOGO_GC_STRESS", "incremental")
+	defer os.Unsetenv("GOGO_GC_STRESS")
+
+	got := runFile(t, path)
+
+	want := "1275\n"
+	if got != want {
+		t.Errorf("write barrier output %q, want %q", got, want)
+	}
+}
+
+// 执行结束后从宿主读取统计信息
+func TestStats(t *testing.T) {
+	source := `package main;
+
+import "runtime";
+
+var keep []map[int]int;
+
+func main() {
+    var i int;
+    for i = 0; i < 10; i = i + 1 {
+        keep = append(keep, map[int]int{i: i});
+    };
+    runtime.GC();
+
+    var garbage map[int]int;
+    for i = 0; i < 100; i = i + 1 {
+        garbage = map[int]int{i: i};
+    };
+};
+`
+
+	path := filepath.Join(t.TempDir(), "stats.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(path)
+
+	VM := vm.NewVirtualMachine(
+		cm.ConstantList,
+		cm.GetVmVariableList(),
+		cm.GetVmFunctionList(),
+		cm.CodeList,
+	)
+	VM.Execute()
+
+	stats := VM.Stats()
+	if stats.ObjectCounts["map"] < 10 {
+		t.Errorf("map count %d, want at least 10", stats.ObjectCounts["map"])
+	}
+	if stats.NumGC < 1 {
+		t.Errorf("NumGC %d, want at least 1", stats.NumGC)
+	}
+	if stats.HeapObjects == 0 || stats.HeapAlloc == 0 || stats.MaxStackDepth == 0 {
+		t.Errorf("unexpected empty stats %+v", stats)
+	}
+
+	// HeapAlloc不包括上一轮gc之后分配的垃圾
+	VM.GC()
+	if live := VM.Stats().HeapAlloc; stats.HeapAlloc != live {
+		t.Errorf("HeapAlloc %d, want live bytes %d", stats.HeapAlloc, live)
+	}
+}
+
+// 保存为字节码文件后再读取执行, 输出与直接执行一致
+func TestImage(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(testFile)
+	image := cm.GetImage()
+
+	var buf bytes.Buffer
+	if err := vm.WriteImage(&buf, image); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	loaded, err := vm.ReadImage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := runImage(t, image)
+	got := runImage(t, loaded)
+	if got != want {
+		t.Errorf("image output differs from source output")
+	}
+
+	for name, b := range map[string][]byte{
+		"truncated": data[:len(data)/2],
+		"magic":     append([]byte("GOGX"), data[4:]...),
+		"version":   append([]byte("GOGC\x63"), data[5:]...),
+	} {
+		if _, err := vm.ReadImage(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s image: expected error", name)
+		}
+	}
+
+	// 原生函数表与虚拟机不一致时, 函数下标会指向错误的函数
+	b := bytes.Replace(data, []byte("printf"), []byte("printg"), 1)
+	if _, err := vm.ReadImage(bytes.NewReader(b)); err == nil || !strings.Contains(err.Error(), "原生函数_sys.printg") {
+		t.Errorf("native function mismatch: error %v", err)
+	}
+}
+
+// 损坏的字节码文件返回错误, 不能panic
+func TestImageCorrupt(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(testFile)
+
+	var buf bytes.Buffer
+	if err := vm.WriteImage(&buf, cm.GetImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	readImage := func(b []byte) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				t.Errorf("read corrupted image: %v", r)
+			}
+		}()
+		_, err = vm.ReadImage(bytes.NewReader(b))
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		b := append([]byte{}, data...)
+		for j := 0; j < 1+rnd.Intn(4); j++ {
+			b[4+rnd.Intn(len(b)-4)] = byte(rnd.Intn(256))
+		}
+		readImage(b)
+	}
+
+	// 全局变量map(int 5: int 5)的键改为切片array(int 5)
+	m := vm.NewObjectMap()
+	m.Set(vm.IntValue(5), vm.IntValue(5))
+	image := &vm.Image{
+		VariableList: []vm.Value{vm.ObjectValue(m)},
+		CodeList:     []byte{vm.OP_CODE_PUSH_NIL, vm.OP_CODE_POP},
+	}
+	buf.Reset()
+	if err := vm.WriteImage(&buf, image); err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.Replace(buf.Bytes(), []byte{9, 1, 1, 10}, []byte{9, 1, 8, 1, 1, 10}, 1)
+	if err := readImage(b); err == nil {
+		t.Errorf("unhashable map key: expected error")
+	}
+
+	// 调用信息不能保存
+	image.VariableList = []vm.Value{vm.ObjectValue(&vm.ObjectCallInfo{})}
+	if err := vm.WriteImage(io.Discard, image); err == nil {
+		t.Errorf("write call info: expected error")
+	}
+}
+
+// 反汇编显示函数信息, 常量, 调用的函数名, 变量名, 跳转label和源代码行号
+func TestDisassemble(t *testing.T) {
+	source := `package main;
+
+func add(a int, b int) int {
+    var sum int = a + b;
+    if sum > 40000 {
+        sum = 0;
+    };
+    return sum;
+};
+
+func main() {
+    printf("%v\n", add(1, 2));
+};
+`
+
+	path := filepath.Join(t.TempDir(), "disasm.gogo")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(path)
+
+	var buf bytes.Buffer
+	if err := vm.Disassemble(&buf, cm.GetImage()); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		".func main add params 2 results 1 stack 3",
+		"; index 9\n",
+		"    .param a\n    .param b\n",
+		"    .local sum zero\n",
+		"push_stack               -2                ; a\n",
+		"pop_stack                1                 ; sum\n",
+		"; 40000\n",
+		"jump_if_false            L0\n",
+		"L0:\n    0028  push_stack",
+		"; \"%v\\n\"\n",
+		"; main.add\n",
+		"; _sys.printf\n",
+		"    .line 12\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("disassembly missing %q\n%s", want, got)
+		}
+	}
+}
+
+// 校验字节码: 编译器生成的镜像通过校验, 损坏的字节码在执行前报错
+func TestVerify(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(testFile)
+	if err := vm.Verify(cm.GetImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户函数的下标在原生函数之后
+	nativeCount := 0
+	for _, f := range cm.FuncList {
+		if f.IsNative() {
+			nativeCount++
+		}
+	}
+
+	// 返回两个参数之和的函数
+	newImage := func() *vm.Image {
+		return &vm.Image{
+			ConstantList: []interface{}{int64(40000), "s"},
+			VariableList: []vm.Value{{}},
+			FunctionList: []*vm.GoGoFunction{{
+				PackageName:  "main",
+				Name:         "add",
+				ParamCount:   2,
+				ResultCount:  1,
+				VariableList: []vm.Value{{}},
+				MaxStackSize: 3,
+				CodeList: []byte{
+					vm.OP_CODE_PUSH_STACK, 0xff, 0xfe,
+					vm.OP_CODE_PUSH_STACK, 0xff, 0xff,
+					vm.OP_CODE_ADD_INT,
+					vm.OP_CODE_POP_STACK, 0, 1,
+					vm.OP_CODE_PUSH_STACK, 0, 1,
+					vm.OP_CODE_RETURN,
+				},
+			}},
+			CodeList: []byte{
+				vm.OP_CODE_PUSH_NIL,
+				vm.OP_CODE_PUSH_INT, 0, 0,
+				vm.OP_CODE_PUSH_INT_1BYTE, 1,
+				vm.OP_CODE_PUSH_FUNCTION, 0, byte(nativeCount),
+				vm.OP_CODE_INVOKE, 0, 2,
+				vm.OP_CODE_POP_STATIC, 0, 0,
+				vm.OP_CODE_JUMP, 0, 18,
+			},
+		}
+	}
+
+	if err := vm.Verify(newImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 原生函数printf需要两个参数
+	printfNoArgs := []byte{vm.OP_CODE_PUSH_NIL, vm.OP_CODE_PUSH_FUNCTION, 0, 0, vm.OP_CODE_INVOKE, 0, 0}
+
+	for name, corrupt := range map[string]func(image *vm.Image){
+		"unknown opcode":     func(image *vm.Image) { image.CodeList[0] = 0xee },
+		"truncated":          func(image *vm.Image) { image.CodeList = image.CodeList[:len(image.CodeList)-1] },
+		"constant index":     func(image *vm.Image) { image.CodeList[3] = 2 },
+		"constant type":      func(image *vm.Image) { image.CodeList[3] = 1 },
+		"static index":       func(image *vm.Image) { image.CodeList[14] = 1 },
+		"function index":     func(image *vm.Image) { image.CodeList[8] = byte(nativeCount + 1) },
+		"argument count":     func(image *vm.Image) { image.CodeList[11] = 1 },
+		"native arguments":   func(image *vm.Image) { image.CodeList = printfNoArgs },
+		"jump target":        func(image *vm.Image) { image.CodeList[17] = 2 },
+		"jump out of range":  func(image *vm.Image) { image.CodeList[17] = 19 },
+		"underflow":          func(image *vm.Image) { image.CodeList[0] = vm.OP_CODE_POP },
+		"local index":        func(image *vm.Image) { image.FunctionList[0].CodeList[9] = 2 },
+		"param index":        func(image *vm.Image) { image.FunctionList[0].CodeList[2] = 0xfd },
+		"missing return":     func(image *vm.Image) { image.FunctionList[0].CodeList[13] = vm.OP_CODE_POP },
+		"return underflow":   func(image *vm.Image) { image.FunctionList[0].ResultCount = 2 },
+		"stack size":         func(image *vm.Image) { image.FunctionList[0].MaxStackSize = 2 },
+		"negative count":     func(image *vm.Image) { image.FunctionList[0].ResultCount = -1 },
+		"depth mismatch":     func(image *vm.Image) { image.CodeList[17] = 1 },
+		"wide without short": func(image *vm.Image) { image.CodeList = append([]byte{vm.OP_CODE_WIDE}, image.CodeList...) },
+	} {
+		image := newImage()
+		corrupt(image)
+		if err := vm.Verify(image); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// 创建虚拟机时校验
+	var buf bytes.Buffer
+	image := newImage()
+	image.FunctionList[0].MaxStackSize = 2
+	if _, err := vm.NewVirtualMachineFromImage(image); err == nil {
+		t.Errorf("new virtual machine from unverifiable image: expected error")
+	}
+
+	// 读取字节码文件时校验
+	if err := vm.WriteImage(&buf, image); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.ReadImage(&buf); err == nil {
+		t.Errorf("read unverifiable image: expected error")
+	}
+}
+
+// 反汇编的输出重新汇编后与原镜像一致, 手写的汇编可以直接执行
+func TestAssemble(t *testing.T) {
+	os.Setenv("IMPORT_SEARCH_PATH", "./test:./test/third_party")
+
+	cm := compiler.NewCompilerManager()
+	cm.CompileFile(testFile)
+	image := cm.GetImage()
+
+	var text bytes.Buffer
+	if err := vm.Disassemble(&text, image); err != nil {
+		t.Fatal(err)
+	}
+
+	assembled, err := vm.Assemble(bytes.NewReader(text.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var again bytes.Buffer
+	if err := vm.Disassemble(&again, assembled); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != text.String() {
+		t.Errorf("disassembly of assembled image differs from original disassembly")
+	}
+
+	// 行号为0的指令汇编后沿用上一个行号, 比较时忽略行号表
+	for _, image := range []*vm.Image{image, assembled} {
+		image.LineNumberList = nil
+		for _, f := range image.FunctionList {
+			f.LineNumberList = nil
+		}
+	}
+
+	var want, got bytes.Buffer
+	if err := vm.WriteImage(&want, image); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.WriteImage(&got, assembled); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("assembled image differs from compiled image")
+	}
+
+	// 计算1到n的和, 跳过的指令超出两个字节时跳转使用wide前缀
+	var sb strings.Builder
+	sb.WriteString(`
+.const format string "%v\n"
+.const int type "int" comparable
+
+.entry
+    push_function main.main
+    invoke 0
+
+.func main sum params 1 results 1
+    .param n
+    .local total int 0
+loop:
+    push_stack n
+    push_int_1byte 0
+    gt_int
+    jump_if_false done
+    push_stack total
+    push_stack n
+    add_int
+    pop_stack total
+    push_stack n
+    push_int_1byte 1
+    sub_int
+    pop_stack n
+    jump loop
+skip:
+`)
+	for i := 0; i < 20000; i++ {
+		sb.WriteString("    push_nil\n    pop\n")
+	}
+	sb.WriteString(`
+done:
+    push_stack total
+    return
+
+.func main main
+    push_string format
+    push_nil
+    push_int_2byte 10000
+    push_function main.sum
+    invoke 1
+    new_interface int
+    new_array 1
+    push_function _sys.printf
+    invoke 2
+    return
+`)
+
+	hand, err := vm.Assemble(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Verify(hand); err != nil {
+		t.Fatal(err)
+	}
+	if output := runImage(t, hand); output != "50005000\n" {
+		t.Errorf("assembled program output %q, want %q", output, "50005000\n")
+	}
+
+	text.Reset()
+	if err := vm.Disassemble(&text, hand); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "wide jump_if_false") {
+		t.Errorf("far jump is not wide")
+	}
+
+	// 不能反汇编的值返回错误
+	hand.VariableList = append(hand.VariableList, vm.ObjectValue(&vm.ObjectCallInfo{}))
+	if err := vm.Disassemble(io.Discard, hand); err == nil {
+		t.Errorf("disassemble call info: expected error")
+	}
+
+	for source, want := range map[string]string{
+		".entry\n    jump nowhere\n":       "第2行: 未定义的label nowhere",
+		".entry\n    frob\n":               "第2行: 语法错误, 未知的指令frob",
+		".entry\n    pop 1\n":              "第2行: 语法错误, 多余的1",
+		".entry\n    push_int c0\n":        "第2行: 未定义的常量 c0",
+		"\n    pop\n":                      "第2行: 语法错误, 指令不在.entry或.func中",
+		".const c int 1\n.const c int 2\n": "第2行: 重复定义了常量 c",
+		".func main f\n.func main f\n.entry\n    push_function main.f\n": "第4行: 函数 main.f有多个定义",
+		".func main f\n    .local x map(int 1 int 2)\n":                  "第2行: 语法错误, 缺少:",
+		".entry\n.global map(array(int 1): int 2)\n":                     "第2行: 不可比较的类型slice不能作为map的键",
+	} {
+		_, err := vm.Assemble(strings.NewReader(source))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("assemble %q: error %v, want %q", source, err, want)
+		}
+	}
+}